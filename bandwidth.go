@@ -0,0 +1,274 @@
+package wiiudownloader
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Reads are split into chunks of at most this size while a limit is active,
+	// so that a single read never waits for long.
+	maxLimitedReadSize = 32 << 10
+	minLimitedReadSize = 1 << 10
+)
+
+// BandwidthRule overrides the bandwidth limit during a daily time window.
+// Start and End are offsets from local midnight; a window whose End is before
+// its Start wraps around midnight, and Start == End covers the whole day.
+// A Limit of 0 means unlimited.
+type BandwidthRule struct {
+	Start time.Duration
+	End   time.Duration
+	Limit int64
+}
+
+func (r BandwidthRule) matches(t time.Time) bool {
+	offset := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+	switch {
+	case r.Start == r.End:
+		return true
+	case r.Start < r.End:
+		return offset >= r.Start && offset < r.End
+	default:
+		return offset >= r.Start || offset < r.End
+	}
+}
+
+func (r BandwidthRule) String() string {
+	return fmt.Sprintf("%s-%s=%s", formatClock(r.Start), formatClock(r.End), FormatBandwidthLimit(r.Limit))
+}
+
+// bandwidthLimiter is a token bucket shared by every download.
+type bandwidthLimiter struct {
+	mutex    sync.Mutex
+	limit    int64
+	schedule []BandwidthRule
+	tokens   float64
+	last     time.Time
+}
+
+var globalBandwidthLimiter = &bandwidthLimiter{}
+
+// SetBandwidthLimit caps the combined download speed of all transfers in bytes
+// per second. 0 removes the limit. Running downloads pick up the change
+// immediately.
+func SetBandwidthLimit(bytesPerSecond int64) {
+	globalBandwidthLimiter.mutex.Lock()
+	defer globalBandwidthLimiter.mutex.Unlock()
+	if bytesPerSecond < 0 {
+		bytesPerSecond = 0
+	}
+	globalBandwidthLimiter.limit = bytesPerSecond
+}
+
+// BandwidthLimit returns the limit set with SetBandwidthLimit.
+func BandwidthLimit() int64 {
+	globalBandwidthLimiter.mutex.Lock()
+	defer globalBandwidthLimiter.mutex.Unlock()
+	return globalBandwidthLimiter.limit
+}
+
+// SetBandwidthSchedule sets the daily windows that override the bandwidth
+// limit. The first rule matching the local time wins; outside every window
+// the limit from SetBandwidthLimit applies.
+func SetBandwidthSchedule(rules []BandwidthRule) {
+	globalBandwidthLimiter.mutex.Lock()
+	defer globalBandwidthLimiter.mutex.Unlock()
+	globalBandwidthLimiter.schedule = append([]BandwidthRule(nil), rules...)
+}
+
+// BandwidthSchedule returns the rules set with SetBandwidthSchedule.
+func BandwidthSchedule() []BandwidthRule {
+	globalBandwidthLimiter.mutex.Lock()
+	defer globalBandwidthLimiter.mutex.Unlock()
+	return append([]BandwidthRule(nil), globalBandwidthLimiter.schedule...)
+}
+
+// CurrentBandwidthLimit returns the limit in effect right now, taking the
+// schedule into account.
+func CurrentBandwidthLimit() int64 {
+	globalBandwidthLimiter.mutex.Lock()
+	defer globalBandwidthLimiter.mutex.Unlock()
+	return globalBandwidthLimiter.currentLimitLocked(time.Now())
+}
+
+func (l *bandwidthLimiter) currentLimitLocked(now time.Time) int64 {
+	for _, rule := range l.schedule {
+		if rule.matches(now) {
+			return rule.Limit
+		}
+	}
+	return l.limit
+}
+
+// readSize shrinks a read buffer so a limited read does not take much longer
+// than a second.
+func (l *bandwidthLimiter) readSize(size int) int {
+	l.mutex.Lock()
+	limit := l.currentLimitLocked(time.Now())
+	l.mutex.Unlock()
+	if limit <= 0 {
+		return size
+	}
+	maxSize := int64(maxLimitedReadSize)
+	if limit < maxSize {
+		maxSize = max(limit, minLimitedReadSize)
+	}
+	return int(min(int64(size), maxSize))
+}
+
+// wait takes n tokens from the bucket, sleeping until they are available.
+func (l *bandwidthLimiter) wait(ctx context.Context, n int) error {
+	l.mutex.Lock()
+	now := time.Now()
+	limit := l.currentLimitLocked(now)
+	if limit <= 0 {
+		l.tokens = 0
+		l.last = now
+		l.mutex.Unlock()
+		return nil
+	}
+	burst := float64(max(limit/4, maxLimitedReadSize))
+	if !l.last.IsZero() {
+		l.tokens += now.Sub(l.last).Seconds() * float64(limit)
+	}
+	l.tokens = min(l.tokens, burst)
+	l.last = now
+	l.tokens -= float64(n)
+	delay := time.Duration(0)
+	if l.tokens < 0 {
+		delay = time.Duration(-l.tokens / float64(limit) * float64(time.Second))
+	}
+	l.mutex.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// refund returns tokens that were reserved for a read that came up short.
+func (l *bandwidthLimiter) refund(n int) {
+	if n <= 0 {
+		return
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.tokens += float64(n)
+}
+
+// ParseBandwidthLimit parses a rate such as "500K", "2MB/s" or "1500000".
+// Units are decimal (K = 1000) and a plain number is bytes per second.
+// "0", "off" and "unlimited" mean no limit.
+func ParseBandwidthLimit(limit string) (int64, error) {
+	text := strings.ToUpper(strings.TrimSpace(limit))
+	switch text {
+	case "", "0", "OFF", "UNLIMITED":
+		return 0, nil
+	}
+	text = strings.TrimSuffix(text, "/S")
+	text = strings.TrimSuffix(text, "B")
+	multiplier := int64(1)
+	switch {
+	case strings.HasSuffix(text, "K"):
+		multiplier = 1000
+	case strings.HasSuffix(text, "M"):
+		multiplier = 1000 * 1000
+	case strings.HasSuffix(text, "G"):
+		multiplier = 1000 * 1000 * 1000
+	}
+	if multiplier != 1 {
+		text = text[:len(text)-1]
+	}
+	value, err := strconv.ParseFloat(strings.TrimSpace(text), 64)
+	// ParseFloat accepts NaN and infinities, and the rate must fit in an
+	// int64, whose maximum rounds up to 2^63 as a float64.
+	rate := value * float64(multiplier)
+	if err != nil || math.IsNaN(value) || value < 0 || rate >= math.MaxInt64 {
+		return 0, fmt.Errorf("invalid bandwidth limit %q", strings.TrimSpace(limit))
+	}
+	return int64(rate), nil
+}
+
+// FormatBandwidthLimit is the inverse of ParseBandwidthLimit.
+func FormatBandwidthLimit(bytesPerSecond int64) string {
+	switch {
+	case bytesPerSecond <= 0:
+		return "unlimited"
+	case bytesPerSecond%(1000*1000*1000) == 0:
+		return fmt.Sprintf("%dG", bytesPerSecond/(1000*1000*1000))
+	case bytesPerSecond%(1000*1000) == 0:
+		return fmt.Sprintf("%dM", bytesPerSecond/(1000*1000))
+	case bytesPerSecond%1000 == 0:
+		return fmt.Sprintf("%dK", bytesPerSecond/1000)
+	default:
+		return strconv.FormatInt(bytesPerSecond, 10)
+	}
+}
+
+// ParseBandwidthSchedule parses comma separated rules of the form
+// "HH:MM-HH:MM=<limit>", for example "08:00-18:00=1M, 18:00-08:00=unlimited".
+func ParseBandwidthSchedule(text string) ([]BandwidthRule, error) {
+	var rules []BandwidthRule
+	for _, item := range strings.Split(text, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		window, limitText, ok := strings.Cut(item, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid bandwidth rule %q: expected HH:MM-HH:MM=<limit>", item)
+		}
+		startText, endText, ok := strings.Cut(window, "-")
+		if !ok {
+			return nil, fmt.Errorf("invalid bandwidth rule %q: expected HH:MM-HH:MM=<limit>", item)
+		}
+		start, err := parseClock(startText)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bandwidth rule %q: %w", item, err)
+		}
+		end, err := parseClock(endText)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bandwidth rule %q: %w", item, err)
+		}
+		limit, err := ParseBandwidthLimit(limitText)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bandwidth rule %q: %w", item, err)
+		}
+		rules = append(rules, BandwidthRule{Start: start, End: end, Limit: limit})
+	}
+	return rules, nil
+}
+
+// FormatBandwidthSchedule is the inverse of ParseBandwidthSchedule.
+func FormatBandwidthSchedule(rules []BandwidthRule) string {
+	items := make([]string, len(rules))
+	for i, rule := range rules {
+		items[i] = rule.String()
+	}
+	return strings.Join(items, ", ")
+}
+
+func parseClock(text string) (time.Duration, error) {
+	parsed, err := time.Parse("15:04", strings.TrimSpace(text))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q", strings.TrimSpace(text))
+	}
+	return time.Duration(parsed.Hour())*time.Hour + time.Duration(parsed.Minute())*time.Minute, nil
+}
+
+func formatClock(offset time.Duration) string {
+	return fmt.Sprintf("%02d:%02d", int(offset/time.Hour), int(offset%time.Hour/time.Minute))
+}
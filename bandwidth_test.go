@@ -0,0 +1,34 @@
+package wiiudownloader
+
+import "testing"
+
+func TestParseBandwidthLimit(t *testing.T) {
+	tests := []struct {
+		limit   string
+		want    int64
+		wantErr bool
+	}{
+		{limit: "", want: 0},
+		{limit: "off", want: 0},
+		{limit: "Unlimited", want: 0},
+		{limit: "1500000", want: 1500000},
+		{limit: "500K", want: 500_000},
+		{limit: "2MB/s", want: 2_000_000},
+		{limit: "1.5m", want: 1_500_000},
+		{limit: "9000000000G", want: 9_000_000_000_000_000_000},
+		{limit: "-1K", wantErr: true},
+		{limit: "fast", wantErr: true},
+		{limit: "NaN", wantErr: true},
+		{limit: "nanK", wantErr: true},
+		{limit: "Inf", wantErr: true},
+		{limit: "-infinity", wantErr: true},
+		{limit: "1e30G", wantErr: true},
+		{limit: "10000000000G", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseBandwidthLimit(tt.limit)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseBandwidthLimit(%q) = %d, %v; want %d, error %v", tt.limit, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
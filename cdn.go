@@ -0,0 +1,331 @@
+package wiiudownloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+const DEFAULT_CDN_BASE_URL = "http://ccs.cdn.c.shop.nintendowifi.net/ccs/download/"
+
+// DEFAULT_USER_AGENT is sent to the CDN when no other user agent is given.
+const DEFAULT_USER_AGENT = "WiiUDownloader"
+
+// TITLE_VERSION_STEP is the distance between consecutive title versions.
+const TITLE_VERSION_STEP = 16
+
+var (
+	cdnMirrorsMutex sync.RWMutex
+	cdnMirrors      = []string{DEFAULT_CDN_BASE_URL}
+)
+
+// mirrorAwareReporter is implemented by progress reporters that want to know
+// which CDN mirror is serving a file.
+type mirrorAwareReporter interface {
+	SetFileMirror(filename, mirror string)
+}
+
+// statusError is returned when a mirror answers with an unexpected HTTP status.
+type statusError struct {
+	StatusCode int
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("status code: %d", e.StatusCode)
+}
+
+// MirrorError records which mirror served a file that failed to download or validate.
+type MirrorError struct {
+	Mirror string
+	File   string
+	Err    error
+}
+
+func (e *MirrorError) Error() string {
+	return fmt.Sprintf("%s from mirror %s: %v", e.File, e.Mirror, e.Err)
+}
+
+func (e *MirrorError) Unwrap() error {
+	return e.Err
+}
+
+// SetCDNMirrors replaces the ordered list of CDN base URLs. Files are requested
+// from the first mirror and fall back to the next one on server errors, files
+// not found, timeouts or validation failures. An empty list restores the Nintendo CDN.
+func SetCDNMirrors(mirrors []string) error {
+	normalized, err := ParseCDNMirrors(mirrors)
+	if err != nil {
+		return err
+	}
+
+	cdnMirrorsMutex.Lock()
+	defer cdnMirrorsMutex.Unlock()
+	cdnMirrors = normalized
+	return nil
+}
+
+// ParseCDNMirrors checks the CDN base URLs and returns them as SetCDNMirrors
+// would use them, without changing the mirrors in use.
+func ParseCDNMirrors(mirrors []string) ([]string, error) {
+	normalized := make([]string, 0, len(mirrors))
+	for _, mirror := range mirrors {
+		mirror = strings.TrimSpace(mirror)
+		if mirror == "" {
+			continue
+		}
+		parsed, err := url.Parse(mirror)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CDN mirror %q: %w", mirror, err)
+		}
+		if parsed.Scheme != "http" && parsed.Scheme != "https" {
+			return nil, fmt.Errorf("invalid CDN mirror %q: scheme must be http or https", mirror)
+		}
+		if parsed.Host == "" {
+			return nil, fmt.Errorf("invalid CDN mirror %q: missing host", mirror)
+		}
+		if !strings.HasSuffix(mirror, "/") {
+			mirror += "/"
+		}
+		normalized = append(normalized, mirror)
+	}
+	if len(normalized) == 0 {
+		normalized = append(normalized, DEFAULT_CDN_BASE_URL)
+	}
+	return normalized, nil
+}
+
+// CDNMirrors returns the ordered list of CDN base URLs currently in use.
+func CDNMirrors() []string {
+	cdnMirrorsMutex.RLock()
+	defer cdnMirrorsMutex.RUnlock()
+	return append([]string(nil), cdnMirrors...)
+}
+
+func cdnURL(mirror, titleID, file string) string {
+	return mirror + titleID + "/" + file
+}
+
+// shouldFailOver reports whether a download error is worth retrying on the next
+// mirror. A 404 only fails over when the mirror is not the last one, since a
+// LAN cache or partial mirror may lack files the next mirror has.
+func shouldFailOver(err error, lastMirror bool) bool {
+	if err == nil || errors.Is(err, ErrCancelled) || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var statusErr *statusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode >= http.StatusInternalServerError || (statusErr.StatusCode == http.StatusNotFound && !lastMirror)
+	}
+	return true
+}
+
+func reportFileMirror(progressReporter ProgressReporter, filename, mirror string) {
+	if reporter, ok := progressReporter.(mirrorAwareReporter); ok {
+		reporter.SetFileMirror(filename, mirror)
+	}
+}
+
+// downloadFromMirrors downloads titleID/file into dstPath, walking the mirror
+// list in order. The resume journal is keyed on titleID/file so a partial file
+// fetched from one mirror is continued from the next one.
+func downloadFromMirrors(ctx context.Context, progressReporter ProgressReporter, client *http.Client, titleID, file, dstPath string, opts downloadOptions) error {
+	mirrors := CDNMirrors()
+	rounds := 1
+	if opts.DoRetries {
+		rounds = maxRetries
+	}
+	singleAttempt := opts
+	singleAttempt.DoRetries = false
+	singleAttempt.ResumeKey = titleID + "/" + file
+	fileStats := &downloadStats{}
+	singleAttempt.Stats = fileStats
+	succeeded := false
+	defer func() {
+		opts.Stats.mergeFile(fileStats, dstPath, succeeded)
+	}()
+
+	var errs []error
+	for round := 1; round <= rounds; round++ {
+		for i, mirror := range mirrors {
+			if !waitUntilResumed(progressReporter) {
+				return ErrCancelled
+			}
+			reportFileMirror(progressReporter, filepath.Base(dstPath), mirror)
+			err := downloadFileWithOptions(ctx, progressReporter, client, cdnURL(mirror, titleID, file), dstPath, singleAttempt)
+			if err == nil {
+				succeeded = true
+				return nil
+			}
+			if isCancelled(progressReporter) {
+				return ErrCancelled
+			}
+			mirrorErr := &MirrorError{Mirror: mirror, File: file, Err: err}
+			if !shouldFailOver(err, i == len(mirrors)-1) {
+				return mirrorErr
+			}
+			errs = append(errs, mirrorErr)
+		}
+		if round < rounds && shouldRetry(progressReporter, opts.DoRetries, round) {
+			time.Sleep(retryDelay)
+		}
+	}
+	if len(errs) == 1 {
+		return errs[0]
+	}
+	return errors.Join(errs...)
+}
+
+// FetchTMD downloads and parses the latest TMD of a title without writing it to
+// disk. An empty userAgent sends DEFAULT_USER_AGENT.
+func FetchTMD(ctx context.Context, client *http.Client, userAgent string, titleID uint64) (*TMD, error) {
+	return fetchTMDFile(ctx, client, userAgent, titleID, "tmd")
+}
+
+// FetchTMDVersion downloads and parses the TMD of a specific title version.
+func FetchTMDVersion(ctx context.Context, client *http.Client, userAgent string, titleID uint64, version uint16) (*TMD, error) {
+	tmd, err := fetchTMDFile(ctx, client, userAgent, titleID, tmdFileName(&version))
+	if err != nil {
+		return nil, err
+	}
+	if tmd.TitleVersion != version {
+		return nil, fmt.Errorf("tmd.%d has title version %d", version, tmd.TitleVersion)
+	}
+	return tmd, nil
+}
+
+// TitleVersions lists the versions of a title found by ListTitleVersions.
+type TitleVersions struct {
+	// Available holds the versions the CDN serves in ascending order. The
+	// latest version is always among them.
+	Available []uint16
+	// Unknown holds the versions whose TMD could not be fetched for another
+	// reason than the CDN lacking it, such as a timeout, in ascending order.
+	Unknown []uint16
+	// Truncated is set when versions older than the probed ones were left out
+	// to stay within MAX_TITLE_VERSION_PROBES requests.
+	Truncated bool
+}
+
+// MAX_TITLE_VERSION_PROBES bounds the number of older versions
+// ListTitleVersions requests a TMD for.
+const MAX_TITLE_VERSION_PROBES = 256
+
+// ListTitleVersions finds the versions of a title the CDN still serves. It
+// requests the latest TMD and then one TMD per older version, up to
+// MAX_TITLE_VERSION_PROBES of them starting from the newest. Wii U title
+// versions are multiples of TITLE_VERSION_STEP, so a Wii U title with latest
+// version 208 costs 14 requests, while every version below the latest is
+// probed for Wii titles. Only fetching the latest TMD or cancellation fails
+// the listing; a version whose probe fails otherwise is reported as unknown.
+func ListTitleVersions(ctx context.Context, client *http.Client, userAgent string, titleID uint64) (*TitleVersions, error) {
+	latest, err := FetchTMD(ctx, client, userAgent, titleID)
+	if err != nil {
+		return nil, err
+	}
+
+	step := 1
+	if latest.Version == TMD_VERSION_WIIU {
+		step = TITLE_VERSION_STEP
+	}
+	result := &TitleVersions{Available: []uint16{latest.TitleVersion}}
+	var resultMutex sync.Mutex
+	g, groupCtx := errgroup.WithContext(ctx)
+	g.SetLimit(maxConcurrentDownloads)
+	// The newest versions are the likeliest to be chosen, so they are the ones
+	// probed when there are too many.
+	newest := -1
+	if latest.TitleVersion > 0 {
+		newest = (int(latest.TitleVersion) - 1) / step * step
+	}
+	for version, probes := newest, 0; version >= 0; version, probes = version-step, probes+1 {
+		if probes == MAX_TITLE_VERSION_PROBES {
+			result.Truncated = true
+			break
+		}
+		version := uint16(version)
+		g.Go(func() error {
+			_, err := FetchTMDVersion(groupCtx, client, userAgent, titleID, version)
+			if ctxErr := groupCtx.Err(); ctxErr != nil {
+				return ctxErr
+			}
+			if errors.Is(err, ErrTitleNotFound) {
+				return nil
+			}
+			resultMutex.Lock()
+			defer resultMutex.Unlock()
+			if err != nil {
+				result.Unknown = append(result.Unknown, version)
+			} else {
+				result.Available = append(result.Available, version)
+			}
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+	slices.Sort(result.Available)
+	slices.Sort(result.Unknown)
+	return result, nil
+}
+
+// tmdFileName returns the CDN file name of the TMD for version, or of the
+// latest TMD when version is nil.
+func tmdFileName(version *uint16) string {
+	if version == nil {
+		return "tmd"
+	}
+	return fmt.Sprintf("tmd.%d", *version)
+}
+
+func fetchTMDFile(ctx context.Context, client *http.Client, userAgent string, titleID uint64, file string) (*TMD, error) {
+	if userAgent == "" {
+		userAgent = DEFAULT_USER_AGENT
+	}
+	var errs []error
+	mirrors := CDNMirrors()
+	for i, mirror := range mirrors {
+		tmd, err := fetchTMDFromMirror(ctx, client, userAgent, cdnURL(mirror, fmt.Sprintf("%016x", titleID), file))
+		if err == nil {
+			return tmd, nil
+		}
+		mirrorErr := &MirrorError{Mirror: mirror, File: file, Err: err}
+		if !shouldFailOver(err, i == len(mirrors)-1) {
+			return nil, titleNotFoundError(titleID, mirrorErr)
+		}
+		errs = append(errs, mirrorErr)
+	}
+	return nil, errors.Join(errs...)
+}
+
+func fetchTMDFromMirror(ctx context.Context, client *http.Client, userAgent, tmdURL string) (*TMD, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, tmdURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", userAgent)
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch TMD: %w", &statusError{StatusCode: resp.StatusCode})
+	}
+	tmdData, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read TMD data: %w", err)
+	}
+	return ParseTMD(tmdData)
+}
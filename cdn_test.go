@@ -0,0 +1,122 @@
+package wiiudownloader
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// testTMD returns a TMD without contents for a title version.
+func testTMD(titleID uint64, tmdVersion byte, titleVersion uint16) []byte {
+	tmd := make([]byte, 0xB04)
+	binary.BigEndian.PutUint32(tmd, 0x10004)
+	copy(tmd[0x140:], "Root-CA00000003-CP0000000b")
+	tmd[0x180] = tmdVersion
+	binary.BigEndian.PutUint64(tmd[0x18C:], titleID)
+	binary.BigEndian.PutUint16(tmd[0x1DC:], titleVersion)
+	return tmd
+}
+
+// serveTestTMDs makes a CDN serving the TMD of titleID for the versions in
+// statuses with their HTTP status, the latest one being served as tmd, the
+// only mirror for the rest of the test.
+func serveTestTMDs(t *testing.T, titleID uint64, tmdVersion byte, latest uint16, statuses map[uint16]int) {
+	t.Helper()
+	prefix := fmt.Sprintf("/%016x/", titleID)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		file, ok := strings.CutPrefix(r.URL.Path, prefix)
+		version := latest
+		if file != "tmd" {
+			var n uint16
+			if _, err := fmt.Sscanf(file, "tmd.%d", &n); !ok || err != nil {
+				http.NotFound(w, r)
+				return
+			}
+			version = n
+		}
+		status, ok := statuses[version]
+		if version == latest {
+			status, ok = http.StatusOK, true
+		}
+		if !ok {
+			status = http.StatusNotFound
+		}
+		if status != http.StatusOK {
+			w.WriteHeader(status)
+			return
+		}
+		w.Write(testTMD(titleID, tmdVersion, version))
+	}))
+	t.Cleanup(server.Close)
+	saved := CDNMirrors()
+	t.Cleanup(func() { SetCDNMirrors(saved) })
+	if err := SetCDNMirrors([]string{server.URL}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestListTitleVersions(t *testing.T) {
+	tests := []struct {
+		name          string
+		titleID       uint64
+		tmdVersion    byte
+		latest        uint16
+		statuses      map[uint16]int
+		wantAvailable []uint16
+		wantUnknown   []uint16
+	}{
+		{
+			name:          "WiiU",
+			titleID:       0x0005000E10101C00,
+			tmdVersion:    TMD_VERSION_WIIU,
+			latest:        64,
+			statuses:      map[uint16]int{16: http.StatusOK, 32: http.StatusServiceUnavailable},
+			wantAvailable: []uint16{16, 64},
+			wantUnknown:   []uint16{32},
+		},
+		{
+			name:          "Wii",
+			titleID:       0x0000000700000050,
+			tmdVersion:    TMD_VERSION_WII,
+			latest:        3,
+			statuses:      map[uint16]int{1: http.StatusOK},
+			wantAvailable: []uint16{1, 3},
+		},
+		{
+			name:          "First",
+			titleID:       0x0005000E10101C00,
+			tmdVersion:    TMD_VERSION_WIIU,
+			latest:        0,
+			wantAvailable: []uint16{0},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			serveTestTMDs(t, tt.titleID, tt.tmdVersion, tt.latest, tt.statuses)
+			versions, err := ListTitleVersions(context.Background(), http.DefaultClient, "", tt.titleID)
+			if err != nil {
+				t.Fatalf("ListTitleVersions: %v", err)
+			}
+			if !slices.Equal(versions.Available, tt.wantAvailable) || !slices.Equal(versions.Unknown, tt.wantUnknown) || versions.Truncated {
+				t.Fatalf("ListTitleVersions = %+v, want available %v and unknown %v", versions, tt.wantAvailable, tt.wantUnknown)
+			}
+		})
+	}
+
+	t.Run("Truncated", func(t *testing.T) {
+		const titleID = 0x0000000700000050
+		serveTestTMDs(t, titleID, TMD_VERSION_WII, MAX_TITLE_VERSION_PROBES+10, map[uint16]int{5: http.StatusOK, 20: http.StatusOK})
+		versions, err := ListTitleVersions(context.Background(), http.DefaultClient, "", titleID)
+		if err != nil {
+			t.Fatalf("ListTitleVersions: %v", err)
+		}
+		if want := []uint16{20, MAX_TITLE_VERSION_PROBES + 10}; !slices.Equal(versions.Available, want) || !versions.Truncated {
+			t.Fatalf("ListTitleVersions = %+v, want available %v and truncated", versions, want)
+		}
+	})
+}
@@ -0,0 +1,251 @@
+package wiiudownloader
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// CemuLayout selects how InstallCemuTitles arranges decrypted titles.
+type CemuLayout int
+
+const (
+	// CEMU_LAYOUT_MLC01 installs every title into its own folder below
+	// usr/title of a Cemu mlc01 directory, where Cemu pairs games with their
+	// updates and DLC itself.
+	CEMU_LAYOUT_MLC01 CemuLayout = iota
+	// CEMU_LAYOUT_MERGED copies the files of updates over those of their base
+	// game and places DLC in its aoc folder, giving one folder per game that
+	// Cemu can load directly.
+	CEMU_LAYOUT_MERGED
+)
+
+// Folders of a decrypted title that are installed.
+var cemuTitleDirectories = []string{"code", "content", "meta"}
+
+var errNotDecrypted = errors.New("title folder is not decrypted")
+
+func (l CemuLayout) String() string {
+	switch l {
+	case CEMU_LAYOUT_MLC01:
+		return "mlc01"
+	case CEMU_LAYOUT_MERGED:
+		return "merged"
+	default:
+		return fmt.Sprintf("CemuLayout(%d)", int(l))
+	}
+}
+
+// ParseCemuLayout parses the name of a layout as returned by String.
+func ParseCemuLayout(name string) (CemuLayout, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "mlc01", "mlc":
+		return CEMU_LAYOUT_MLC01, nil
+	case "merged":
+		return CEMU_LAYOUT_MERGED, nil
+	default:
+		return 0, fmt.Errorf("unknown Cemu layout %q: expected mlc01 or merged", name)
+	}
+}
+
+// CemuInstall describes where InstallCemuTitles put a title folder.
+type CemuInstall struct {
+	Source       string
+	TitleID      uint64
+	TitleVersion uint16
+	Target       string
+}
+
+// InstallCemuTitles installs the decrypted title folders into dest using
+// layout. Files are hard linked where possible and copied otherwise, so the
+// folders are left as they are. Games, updates and DLC of the same title are
+// paired by the low half of their title ID; with CEMU_LAYOUT_MERGED a game is
+// installed before its updates, in ascending version, and its DLC. The
+// installs done before an error are returned with it.
+func InstallCemuTitles(folders []string, dest string, layout CemuLayout) ([]CemuInstall, error) {
+	var installs []CemuInstall
+	for _, folder := range folders {
+		titleID, titleVersion, err := decryptedTitleInfo(folder)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", folder, err)
+		}
+		installs = append(installs, CemuInstall{Source: folder, TitleID: titleID, TitleVersion: titleVersion})
+	}
+
+	if layout == CEMU_LAYOUT_MERGED {
+		slices.SortStableFunc(installs, func(a, b CemuInstall) int {
+			if order := cemuInstallOrder(a.TitleID) - cemuInstallOrder(b.TitleID); order != 0 {
+				return order
+			}
+			return int(a.TitleVersion) - int(b.TitleVersion)
+		})
+	}
+	for i := range installs {
+		target, err := cemuTarget(dest, layout, installs[i].TitleID)
+		if err != nil {
+			return installs[:i], fmt.Errorf("%s: %w", installs[i].Source, err)
+		}
+		if err := installTitleFiles(installs[i].Source, target); err != nil {
+			return installs[:i], fmt.Errorf("%s: %w", installs[i].Source, err)
+		}
+		installs[i].Target = target
+	}
+	return installs, nil
+}
+
+func cemuInstallOrder(titleID uint64) int {
+	switch GetTitleIDHigh(titleID) {
+	case TID_HIGH_UPDATE:
+		return 1
+	case TID_HIGH_DLC:
+		return 2
+	default:
+		return 0
+	}
+}
+
+// cemuTarget returns the folder the files of a title are installed into.
+func cemuTarget(dest string, layout CemuLayout, titleID uint64) (string, error) {
+	high := GetTitleIDHigh(titleID)
+	if layout == CEMU_LAYOUT_MLC01 {
+		switch high {
+		case TID_HIGH_GAME, TID_HIGH_DEMO, TID_HIGH_UPDATE, TID_HIGH_DLC:
+			return filepath.Join(dest, "usr", "title", fmt.Sprintf("%08x", high), fmt.Sprintf("%08x", GetTitleIDLow(titleID))), nil
+		case TID_HIGH_SYSTEM_APP, TID_HIGH_SYSTEM_DATA, TID_HIGH_SYSTEM_APPLET:
+			return filepath.Join(dest, "sys", "title", fmt.Sprintf("%08x", high), fmt.Sprintf("%08x", GetTitleIDLow(titleID))), nil
+		}
+		return "", fmt.Errorf("%s titles cannot be installed into mlc01", GetFormattedKind(titleID))
+	}
+
+	switch high {
+	case TID_HIGH_GAME, TID_HIGH_DEMO:
+		return filepath.Join(dest, cemuGameFolderName(GetTitleEntryFromTid(titleID), titleID)), nil
+	case TID_HIGH_UPDATE, TID_HIGH_DLC:
+		source := GetTitleEntryFromTid(titleID)
+		if source.TitleID == 0 {
+			source = TitleEntry{TitleID: titleID}
+		}
+		gameID := uint64(TID_HIGH_GAME)<<32 | uint64(GetTitleIDLow(titleID))
+		game, ok := FindRelatedTitleByHighAndLow(source, TID_HIGH_GAME, nil)
+		if ok {
+			gameID = game.TitleID
+		}
+		target := filepath.Join(dest, cemuGameFolderName(game, gameID))
+		if high == TID_HIGH_DLC {
+			target = filepath.Join(target, "aoc")
+		}
+		return target, nil
+	}
+	return "", fmt.Errorf("%s titles cannot be merged", GetFormattedKind(titleID))
+}
+
+func cemuGameFolderName(game TitleEntry, gameID uint64) string {
+	if game.Name == "" {
+		return fmt.Sprintf("%016x", gameID)
+	}
+	return fmt.Sprintf("%s [%016x]", NormalizeFilename(game.Name), gameID)
+}
+
+// decryptedTitleInfo returns the title ID and version of a decrypted title
+// folder from its TMD, or from meta/meta.xml when the encrypted files were
+// deleted.
+func decryptedTitleInfo(path string) (uint64, uint16, error) {
+	found := false
+	for _, dir := range cemuTitleDirectories {
+		if info, err := os.Stat(filepath.Join(path, dir)); err == nil && info.IsDir() {
+			found = true
+		}
+	}
+	if !found {
+		return 0, 0, errNotDecrypted
+	}
+
+	if tmd, err := readTitleTMD(path); err == nil {
+		return tmd.TitleID, tmd.TitleVersion, nil
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return 0, 0, err
+	}
+
+	data, err := os.ReadFile(filepath.Join(path, "meta", "meta.xml"))
+	if err != nil {
+		return 0, 0, fmt.Errorf("neither title.tmd nor meta/meta.xml identify the title: %w", err)
+	}
+	var meta struct {
+		TitleID      string `xml:"title_id"`
+		TitleVersion string `xml:"title_version"`
+	}
+	if err := xml.Unmarshal(data, &meta); err != nil {
+		return 0, 0, fmt.Errorf("failed to parse meta.xml: %w", err)
+	}
+	titleID, err := strconv.ParseUint(strings.TrimSpace(meta.TitleID), 16, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid title_id in meta.xml: %w", err)
+	}
+	titleVersion, err := strconv.ParseUint(strings.TrimSpace(meta.TitleVersion), 10, 16)
+	if err != nil {
+		titleVersion = 0
+	}
+	return titleID, uint16(titleVersion), nil
+}
+
+// installTitleFiles links or copies the decrypted folders of the title in src
+// into target, replacing files that are already there.
+func installTitleFiles(src, target string) error {
+	for _, dir := range cemuTitleDirectories {
+		root := filepath.Join(src, dir)
+		if _, err := os.Stat(root); errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(src, path)
+			if err != nil {
+				return err
+			}
+			dst := filepath.Join(target, rel)
+			if entry.IsDir() {
+				return os.MkdirAll(dst, 0o755)
+			}
+			return linkOrCopyFile(path, dst)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// linkOrCopyFile replaces dst with a hard link to src, or with a copy of it
+// when src is on another file system.
+func linkOrCopyFile(src, dst string) error {
+	if err := os.Remove(dst); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if err := os.Link(src, dst); err == nil {
+		return nil
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, downloadFilePerm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -1,6 +1,7 @@
 package wiiudownloader
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"os"
@@ -15,12 +16,16 @@ const (
 	CETK_CERT_SIZE         = 0x300
 )
 
-func getDefaultCert(progressReporter ProgressReporter, client *http.Client) ([]byte, error) {
+func getDefaultCert(ctx context.Context, progressReporter ProgressReporter, client *http.Client, userAgent string) ([]byte, error) {
 	if hasCetkCertData(cetkData) {
 		return cetkData[CETK_CERT_START_OFFSET : CETK_CERT_START_OFFSET+CETK_CERT_SIZE], nil
 	}
 	cetkDir := path.Join(os.TempDir(), "cetk")
-	if err := downloadFile(progressReporter, client, "http://ccs.cdn.c.shop.nintendowifi.net/ccs/download/000500101000400a/cetk", cetkDir, true); err != nil {
+	if err := downloadFromMirrors(ctx, progressReporter, client, "000500101000400a", "cetk", cetkDir, downloadOptions{
+		DoRetries:   true,
+		AllowResume: true,
+		UserAgent:   userAgent,
+	}); err != nil {
 		return nil, err
 	}
 	cetkData, err := os.ReadFile(cetkDir)
@@ -39,6 +44,10 @@ func getDefaultCert(progressReporter ProgressReporter, client *http.Client) ([]b
 }
 
 func GenerateCert(tmd *TMD, outputPath string, progressReporter ProgressReporter, client *http.Client) error {
+	return generateCert(context.Background(), tmd, outputPath, progressReporter, client, DEFAULT_USER_AGENT)
+}
+
+func generateCert(ctx context.Context, tmd *TMD, outputPath string, progressReporter ProgressReporter, client *http.Client, userAgent string) error {
 	cert, err := os.Create(outputPath)
 	if err != nil {
 		return err
@@ -53,7 +62,7 @@ func GenerateCert(tmd *TMD, outputPath string, progressReporter ProgressReporter
 		return err
 	}
 
-	defaultCert, err := getDefaultCert(progressReporter, client)
+	defaultCert, err := getDefaultCert(ctx, progressReporter, client, userAgent)
 	if err != nil {
 		return err
 	}
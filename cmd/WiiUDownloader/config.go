@@ -15,17 +15,26 @@ import (
 )
 
 type Config struct {
-	DarkMode                bool   `koanf:"darkMode"`
-	DecryptContents         bool   `koanf:"decryptContents"`
-	DeleteEncryptedContents bool   `koanf:"deleteEncryptedContents"`
-	ContinueOnError         bool   `koanf:"continueOnError"`
-	SuggestRelatedContent   bool   `koanf:"suggestRelatedContent"`
-	SelectedRegion          uint8  `koanf:"selectedRegion"`
-	DidInitialSetup         bool   `koanf:"didInitialSetup"`
-	LastSelectedPath        string `koanf:"lastSelectedPath"`
-	RememberLastPath        bool   `koanf:"rememberLastPath"`
-	ShowDonationBar         bool   `koanf:"showDonationBar"`
-	GetSizeOnQueue          bool   `koanf:"getSizeOnQueue"`
+	DarkMode                bool     `koanf:"darkMode"`
+	DecryptContents         bool     `koanf:"decryptContents"`
+	DeleteEncryptedContents bool     `koanf:"deleteEncryptedContents"`
+	ContinueOnError         bool     `koanf:"continueOnError"`
+	SuggestRelatedContent   bool     `koanf:"suggestRelatedContent"`
+	SelectedRegion          uint8    `koanf:"selectedRegion"`
+	DidInitialSetup         bool     `koanf:"didInitialSetup"`
+	LastSelectedPath        string   `koanf:"lastSelectedPath"`
+	RememberLastPath        bool     `koanf:"rememberLastPath"`
+	ShowDonationBar         bool     `koanf:"showDonationBar"`
+	GetSizeOnQueue          bool     `koanf:"getSizeOnQueue"`
+	CDNMirrors              []string `koanf:"cdnMirrors"`
+	BandwidthLimit          string   `koanf:"bandwidthLimit"`
+	BandwidthSchedule       string   `koanf:"bandwidthSchedule"`
+	DecryptWhileDownloading bool     `koanf:"decryptWhileDownloading"`
+	ExtractInclude          []string `koanf:"extractInclude"`
+	ExtractExclude          []string `koanf:"extractExclude"`
+	DownloadMatchingOnly    bool     `koanf:"downloadMatchingOnly"`
+	CemuLayout              string   `koanf:"cemuLayout"`
+	CemuPath                string   `koanf:"cemuPath"`
 	saveConfigCallback      func()
 	saveMutex               *sync.Mutex
 }
@@ -159,6 +168,32 @@ func (c *Config) SetValuesFromConfig(newK *koanf.Koanf) error {
 	return nil
 }
 
+// applyBandwidthSettings parses the bandwidth limit and schedule and hands them
+// to the downloader, which applies them to running downloads right away.
+func applyBandwidthSettings(limitText, scheduleText string) error {
+	limit, schedule, err := parseBandwidthSettings(limitText, scheduleText)
+	if err != nil {
+		return err
+	}
+	wiiudownloader.SetBandwidthLimit(limit)
+	wiiudownloader.SetBandwidthSchedule(schedule)
+	return nil
+}
+
+// parseBandwidthSettings parses the bandwidth limit and schedule without
+// applying them.
+func parseBandwidthSettings(limitText, scheduleText string) (int64, []wiiudownloader.BandwidthRule, error) {
+	limit, err := wiiudownloader.ParseBandwidthLimit(limitText)
+	if err != nil {
+		return 0, nil, err
+	}
+	schedule, err := wiiudownloader.ParseBandwidthSchedule(scheduleText)
+	if err != nil {
+		return 0, nil, err
+	}
+	return limit, schedule, nil
+}
+
 func configDirPath(userConfigDir string) string {
 	return filepath.Join(userConfigDir, WIIUDOWNLOADER_CONFIG_DIR)
 }
@@ -1,6 +1,9 @@
 package main
 
 import (
+	"strings"
+
+	wiiudownloader "github.com/Xpl0itU/WiiUDownloader"
 	"github.com/Xpl0itU/dialog"
 	"github.com/gotk3/gotk3/gtk"
 )
@@ -20,6 +23,7 @@ const (
 	SETTINGS_ENTRY_MARGIN_END           = 10
 	UNSAVED_CHANGES_CONFIRM_MESSAGE     = "You have unsaved changes. Close without saving?"
 	INVALID_DOWNLOAD_PATH_ERROR_MESSAGE = "Invalid download path. Please select a valid directory."
+	MISSING_CEMU_PATH_ERROR_MESSAGE     = "Choose the folder to install titles for Cemu into."
 )
 
 func NewConfigWindow(config *Config) (*ConfigWindow, error) {
@@ -142,6 +146,158 @@ func NewConfigWindow(config *Config) (*ConfigWindow, error) {
 	SetupCheckButtonAccessibility(suggestRelatedContentCheck, "Offer related content that matches the same title ID")
 	downloadsGrid.Attach(suggestRelatedContentCheck, 0, 1, 1, 1)
 
+	cdnMirrorsLabel, err := gtk.LabelNew("CDN mirrors (comma separated, tried in order):")
+	if err != nil {
+		return nil, err
+	}
+	cdnMirrorsLabel.SetHAlign(gtk.ALIGN_START)
+	downloadsGrid.Attach(cdnMirrorsLabel, 0, 2, 1, 1)
+
+	cdnMirrorsEntry, err := gtk.EntryNew()
+	if err != nil {
+		return nil, err
+	}
+	cdnMirrorsEntry.SetText(strings.Join(config.CDNMirrors, ", "))
+	cdnMirrorsEntry.SetPlaceholderText(wiiudownloader.DEFAULT_CDN_BASE_URL)
+	cdnMirrorsEntry.SetWidthChars(SETTINGS_ENTRY_WIDTH_CHARS)
+	cdnMirrorsEntry.SetHExpand(true)
+	SetupEntryAccessibility(cdnMirrorsEntry, "CDN mirrors", "Base URLs to download titles from. The next mirror is used when one fails.")
+	downloadsGrid.Attach(cdnMirrorsEntry, 0, 3, 1, 1)
+
+	bandwidthLimitLabel, err := gtk.LabelNew("Bandwidth limit (e.g. 500K or 2M bytes/s, empty for unlimited):")
+	if err != nil {
+		return nil, err
+	}
+	bandwidthLimitLabel.SetHAlign(gtk.ALIGN_START)
+	downloadsGrid.Attach(bandwidthLimitLabel, 0, 4, 1, 1)
+
+	bandwidthLimitEntry, err := gtk.EntryNew()
+	if err != nil {
+		return nil, err
+	}
+	bandwidthLimitEntry.SetText(config.BandwidthLimit)
+	bandwidthLimitEntry.SetPlaceholderText("unlimited")
+	bandwidthLimitEntry.SetWidthChars(SETTINGS_ENTRY_WIDTH_CHARS)
+	bandwidthLimitEntry.SetHExpand(true)
+	SetupEntryAccessibility(bandwidthLimitEntry, "Bandwidth limit", "Maximum combined download speed of all downloads.")
+	downloadsGrid.Attach(bandwidthLimitEntry, 0, 5, 1, 1)
+
+	bandwidthScheduleLabel, err := gtk.LabelNew("Bandwidth schedule (overrides the limit during the given hours):")
+	if err != nil {
+		return nil, err
+	}
+	bandwidthScheduleLabel.SetHAlign(gtk.ALIGN_START)
+	downloadsGrid.Attach(bandwidthScheduleLabel, 0, 6, 1, 1)
+
+	bandwidthScheduleEntry, err := gtk.EntryNew()
+	if err != nil {
+		return nil, err
+	}
+	bandwidthScheduleEntry.SetText(config.BandwidthSchedule)
+	bandwidthScheduleEntry.SetPlaceholderText("08:00-18:00=1M, 18:00-08:00=unlimited")
+	bandwidthScheduleEntry.SetWidthChars(SETTINGS_ENTRY_WIDTH_CHARS)
+	bandwidthScheduleEntry.SetHExpand(true)
+	SetupEntryAccessibility(bandwidthScheduleEntry, "Bandwidth schedule", "Comma separated HH:MM-HH:MM=limit rules that override the bandwidth limit.")
+	downloadsGrid.Attach(bandwidthScheduleEntry, 0, 7, 1, 1)
+
+	decryptWhileDownloadingCheck, err := gtk.CheckButtonNewWithLabel("Decrypt each content as soon as it is downloaded")
+	if err != nil {
+		return nil, err
+	}
+	decryptWhileDownloadingCheck.SetActive(config.DecryptWhileDownloading)
+	SetupCheckButtonAccessibility(decryptWhileDownloadingCheck, "When decryption is enabled, extract files while the rest of the title is still downloading")
+	downloadsGrid.Attach(decryptWhileDownloadingCheck, 0, 8, 1, 1)
+
+	extractIncludeLabel, err := gtk.LabelNew("Only extract files matching (comma separated, e.g. content/Common, *.rpx):")
+	if err != nil {
+		return nil, err
+	}
+	extractIncludeLabel.SetHAlign(gtk.ALIGN_START)
+	downloadsGrid.Attach(extractIncludeLabel, 0, 9, 1, 1)
+
+	extractIncludeEntry, err := gtk.EntryNew()
+	if err != nil {
+		return nil, err
+	}
+	extractIncludeEntry.SetText(strings.Join(config.ExtractInclude, ", "))
+	extractIncludeEntry.SetPlaceholderText("all files")
+	extractIncludeEntry.SetWidthChars(SETTINGS_ENTRY_WIDTH_CHARS)
+	extractIncludeEntry.SetHExpand(true)
+	SetupEntryAccessibility(extractIncludeEntry, "Extract only", "Glob patterns of the files to extract when decrypting. Empty extracts every file.")
+	downloadsGrid.Attach(extractIncludeEntry, 0, 10, 1, 1)
+
+	extractExcludeLabel, err := gtk.LabelNew("Never extract files matching (comma separated):")
+	if err != nil {
+		return nil, err
+	}
+	extractExcludeLabel.SetHAlign(gtk.ALIGN_START)
+	downloadsGrid.Attach(extractExcludeLabel, 0, 11, 1, 1)
+
+	extractExcludeEntry, err := gtk.EntryNew()
+	if err != nil {
+		return nil, err
+	}
+	extractExcludeEntry.SetText(strings.Join(config.ExtractExclude, ", "))
+	extractExcludeEntry.SetPlaceholderText("no files")
+	extractExcludeEntry.SetWidthChars(SETTINGS_ENTRY_WIDTH_CHARS)
+	extractExcludeEntry.SetHExpand(true)
+	SetupEntryAccessibility(extractExcludeEntry, "Never extract", "Glob patterns of the files to skip when decrypting.")
+	downloadsGrid.Attach(extractExcludeEntry, 0, 12, 1, 1)
+
+	downloadMatchingOnlyCheck, err := gtk.CheckButtonNewWithLabel("Only download contents holding matching files")
+	if err != nil {
+		return nil, err
+	}
+	downloadMatchingOnlyCheck.SetActive(config.DownloadMatchingOnly)
+	SetupCheckButtonAccessibility(downloadMatchingOnlyCheck, "Skip the contents of a title that hold none of the files selected by the patterns above")
+	downloadsGrid.Attach(downloadMatchingOnlyCheck, 0, 13, 1, 1)
+
+	cemuLayoutLabel, err := gtk.LabelNew("After decrypting, install titles for Cemu:")
+	if err != nil {
+		return nil, err
+	}
+	cemuLayoutLabel.SetHAlign(gtk.ALIGN_START)
+	downloadsGrid.Attach(cemuLayoutLabel, 0, 14, 1, 1)
+
+	cemuLayoutCombo, err := gtk.ComboBoxTextNew()
+	if err != nil {
+		return nil, err
+	}
+	cemuLayoutCombo.Append("", "Off")
+	cemuLayoutCombo.Append(wiiudownloader.CEMU_LAYOUT_MLC01.String(), "Into an mlc01 folder (usr/title)")
+	cemuLayoutCombo.Append(wiiudownloader.CEMU_LAYOUT_MERGED.String(), "As game folders with updates and DLC merged in")
+	if !cemuLayoutCombo.SetActiveID(config.CemuLayout) {
+		cemuLayoutCombo.SetActiveID("")
+	}
+	downloadsGrid.Attach(cemuLayoutCombo, 0, 15, 1, 1)
+
+	cemuPathEntry, err := gtk.EntryNew()
+	if err != nil {
+		return nil, err
+	}
+	cemuPathEntry.SetText(config.CemuPath)
+	cemuPathEntry.SetPlaceholderText("mlc01 or games folder")
+	cemuPathEntry.SetWidthChars(SETTINGS_ENTRY_WIDTH_CHARS)
+	cemuPathEntry.SetHExpand(true)
+	SetupEntryAccessibility(cemuPathEntry, "Cemu folder", "The mlc01 folder of Cemu, or the folder merged game folders are created in.")
+	downloadsGrid.Attach(cemuPathEntry, 0, 16, 1, 1)
+
+	cemuPathButton, err := gtk.ButtonNewWithLabel("Browse")
+	if err != nil {
+		return nil, err
+	}
+	SetupButtonAccessibility(cemuPathButton, "Open file browser to select the Cemu folder")
+	cemuPathButton.Connect("clicked", func() {
+		selectedPath, err := dialog.Directory().Title("Select Cemu Folder").Browse()
+		if err != nil {
+			return
+		}
+		if selectedPath != "" {
+			cemuPathEntry.SetText(selectedPath)
+		}
+	})
+	downloadsGrid.Attach(cemuPathButton, 1, 16, 1, 1)
+
 	stack.AddTitled(downloadsGrid, "downloads", "Downloads")
 
 	// --- Interface Tab ---
@@ -209,12 +365,22 @@ func NewConfigWindow(config *Config) (*ConfigWindow, error) {
 	rememberPathCheck.Connect("toggled", func() { dirty = true })
 	continueOnErrorCheck.Connect("toggled", func() { dirty = true })
 	suggestRelatedContentCheck.Connect("toggled", func() { dirty = true })
+	decryptWhileDownloadingCheck.Connect("toggled", func() { dirty = true })
+	downloadMatchingOnlyCheck.Connect("toggled", func() { dirty = true })
+	extractIncludeEntry.Connect("changed", func() { dirty = true })
+	extractExcludeEntry.Connect("changed", func() { dirty = true })
+	cemuLayoutCombo.Connect("changed", func() { dirty = true })
+	cemuPathEntry.Connect("changed", func() { dirty = true })
 	showDonationBarCheck.Connect("toggled", func() { dirty = true })
 	getSizeOnQueueCheck.Connect("toggled", func() { dirty = true })
 	downloadPathEntry.Connect("changed", func() { dirty = true })
+	cdnMirrorsEntry.Connect("changed", func() { dirty = true })
+	bandwidthLimitEntry.Connect("changed", func() { dirty = true })
+	bandwidthScheduleEntry.Connect("changed", func() { dirty = true })
 
 	saveButton.Connect("clicked", func() {
-		config.DarkMode = darkModeCheck.GetActive()
+		// Every field is checked before any setting is applied, so a rejected
+		// field leaves both the running settings and the config untouched.
 		newPath, getTextErr := downloadPathEntry.GetText()
 		if getTextErr != nil {
 			ShowErrorDialog(win, getTextErr)
@@ -227,10 +393,83 @@ func NewConfigWindow(config *Config) (*ConfigWindow, error) {
 			return
 		}
 
+		mirrorsText, getTextErr := cdnMirrorsEntry.GetText()
+		if getTextErr != nil {
+			ShowErrorDialog(win, getTextErr)
+			return
+		}
+		mirrors := splitCommaList(mirrorsText)
+		if _, err := wiiudownloader.ParseCDNMirrors(mirrors); err != nil {
+			ShowErrorDialog(win, err)
+			return
+		}
+
+		bandwidthLimit, getTextErr := bandwidthLimitEntry.GetText()
+		if getTextErr != nil {
+			ShowErrorDialog(win, getTextErr)
+			return
+		}
+		bandwidthSchedule, getTextErr := bandwidthScheduleEntry.GetText()
+		if getTextErr != nil {
+			ShowErrorDialog(win, getTextErr)
+			return
+		}
+		limit, schedule, err := parseBandwidthSettings(bandwidthLimit, bandwidthSchedule)
+		if err != nil {
+			ShowErrorDialog(win, err)
+			return
+		}
+
+		includeText, getTextErr := extractIncludeEntry.GetText()
+		if getTextErr != nil {
+			ShowErrorDialog(win, getTextErr)
+			return
+		}
+		excludeText, getTextErr := extractExcludeEntry.GetText()
+		if getTextErr != nil {
+			ShowErrorDialog(win, getTextErr)
+			return
+		}
+		extractFilter := wiiudownloader.PathFilter{Include: splitCommaList(includeText), Exclude: splitCommaList(excludeText)}
+		if err := extractFilter.Validate(); err != nil {
+			ShowErrorDialog(win, err)
+			return
+		}
+
+		cemuPath, getTextErr := cemuPathEntry.GetText()
+		if getTextErr != nil {
+			ShowErrorDialog(win, getTextErr)
+			return
+		}
+		cemuLayout := cemuLayoutCombo.GetActiveID()
+		if cemuLayout != "" && strings.TrimSpace(cemuPath) == "" {
+			errorDialog := gtk.MessageDialogNew(win, gtk.DIALOG_MODAL, gtk.MESSAGE_ERROR, gtk.BUTTONS_OK, MISSING_CEMU_PATH_ERROR_MESSAGE)
+			defer errorDialog.Destroy()
+			errorDialog.Run()
+			return
+		}
+
+		if err := wiiudownloader.SetCDNMirrors(mirrors); err != nil {
+			ShowErrorDialog(win, err)
+			return
+		}
+		wiiudownloader.SetBandwidthLimit(limit)
+		wiiudownloader.SetBandwidthSchedule(schedule)
+
+		config.DarkMode = darkModeCheck.GetActive()
 		config.LastSelectedPath = newPath
+		config.CDNMirrors = mirrors
+		config.BandwidthLimit = bandwidthLimit
+		config.BandwidthSchedule = bandwidthSchedule
 		config.RememberLastPath = rememberPathCheck.GetActive()
 		config.ContinueOnError = continueOnErrorCheck.GetActive()
 		config.SuggestRelatedContent = suggestRelatedContentCheck.GetActive()
+		config.DecryptWhileDownloading = decryptWhileDownloadingCheck.GetActive()
+		config.ExtractInclude = extractFilter.Include
+		config.ExtractExclude = extractFilter.Exclude
+		config.DownloadMatchingOnly = downloadMatchingOnlyCheck.GetActive()
+		config.CemuLayout = cemuLayout
+		config.CemuPath = strings.TrimSpace(cemuPath)
 		config.ShowDonationBar = showDonationBarCheck.GetActive()
 		config.GetSizeOnQueue = getSizeOnQueueCheck.GetActive()
 
@@ -269,6 +508,16 @@ func NewConfigWindow(config *Config) (*ConfigWindow, error) {
 	return &configWindow, nil
 }
 
+func splitCommaList(text string) []string {
+	var items []string
+	for _, item := range strings.Split(text, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
 func addStyleClass(getStyleContext func() (*gtk.StyleContext, error), className string) {
 	styleContext, err := getStyleContext()
 	if err != nil || styleContext == nil {
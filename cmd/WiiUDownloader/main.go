@@ -64,6 +64,12 @@ func main() {
 	if config == nil {
 		config = getDefaultConfig()
 	}
+	if err := wiiudownloader.SetCDNMirrors(config.CDNMirrors); err != nil {
+		log.Printf("error applying CDN mirrors: %v", err)
+	}
+	if err := applyBandwidthSettings(config.BandwidthLimit, config.BandwidthSchedule); err != nil {
+		log.Printf("error applying bandwidth settings: %v", err)
+	}
 
 	if settings, err := gtk.SettingsGetDefault(); err != nil {
 		log.Printf("error getting gtk settings: %v", err)
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -9,6 +10,7 @@ import (
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -141,6 +143,7 @@ func NewMainWindow(entries []wiiudownloader.TitleEntry, client *http.Client, con
 	searchEntry.Connect("changed", mainWindow.onSearchEntryChanged)
 
 	mainWindow.queuePane.SetDownloadCallback(mainWindow.onDownloadQueueButtonClicked)
+	mainWindow.queuePane.SetVersionPickerCallback(mainWindow.showVersionPickerDialog)
 
 	return &mainWindow
 }
@@ -385,7 +388,7 @@ func (mw *MainWindow) BuildUI() {
 
 		mw.progressWindow.Window.ShowAll()
 		go func() {
-			if err := mw.onDecryptContentsMenuItemClicked(selectedPath); err != nil {
+			if err := mw.onDecryptContentsMenuItemClicked(selectedPath, wiiudownloader.PathFilter{}); err != nil {
 				uiIdleAdd(func() {
 					mw.showError(err)
 				})
@@ -394,6 +397,84 @@ func (mw *MainWindow) BuildUI() {
 	})
 	toolsSubMenu.Append(decryptContentsMenuItem)
 
+	decryptSelectedFilesMenuItem, err := gtk.MenuItemNewWithLabel("Decrypt selected files")
+	if err != nil {
+		log.Fatalln("Unable to create menu item:", err)
+	}
+	decryptSelectedFilesMenuItem.ToWidget().SetProperty("tooltip-text", "Decrypt selected files - Select a game directory and extract only the files matching a set of patterns")
+	decryptSelectedFilesMenuItem.Connect("activate", func() {
+		selectedPath, err := dialog.Directory().Title("Select the game path").Browse()
+		if err != nil {
+			return
+		}
+		filter, ok := mw.showPathFilterDialog()
+		if !ok {
+			return
+		}
+
+		mw.progressWindow, err = createProgressWindow(mw.window)
+		if err != nil {
+			log.Printf("Failed to create progress window: %v", err)
+			return
+		}
+		mw.progressWindow.SetGameTitle(filepath.Base(selectedPath))
+		mw.progressWindow.Window.ShowAll()
+		go func() {
+			if err := mw.onDecryptContentsMenuItemClicked(selectedPath, filter); err != nil {
+				uiIdleAdd(func() {
+					mw.showError(err)
+				})
+			}
+		}()
+	})
+	toolsSubMenu.Append(decryptSelectedFilesMenuItem)
+
+	verifyTitleMenuItem, err := gtk.MenuItemNewWithLabel("Verify title")
+	if err != nil {
+		log.Fatalln("Unable to create menu item:", err)
+	}
+	verifyTitleMenuItem.ToWidget().SetProperty("tooltip-text", "Verify title - Check a downloaded game directory for damaged or missing contents without decrypting it")
+	verifyTitleMenuItem.Connect("activate", func() {
+		selectedPath, err := dialog.Directory().Title("Select the game path").Browse()
+		if err != nil {
+			return
+		}
+
+		mw.progressWindow, err = createProgressWindow(mw.window)
+		if err != nil {
+			log.Printf("Failed to create progress window: %v", err)
+			return
+		}
+		mw.progressWindow.Window.SetTitle("WiiUDownloader - Verifying")
+		mw.progressWindow.SetGameTitle(filepath.Base(selectedPath))
+		mw.progressWindow.Window.ShowAll()
+		go mw.onVerifyTitleMenuItemClicked(selectedPath)
+	})
+	toolsSubMenu.Append(verifyTitleMenuItem)
+
+	repairTitleMenuItem, err := gtk.MenuItemNewWithLabel("Repair title")
+	if err != nil {
+		log.Fatalln("Unable to create menu item:", err)
+	}
+	repairTitleMenuItem.ToWidget().SetProperty("tooltip-text", "Repair title - Download only the damaged or missing contents of a game directory again")
+	repairTitleMenuItem.Connect("activate", func() {
+		selectedPath, err := dialog.Directory().Title("Select the game path").Browse()
+		if err != nil {
+			return
+		}
+
+		mw.progressWindow, err = createProgressWindow(mw.window)
+		if err != nil {
+			log.Printf("Failed to create progress window: %v", err)
+			return
+		}
+		mw.progressWindow.Window.SetTitle("WiiUDownloader - Repairing")
+		mw.progressWindow.SetGameTitle(filepath.Base(selectedPath))
+		mw.progressWindow.Window.ShowAll()
+		go mw.onRepairTitleMenuItemClicked(selectedPath)
+	})
+	toolsSubMenu.Append(repairTitleMenuItem)
+
 	generateFakeTicketCert, err := gtk.MenuItemNewWithLabel("Generate fake ticket and cert")
 	if err != nil {
 		log.Fatalln("Unable to create menu item:", err)
@@ -846,8 +927,11 @@ func shouldShowQueueErrorSummary(runErr error, errors []DownloadError) bool {
 	return runErr == nil && len(errors) > 0
 }
 
-func (mw *MainWindow) onDecryptContentsMenuItemClicked(selectedPath string) error {
-	err := wiiudownloader.DecryptContents(selectedPath, mw.progressWindow, false)
+func (mw *MainWindow) onDecryptContentsMenuItemClicked(selectedPath string, filter wiiudownloader.PathFilter) error {
+	err := wiiudownloader.DecryptContentsWithOptions(context.Background(), selectedPath, wiiudownloader.DecryptContentsOptions{
+		ProgressReporter: mw.progressWindow,
+		Filter:           filter,
+	})
 
 	uiIdleAdd(func() {
 		mw.progressWindow.Window.Hide()
@@ -866,6 +950,153 @@ func (mw *MainWindow) onDecryptContentsMenuItemClicked(selectedPath string) erro
 	return err
 }
 
+func (mw *MainWindow) onVerifyTitleMenuItemClicked(selectedPath string) {
+	report, err := wiiudownloader.VerifyTitle(selectedPath, mw.progressWindow)
+
+	uiIdleAdd(func() {
+		mw.progressWindow.Window.Hide()
+		switch {
+		case errors.Is(err, wiiudownloader.ErrCancelled):
+		case err != nil:
+			mw.showError(err)
+		default:
+			mw.showVerifyReportDialog(selectedPath, report)
+		}
+	})
+}
+
+func (mw *MainWindow) onRepairTitleMenuItemClicked(selectedPath string) {
+	result, err := wiiudownloader.RepairTitle(context.Background(), selectedPath, wiiudownloader.RepairTitleOptions{
+		Client:           mw.client,
+		ProgressReporter: mw.progressWindow,
+	})
+
+	uiIdleAdd(func() {
+		mw.progressWindow.Window.Hide()
+		switch {
+		case errors.Is(err, wiiudownloader.ErrCancelled):
+		case err != nil:
+			mw.showError(err)
+		default:
+			name := filepath.Base(selectedPath)
+			summary := fmt.Sprintf("All %d contents of %s are intact, nothing to repair.", len(result.Scan.Contents), name)
+			switch {
+			case len(result.Unrepaired) > 0:
+				summary = fmt.Sprintf("%d of %d damaged contents of %s could not be repaired.", len(result.Unrepaired), len(result.Repaired)+len(result.Unrepaired), name)
+			case len(result.Repaired) > 0:
+				summary = fmt.Sprintf("Repaired %d of %d contents of %s (%s downloaded).", len(result.Repaired), len(result.Scan.Contents), name, formatBytes(uint64(result.BytesTransferred)))
+			}
+			mw.showContentReportDialog("Repair Report", summary, "Repaired", append(result.Repaired, result.Unrepaired...))
+		}
+	})
+}
+
+func (mw *MainWindow) showVerifyReportDialog(path string, report *wiiudownloader.VerifyReport) {
+	failed := report.Failed()
+	summary := fmt.Sprintf("All %d contents of %s are intact.", len(report.Contents), filepath.Base(path))
+	if len(failed) > 0 {
+		summary = fmt.Sprintf("%d of %d contents of %s failed verification.", len(failed), len(report.Contents), filepath.Base(path))
+	}
+	mw.showContentReportDialog("Verification Report", summary, "OK", report.Contents)
+}
+
+// showContentReportDialog lists the outcome for each content; okStatus labels
+// the contents without an error.
+func (mw *MainWindow) showContentReportDialog(title, summary, okStatus string, contents []wiiudownloader.ContentReport) {
+	dialog, err := gtk.DialogNew()
+	if err != nil {
+		log.Printf("Error creating dialog: %v", err)
+		return
+	}
+	defer dialog.Destroy()
+
+	dialog.SetTitle(title)
+	dialog.SetModal(true)
+	dialog.SetTransientFor(mw.window)
+	dialog.SetPosition(gtk.WIN_POS_CENTER_ON_PARENT)
+	dialog.SetDefaultSize(ERROR_DIALOG_WIDTH, ERROR_DIALOG_HEIGHT)
+
+	contentArea, err := dialog.GetContentArea()
+	if err != nil {
+		return
+	}
+
+	headerLabel, err := gtk.LabelNew(summary)
+	if err != nil {
+		return
+	}
+	headerLabel.SetMarginTop(DIALOG_MARGIN)
+	headerLabel.SetMarginBottom(DIALOG_MARGIN)
+	headerLabel.SetMarginStart(DIALOG_MARGIN)
+	headerLabel.SetLineWrap(true)
+	contentArea.PackStart(headerLabel, false, false, 0)
+
+	scrolledWindow, err := gtk.ScrolledWindowNew(nil, nil)
+	if err != nil {
+		return
+	}
+	scrolledWindow.SetPolicy(gtk.POLICY_NEVER, gtk.POLICY_AUTOMATIC)
+	scrolledWindow.SetMarginStart(DIALOG_MARGIN)
+	scrolledWindow.SetMarginEnd(DIALOG_MARGIN)
+	contentArea.PackStart(scrolledWindow, true, true, 0)
+
+	listBox, err := gtk.ListBoxNew()
+	if err != nil {
+		return
+	}
+	listBox.SetSelectionMode(gtk.SELECTION_NONE)
+	scrolledWindow.Add(listBox)
+
+	for _, content := range contents {
+		row, err := gtk.ListBoxRowNew()
+		if err != nil {
+			continue
+		}
+		box, err := gtk.BoxNew(gtk.ORIENTATION_VERTICAL, 5)
+		if err != nil {
+			continue
+		}
+		box.SetMarginTop(ERROR_ROW_MARGIN)
+		box.SetMarginBottom(ERROR_ROW_MARGIN)
+		box.SetMarginStart(ERROR_ROW_MARGIN)
+		box.SetMarginEnd(ERROR_ROW_MARGIN)
+
+		check := "SHA-1"
+		if content.Hashed {
+			check = "hash tree"
+		}
+		status := fmt.Sprintf("<span foreground='#16a34a'>%s</span>", escapeMarkup(okStatus))
+		if content.Err != nil {
+			status = fmt.Sprintf("<span foreground='#dc2626'>%s</span>", escapeMarkup(detectErrorType(content.Err)))
+		}
+		titleLabel, err := gtk.LabelNew("")
+		if err != nil {
+			continue
+		}
+		titleLabel.SetMarkup(fmt.Sprintf("<b>%s.app</b> (%s, %s): %s", content.Content.CIDStr, formatBytes(content.Content.Size), check, status))
+		titleLabel.SetXAlign(0)
+		box.PackStart(titleLabel, false, false, 0)
+
+		if content.Err != nil {
+			errorLabel, err := gtk.LabelNew(content.Err.Error())
+			if err != nil {
+				continue
+			}
+			errorLabel.SetXAlign(0)
+			errorLabel.SetLineWrap(true)
+			errorLabel.SetLineWrapMode(pango.WRAP_WORD)
+			box.PackStart(errorLabel, false, false, 0)
+		}
+
+		row.Add(box)
+		listBox.Add(row)
+	}
+
+	dialog.AddButton("Close", gtk.RESPONSE_OK)
+	contentArea.ShowAll()
+	dialog.Run()
+}
+
 func (mw *MainWindow) setupDonationBar() {
 	bar, err := gtk.BoxNew(gtk.ORIENTATION_HORIZONTAL, 12)
 	if err != nil {
@@ -1620,6 +1851,16 @@ func (mw *MainWindow) showErrorsDialog(errors []DownloadError) {
 			box.PackStart(errorTypeLabel, false, false, 0)
 		}
 
+		if dlErr.Mirror != "" {
+			mirrorLabel, err := gtk.LabelNew("")
+			if err != nil {
+				continue
+			}
+			mirrorLabel.SetMarkup(fmt.Sprintf("<i>Mirror: %s</i>", escapeMarkup(dlErr.Mirror)))
+			mirrorLabel.SetXAlign(0)
+			box.PackStart(mirrorLabel, false, false, 0)
+		}
+
 		errorLabel, err := gtk.LabelNew(dlErr.Error)
 		if err != nil {
 			continue
@@ -1701,6 +1942,8 @@ func (mw *MainWindow) onDownloadQueueClicked(selectedPath string, decryptContent
 	mw.progressWindow.ResetTotalsAndErrors()
 
 	totalInQueue := mw.queuePane.GetTitleQueueSize()
+	// Titles are downloaded one at a time, so downloaded needs no lock.
+	var downloaded []string
 	mw.queuePane.ForEachRemoving(func(title wiiudownloader.TitleEntry) bool {
 		if mw.progressWindow.Cancelled() {
 			return false
@@ -1712,11 +1955,29 @@ func (mw *MainWindow) onDownloadQueueClicked(selectedPath string, decryptContent
 				return nil
 			}
 			tidStr := fmt.Sprintf("%016x", title.TitleID)
-			titlePath := filepath.Join(selectedPath, fmt.Sprintf("%s [%s] [%s]", normalizeFilename(title.Name), wiiudownloader.GetFormattedKind(title.TitleID), tidStr))
-			downloadErr := wiiudownloader.DownloadTitle(tidStr, titlePath, decryptContents, mw.progressWindow, deleteEncryptedContents, mw.client)
+			version := mw.queuePane.GetTitleVersion(title.TitleID)
+			titleFolder := fmt.Sprintf("%s [%s] [%s]", wiiudownloader.NormalizeFilename(title.Name), wiiudownloader.GetFormattedKind(title.TitleID), tidStr)
+			if version != nil {
+				titleFolder += fmt.Sprintf(" [v%d]", *version)
+			}
+			titlePath := filepath.Join(selectedPath, titleFolder)
+			_, downloadErr := wiiudownloader.DownloadTitleWithOptions(context.Background(), title.TitleID, wiiudownloader.DownloadTitleOptions{
+				OutputDirectory:         titlePath,
+				Decrypt:                 decryptContents,
+				DeleteEncryptedContents: deleteEncryptedContents,
+				Client:                  mw.client,
+				ProgressReporter:        mw.progressWindow,
+				TitleVersion:            version,
+				DecryptWhileDownloading: config.DecryptWhileDownloading,
+				Filter: wiiudownloader.PathFilter{
+					Include: config.ExtractInclude,
+					Exclude: config.ExtractExclude,
+				},
+				MatchingContentsOnly: config.DownloadMatchingOnly,
+			})
 
-			if downloadErr != nil && downloadErr != context.Canceled {
-				errorType := detectErrorType(downloadErr.Error())
+			if downloadErr != nil && !errors.Is(downloadErr, wiiudownloader.ErrCancelled) {
+				errorType := detectErrorType(downloadErr)
 				mw.progressWindow.AddErrorWithType(title.Name, downloadErr.Error(), tidStr, errorType)
 
 				if config.ContinueOnError {
@@ -1726,6 +1987,9 @@ func (mw *MainWindow) onDownloadQueueClicked(selectedPath string, decryptContent
 				queueStatusChan <- false
 				return downloadErr
 			}
+			if downloadErr == nil {
+				downloaded = append(downloaded, titlePath)
+			}
 
 			queueStatusChan <- true
 			return nil
@@ -1744,6 +2008,10 @@ func (mw *MainWindow) onDownloadQueueClicked(selectedPath string, decryptContent
 		}
 	})
 
+	if decryptContents && config.CemuLayout != "" && len(downloaded) > 0 && !mw.progressWindow.Cancelled() {
+		mw.installForCemu(downloaded, config)
+	}
+
 	uiIdleAdd(func() {
 		mw.progressWindow.Window.Hide()
 		mw.updateTitlesInQueue()
@@ -1757,6 +2025,18 @@ func (mw *MainWindow) onDownloadQueueClicked(selectedPath string, decryptContent
 	return err
 }
 
+// installForCemu installs the decrypted title folders as set up in the
+// Downloads tab of the settings, reporting failures in the progress window.
+func (mw *MainWindow) installForCemu(folders []string, config *Config) {
+	layout, err := wiiudownloader.ParseCemuLayout(config.CemuLayout)
+	if err == nil {
+		_, err = wiiudownloader.InstallCemuTitles(folders, config.CemuPath, layout)
+	}
+	if err != nil {
+		mw.progressWindow.AddErrorWithType("Cemu install", err.Error(), "", detectErrorType(err))
+	}
+}
+
 func (mw *MainWindow) collectTIDs(titles []wiiudownloader.TitleEntry) []uint64 {
 	tids := make([]uint64, len(titles))
 	for i, t := range titles {
@@ -1788,31 +2068,198 @@ func (mw *MainWindow) addTitlesToQueue(titles []wiiudownloader.TitleEntry) {
 	}
 
 	for _, entry := range toAdd {
-		go func(e wiiudownloader.TitleEntry) {
-			// Acquire semaphore
-			mw.sizeFetchSemaphore <- struct{}{}
-			defer func() { <-mw.sizeFetchSemaphore }()
+		go mw.fetchQueuedTitleSize(entry)
+	}
+}
 
-			if !mw.queuePane.IsTitleInQueue(e) {
-				return
-			}
+func (mw *MainWindow) fetchQueuedTitleSize(e wiiudownloader.TitleEntry) {
+	// Acquire semaphore
+	mw.sizeFetchSemaphore <- struct{}{}
+	defer func() { <-mw.sizeFetchSemaphore }()
 
-			size, err := fetchTMDSize(e.TitleID, mw.client)
+	if !mw.queuePane.IsTitleInQueue(e) {
+		return
+	}
 
-			if !mw.queuePane.IsTitleInQueue(e) {
+	size, err := fetchTMDSize(e.TitleID, mw.queuePane.GetTitleVersion(e.TitleID), mw.client)
+
+	if !mw.queuePane.IsTitleInQueue(e) {
+		return
+	}
+
+	uiIdleAdd(func() {
+		if err != nil {
+			log.Printf("Failed to fetch size for %016x: %v", e.TitleID, err)
+			mw.queuePane.SetTitleError(e.TitleID)
+		} else {
+			mw.queuePane.SetTitleSize(e.TitleID, size)
+		}
+	})
+}
+
+// showVersionPickerDialog lets the user pin a queued update to one of the
+// versions still available on the CDN.
+func (mw *MainWindow) showVersionPickerDialog(title wiiudownloader.TitleEntry) {
+	dialog, err := gtk.DialogNew()
+	if err != nil {
+		log.Printf("Error creating dialog: %v", err)
+		return
+	}
+	defer dialog.Destroy()
+
+	dialog.SetTitle("Choose Version")
+	dialog.SetTransientFor(mw.window)
+	dialog.SetModal(true)
+	dialog.AddButton("Cancel", gtk.RESPONSE_CANCEL)
+	dialog.AddButton("Select", gtk.RESPONSE_ACCEPT)
+
+	contentArea, err := dialog.GetContentArea()
+	if err != nil {
+		return
+	}
+	contentArea.SetSpacing(10)
+	contentArea.SetMarginTop(10)
+	contentArea.SetMarginBottom(10)
+	contentArea.SetMarginStart(10)
+	contentArea.SetMarginEnd(10)
+
+	label, _ := gtk.LabelNew(fmt.Sprintf("Version of %s to download:", title.Name))
+	label.SetHAlign(gtk.ALIGN_START)
+	contentArea.PackStart(label, false, false, 0)
+
+	combo, _ := gtk.ComboBoxTextNew()
+	combo.AppendText(formatTitleVersion(nil))
+	combo.SetActive(0)
+	contentArea.PackStart(combo, false, false, 0)
+
+	statusLabel, _ := gtk.LabelNew("Looking up available versions...")
+	statusLabel.SetHAlign(gtk.ALIGN_START)
+	contentArea.PackStart(statusLabel, false, false, 0)
+
+	dialog.SetDefaultResponse(gtk.RESPONSE_ACCEPT)
+	dialog.ShowAll()
+
+	// versions is only touched on the UI thread; closed keeps the lookup from
+	// updating widgets once the dialog is gone.
+	var versions []uint16
+	closed := false
+	current := mw.queuePane.GetTitleVersion(title.TitleID)
+	go func() {
+		listed, err := wiiudownloader.ListTitleVersions(context.Background(), mw.client, wiiudownloader.DEFAULT_USER_AGENT, title.TitleID)
+		uiIdleAdd(func() {
+			if closed {
 				return
 			}
-
-			uiIdleAdd(func() {
-				if err != nil {
-					log.Printf("Failed to fetch size for %016x: %v", e.TitleID, err)
-					mw.queuePane.SetTitleError(e.TitleID)
-				} else {
-					mw.queuePane.SetTitleSize(e.TitleID, size)
+			if err != nil {
+				statusLabel.SetText(fmt.Sprintf("Could not list versions: %v", err))
+				return
+			}
+			status := fmt.Sprintf("%d version(s) available", len(listed.Available))
+			if len(listed.Unknown) > 0 {
+				status += fmt.Sprintf(", %d could not be checked", len(listed.Unknown))
+			}
+			if listed.Truncated {
+				status += fmt.Sprintf(", only the newest %d were looked up", wiiudownloader.MAX_TITLE_VERSION_PROBES)
+			}
+			statusLabel.SetText(status)
+			// Versions that could not be checked are offered too, since the
+			// CDN may well serve them.
+			all := slices.Concat(listed.Available, listed.Unknown)
+			slices.Sort(all)
+			for i := len(all) - 1; i >= 0; i-- {
+				versions = append(versions, all[i])
+				label := formatTitleVersion(&all[i])
+				if !slices.Contains(listed.Available, all[i]) {
+					label += " (unchecked)"
 				}
-			})
-		}(entry)
+				combo.AppendText(label)
+				if current != nil && *current == all[i] {
+					combo.SetActive(len(versions))
+				}
+			}
+		})
+	}()
+
+	response := dialog.Run()
+	closed = true
+	if response != gtk.RESPONSE_ACCEPT {
+		return
+	}
+
+	var selected *uint16
+	if active := combo.GetActive(); active > 0 && active <= len(versions) {
+		selected = &versions[active-1]
+	}
+	mw.queuePane.SetTitleVersion(title.TitleID, selected)
+
+	if config, _ := loadConfig(); config.GetSizeOnQueue {
+		mw.queuePane.SetTitleLoading(title.TitleID)
+		go mw.fetchQueuedTitleSize(title)
+	}
+}
+
+// showPathFilterDialog asks for the patterns of the files to extract,
+// starting from the ones in the settings. ok is false when the dialog is
+// cancelled.
+func (mw *MainWindow) showPathFilterDialog() (filter wiiudownloader.PathFilter, ok bool) {
+	dialog, err := gtk.DialogNew()
+	if err != nil {
+		log.Printf("Error creating dialog: %v", err)
+		return filter, false
+	}
+	defer dialog.Destroy()
+
+	dialog.SetTitle("Decrypt Selected Files")
+	dialog.SetTransientFor(mw.window)
+	dialog.SetModal(true)
+	dialog.AddButton("Cancel", gtk.RESPONSE_CANCEL)
+	dialog.AddButton("Decrypt", gtk.RESPONSE_ACCEPT)
+
+	contentArea, err := dialog.GetContentArea()
+	if err != nil {
+		return filter, false
+	}
+	contentArea.SetSpacing(10)
+	contentArea.SetMarginTop(10)
+	contentArea.SetMarginBottom(10)
+	contentArea.SetMarginStart(10)
+	contentArea.SetMarginEnd(10)
+
+	config, _ := loadConfig()
+	newEntry := func(labelText, placeholder string, patterns []string) *gtk.Entry {
+		label, _ := gtk.LabelNew(labelText)
+		label.SetHAlign(gtk.ALIGN_START)
+		contentArea.PackStart(label, false, false, 0)
+
+		entry, _ := gtk.EntryNew()
+		entry.SetPlaceholderText(placeholder)
+		entry.SetWidthChars(SETTINGS_ENTRY_WIDTH_CHARS)
+		entry.SetActivatesDefault(true)
+		entry.SetText(strings.Join(patterns, ", "))
+		contentArea.PackStart(entry, false, false, 0)
+		return entry
+	}
+	var include, exclude []string
+	if config != nil {
+		include, exclude = config.ExtractInclude, config.ExtractExclude
+	}
+	includeEntry := newEntry("Extract files matching (comma separated, e.g. content/Common, *.rpx):", "all files", include)
+	excludeEntry := newEntry("Skip files matching (comma separated):", "no files", exclude)
+
+	dialog.SetDefaultResponse(gtk.RESPONSE_ACCEPT)
+	dialog.ShowAll()
+
+	for dialog.Run() == gtk.RESPONSE_ACCEPT {
+		includeText, _ := includeEntry.GetText()
+		excludeText, _ := excludeEntry.GetText()
+		filter = wiiudownloader.PathFilter{Include: splitCommaList(includeText), Exclude: splitCommaList(excludeText)}
+		if err := filter.Validate(); err != nil {
+			ShowErrorDialog(&dialog.Window, err)
+			continue
+		}
+		return filter, true
 	}
+	return filter, false
 }
 
 func (mw *MainWindow) showAddByTitleIDDialog() {
@@ -2,11 +2,13 @@ package main
 
 import (
 	"fmt"
+	"log"
 	"sync"
 	"time"
 
 	"github.com/gotk3/gotk3/gdk"
 	"github.com/gotk3/gotk3/gtk"
+	"github.com/gotk3/gotk3/pango"
 )
 
 type DownloadError struct {
@@ -14,6 +16,8 @@ type DownloadError struct {
 	Error     string
 	TidStr    string
 	ErrorType string
+	// Mirror is the CDN mirror that served the last file before the error.
+	Mirror string
 }
 
 const (
@@ -76,6 +80,7 @@ type ProgressWindow struct {
 	box             *gtk.Box
 	gameLabel       *gtk.Label
 	bar             *gtk.ProgressBar
+	fileLabel       *gtk.Label
 	pauseButton     *gtk.Button
 	cancelButton    *gtk.Button
 	cancelled       bool
@@ -83,6 +88,8 @@ type ProgressWindow struct {
 	totalToDownload int64
 	totalDownloaded int64
 	progressPerFile map[string]int64
+	fileMirrors     map[string]string
+	lastMirror      string
 	progressMutex   sync.Mutex
 	controlMutex    sync.Mutex
 	controlCond     *sync.Cond
@@ -262,11 +269,14 @@ func (pw *ProgressWindow) ResetTotals() {
 		}
 		pw.bar.SetFraction(0)
 		pw.bar.SetText("Preparing...")
+		pw.fileLabel.SetText("")
 	})
 	pw.resetTransferState()
 	pw.progressMutex.Lock()
 	defer pw.progressMutex.Unlock()
 	pw.progressPerFile = make(map[string]int64)
+	pw.fileMirrors = make(map[string]string)
+	pw.lastMirror = ""
 	pw.totalDownloaded = 0
 	pw.totalToDownload = 0
 }
@@ -279,11 +289,14 @@ func (pw *ProgressWindow) ResetTotalsAndErrors() {
 		}
 		pw.bar.SetFraction(0)
 		pw.bar.SetText("Preparing...")
+		pw.fileLabel.SetText("")
 	})
 	pw.resetTransferState()
 	pw.progressMutex.Lock()
 	defer pw.progressMutex.Unlock()
 	pw.progressPerFile = make(map[string]int64)
+	pw.fileMirrors = make(map[string]string)
+	pw.lastMirror = ""
 	pw.totalDownloaded = 0
 	pw.totalToDownload = 0
 	pw.ClearErrors()
@@ -302,6 +315,33 @@ func (pw *ProgressWindow) SetTotalDownloadedForFile(filename string, downloaded
 	pw.progressMutex.Unlock()
 }
 
+// SetFileMirror shows which mirror the file being downloaded comes from, and
+// remembers it for the errors added afterwards.
+func (pw *ProgressWindow) SetFileMirror(filename, mirror string) {
+	pw.progressMutex.Lock()
+	if pw.fileMirrors == nil {
+		pw.fileMirrors = make(map[string]string)
+	}
+	text := fmt.Sprintf("%s from %s", filename, mirror)
+	if previous, ok := pw.fileMirrors[filename]; ok && previous != mirror {
+		log.Printf("%s: switching from mirror %s to %s", filename, previous, mirror)
+		text = fmt.Sprintf("%s from %s (switched from %s)", filename, mirror, previous)
+	}
+	pw.fileMirrors[filename] = mirror
+	pw.lastMirror = mirror
+	pw.progressMutex.Unlock()
+
+	uiIdleAdd(func() {
+		pw.fileLabel.SetText(text)
+	})
+}
+
+func (pw *ProgressWindow) currentMirror() string {
+	pw.progressMutex.Lock()
+	defer pw.progressMutex.Unlock()
+	return pw.lastMirror
+}
+
 func (pw *ProgressWindow) SetStartTime(startTime time.Time) {
 	pw.progressMutex.Lock()
 	defer pw.progressMutex.Unlock()
@@ -309,16 +349,19 @@ func (pw *ProgressWindow) SetStartTime(startTime time.Time) {
 }
 
 func (pw *ProgressWindow) AddError(title, errorMsg, tidStr string) {
+	mirror := pw.currentMirror()
 	pw.errorsMutex.Lock()
 	defer pw.errorsMutex.Unlock()
 	pw.errors = append(pw.errors, DownloadError{
 		Title:  title,
 		Error:  errorMsg,
 		TidStr: tidStr,
+		Mirror: mirror,
 	})
 }
 
 func (pw *ProgressWindow) AddErrorWithType(title, errorMsg, tidStr, errorType string) {
+	mirror := pw.currentMirror()
 	pw.errorsMutex.Lock()
 	defer pw.errorsMutex.Unlock()
 	pw.errors = append(pw.errors, DownloadError{
@@ -326,6 +369,7 @@ func (pw *ProgressWindow) AddErrorWithType(title, errorMsg, tidStr, errorType st
 		Error:     errorMsg,
 		TidStr:    tidStr,
 		ErrorType: errorType,
+		Mirror:    mirror,
 	})
 }
 
@@ -386,6 +430,15 @@ func createProgressWindow(parent *gtk.Window) (*ProgressWindow, error) {
 	progressBar.ToWidget().SetProperty("tooltip-text", "Download progress bar - Shows current download status, speed, and bytes downloaded")
 	box.PackStart(progressBar, false, false, 0)
 
+	fileLabel, err := gtk.LabelNew("")
+	if err != nil {
+		return nil, err
+	}
+	fileLabel.SetEllipsize(pango.ELLIPSIZE_MIDDLE)
+	addStyleClass(fileLabel.GetStyleContext, "dim-label")
+	SetupLabelAccessibility(fileLabel, "Current file and mirror label")
+	box.PackStart(fileLabel, false, false, 0)
+
 	cancelButton, err := gtk.ButtonNew()
 	if err != nil {
 		return nil, err
@@ -427,6 +480,7 @@ func createProgressWindow(parent *gtk.Window) (*ProgressWindow, error) {
 		box:           box,
 		gameLabel:     gameLabel,
 		bar:           progressBar,
+		fileLabel:     fileLabel,
 		pauseButton:   pauseButton,
 		cancelButton:  cancelButton,
 		cancelled:     false,
@@ -11,13 +11,14 @@ import (
 )
 
 const (
-	QUEUE_NAME_COLUMN_MAX_WIDTH   = 200
-	QUEUE_REGION_COLUMN_MAX_WIDTH = 70
-	QUEUE_KIND_COLUMN_MAX_WIDTH   = 90
-	QUEUE_SIZE_COLUMN_MAX_WIDTH   = 100
-	QUEUE_BUTTON_HEIGHT           = 42
-	TID_BASE_16                   = 16
-	TID_BITS_64                   = 64
+	QUEUE_NAME_COLUMN_MAX_WIDTH    = 200
+	QUEUE_REGION_COLUMN_MAX_WIDTH  = 70
+	QUEUE_KIND_COLUMN_MAX_WIDTH    = 90
+	QUEUE_SIZE_COLUMN_MAX_WIDTH    = 100
+	QUEUE_VERSION_COLUMN_MAX_WIDTH = 70
+	QUEUE_BUTTON_HEIGHT            = 42
+	TID_BASE_16                    = 16
+	TID_BITS_64                    = 64
 )
 
 type QueuePane struct {
@@ -30,7 +31,9 @@ type QueuePane struct {
 	store                 *gtk.ListStore
 	titleSizes            map[uint64]string
 	titleBytes            map[uint64]uint64
+	titleVersions         *Locked[map[uint64]uint16]
 	updateFunc            func()
+	versionPickerFunc     func(wiiudownloader.TitleEntry)
 }
 
 func createColumn(renderer *gtk.CellRendererText, title string, id int) (*gtk.TreeViewColumn, error) {
@@ -44,7 +47,7 @@ func NewQueuePane() (*QueuePane, error) {
 	}
 	scrolledWindow.SetPolicy(gtk.POLICY_AUTOMATIC, gtk.POLICY_AUTOMATIC)
 
-	store, err := gtk.ListStoreNew(glib.TYPE_STRING, glib.TYPE_STRING, glib.TYPE_STRING, glib.TYPE_STRING, glib.TYPE_STRING)
+	store, err := gtk.ListStoreNew(glib.TYPE_STRING, glib.TYPE_STRING, glib.TYPE_STRING, glib.TYPE_STRING, glib.TYPE_STRING, glib.TYPE_STRING)
 	if err != nil {
 		return nil, err
 	}
@@ -59,7 +62,7 @@ func NewQueuePane() (*QueuePane, error) {
 	}
 	selection.SetMode(gtk.SELECTION_MULTIPLE)
 	SetupTreeViewAccessibility(titleTreeView)
-	titleTreeView.ToWidget().SetProperty("tooltip-text", "Download queue - Shows games queued for download. Use arrow keys to navigate, space to select/deselect, or click Remove from Queue button to remove selected titles")
+	titleTreeView.ToWidget().SetProperty("tooltip-text", "Download queue - Shows games queued for download. Use arrow keys to navigate, space to select/deselect, or click Remove from Queue button to remove selected titles. Double-click an update to choose its version")
 
 	titleTreeView.SetModel(store)
 
@@ -95,6 +98,13 @@ func NewQueuePane() (*QueuePane, error) {
 	sizeColumn.SetMaxWidth(QUEUE_SIZE_COLUMN_MAX_WIDTH)
 	titleTreeView.AppendColumn(sizeColumn)
 
+	versionColumn, err := createColumn(renderer, "Version", 5)
+	if err != nil {
+		return nil, err
+	}
+	versionColumn.SetMaxWidth(QUEUE_VERSION_COLUMN_MAX_WIDTH)
+	titleTreeView.AppendColumn(versionColumn)
+
 	titleTreeView.SetExpanderColumn(nameColumn)
 
 	scrolledWindow.Add(titleTreeView)
@@ -163,8 +173,38 @@ func NewQueuePane() (*QueuePane, error) {
 		totalSizeLabel:        totalSizeLabel,
 		titleSizes:            make(map[uint64]string),
 		titleBytes:            make(map[uint64]uint64),
+		titleVersions:         NewLocked(make(map[uint64]uint16)),
 	}
 
+	titleTreeView.Connect("row-activated", func(_ *gtk.TreeView, path *gtk.TreePath) {
+		if queuePane.versionPickerFunc == nil {
+			return
+		}
+		iter, err := store.GetIter(path)
+		if err != nil {
+			return
+		}
+		tid, err := store.GetValue(iter, 3)
+		if err != nil {
+			return
+		}
+		defer tid.Unset()
+		tidStr, err := tid.GetString()
+		if err != nil {
+			return
+		}
+		tidParsed, err := strconv.ParseUint(tidStr, TID_BASE_16, TID_BITS_64)
+		if err != nil || wiiudownloader.GetTitleIDHigh(tidParsed) != wiiudownloader.TID_HIGH_UPDATE {
+			return
+		}
+		for _, title := range queuePane.GetTitleQueue() {
+			if title.TitleID == tidParsed {
+				queuePane.versionPickerFunc(title)
+				return
+			}
+		}
+	})
+
 	removeFromQueueButton.Connect("clicked", func() {
 		selection, err := titleTreeView.GetSelection()
 		if err != nil {
@@ -224,18 +264,7 @@ func NewQueuePane() (*QueuePane, error) {
 			}
 		}
 
-		queuePane.titleQueue.WithLock(func(queue *[]wiiudownloader.TitleEntry) {
-			for _, tidToRemove := range titlesToRemove {
-				for i, t := range *queue {
-					if t.TitleID == tidToRemove {
-						*queue = append((*queue)[:i], (*queue)[i+1:]...)
-						break
-					}
-				}
-			}
-		})
-
-		queuePane.Update(true)
+		queuePane.RemoveTitles(titlesToRemove)
 	})
 
 	buttonBox, err := gtk.BoxNew(gtk.ORIENTATION_HORIZONTAL, 0)
@@ -278,6 +307,9 @@ func (qp *QueuePane) RemoveTitle(title wiiudownloader.TitleEntry) {
 			}
 		}
 	})
+	qp.titleVersions.WithLock(func(versions *map[uint64]uint16) {
+		delete(*versions, title.TitleID)
+	})
 	qp.Update(true)
 }
 
@@ -292,6 +324,11 @@ func (qp *QueuePane) RemoveTitles(titlesToRemove []uint64) {
 			}
 		}
 	})
+	qp.titleVersions.WithLock(func(versions *map[uint64]uint16) {
+		for _, rid := range titlesToRemove {
+			delete(*versions, rid)
+		}
+	})
 	qp.Update(true)
 }
 
@@ -299,6 +336,35 @@ func (qp *QueuePane) Clear() {
 	qp.titleQueue.WithLock(func(queue *[]wiiudownloader.TitleEntry) {
 		*queue = make([]wiiudownloader.TitleEntry, 0)
 	})
+	qp.titleVersions.WithLock(func(versions *map[uint64]uint16) {
+		*versions = make(map[uint64]uint16)
+	})
+}
+
+func (qp *QueuePane) SetVersionPickerCallback(f func(wiiudownloader.TitleEntry)) {
+	qp.versionPickerFunc = f
+}
+
+// SetTitleVersion pins a queued title to a version; nil downloads the latest one.
+func (qp *QueuePane) SetTitleVersion(titleID uint64, version *uint16) {
+	qp.titleVersions.WithLock(func(versions *map[uint64]uint16) {
+		if version == nil {
+			delete(*versions, titleID)
+		} else {
+			(*versions)[titleID] = *version
+		}
+	})
+	qp.Update(false)
+}
+
+func (qp *QueuePane) GetTitleVersion(titleID uint64) *uint16 {
+	var result *uint16
+	qp.titleVersions.WithRLock(func(versions map[uint64]uint16) {
+		if version, ok := versions[titleID]; ok {
+			result = &version
+		}
+	})
+	return result
 }
 
 func (qp *QueuePane) SetDownloadCallback(f func()) {
@@ -442,13 +508,14 @@ func (qp *QueuePane) Update(doUpdateFunc bool) {
 
 			qp.store.Set(
 				iter,
-				[]int{0, 1, 2, 3, 4},
+				[]int{0, 1, 2, 3, 4, 5},
 				[]interface{}{
 					title.Name,
 					wiiudownloader.GetFormattedRegion(title.Region),
 					wiiudownloader.GetFormattedKind(title.TitleID),
 					fmt.Sprintf("%016x", title.TitleID),
 					sizeStr,
+					formatTitleVersion(qp.GetTitleVersion(title.TitleID)),
 				},
 			)
 		}
@@ -496,3 +563,10 @@ func (qp *QueuePane) updateTotalSizeLabel() {
 	}
 	qp.totalSizeLabel.SetMarkup(fmt.Sprintf("<b>%s</b>", text))
 }
+
+func formatTitleVersion(version *uint16) string {
+	if version == nil {
+		return "Latest"
+	}
+	return fmt.Sprintf("v%d", *version)
+}
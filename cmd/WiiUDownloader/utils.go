@@ -1,10 +1,13 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"io"
+	"io/fs"
 	"log"
 	"math"
+	"net"
 	"net/http"
 	"os"
 	"strings"
@@ -32,66 +35,23 @@ func formatBytes(bytes uint64) string {
 	return fmt.Sprintf("%.2f %s", value, units[unitIndex])
 }
 
-func fetchTMDSize(titleID uint64, client *http.Client) (uint64, error) {
-	baseURL := fmt.Sprintf("http://ccs.cdn.c.shop.nintendowifi.net/ccs/download/%016x", titleID)
-	tmdURL := fmt.Sprintf("%s/tmd", baseURL)
-
-	resp, err := client.Get(tmdURL)
-	if err != nil {
-		return 0, err
+func fetchTMDSize(titleID uint64, version *uint16, client *http.Client) (uint64, error) {
+	var (
+		tmd *wiiudownloader.TMD
+		err error
+	)
+	if version != nil {
+		tmd, err = wiiudownloader.FetchTMDVersion(context.Background(), client, wiiudownloader.DEFAULT_USER_AGENT, titleID, *version)
+	} else {
+		tmd, err = wiiudownloader.FetchTMD(context.Background(), client, wiiudownloader.DEFAULT_USER_AGENT, titleID)
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return 0, fmt.Errorf("failed to fetch TMD: status %d", resp.StatusCode)
-	}
-
-	tmdData, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return 0, fmt.Errorf("failed to read TMD data: %w", err)
-	}
-
-	tmd, err := wiiudownloader.ParseTMD(tmdData)
-	if err != nil {
-		return 0, fmt.Errorf("failed to parse TMD: %w", err)
+		return 0, err
 	}
 
 	return tmd.CalculateTotalSize(), nil
 }
 
-func normalizeFilename(filename string) string {
-	var out strings.Builder
-	shouldAppend := true
-	firstChar := true
-
-	for _, c := range filename {
-		switch {
-		case c == '_':
-			if shouldAppend {
-				out.WriteRune('_')
-				shouldAppend = false
-			}
-			firstChar = false
-		case c == ' ':
-			if shouldAppend && !firstChar {
-				out.WriteRune(' ')
-				shouldAppend = false
-			}
-		case (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9'):
-			out.WriteRune(c)
-			shouldAppend = true
-			firstChar = false
-		}
-	}
-
-	result := out.String()
-	if len(result) > 0 && result[len(result)-1] == '_' {
-		result = result[:len(result)-1]
-	}
-
-	return result
-}
-
 func setDarkTheme(darkMode bool) {
 	gSettings, err := gtk.SettingsGetDefault()
 	if err != nil {
@@ -276,34 +236,33 @@ func escapeMarkup(text string) string {
 	return text
 }
 
-func detectErrorType(errorMsg string) string {
-	errorLower := strings.ToLower(errorMsg)
-
-	if strings.Contains(errorLower, "tmd") || strings.Contains(errorLower, "title.tmd") {
-		return "TMD Download"
-	}
-	if strings.Contains(errorLower, "tik") || strings.Contains(errorLower, "cetk") || strings.Contains(errorLower, "title.tik") {
-		return "Ticket Download"
-	}
-	if strings.Contains(errorLower, "cert") || strings.Contains(errorLower, "certificate") {
-		return "Certificate Download"
-	}
-	if strings.Contains(errorLower, "decrypt") {
-		return "Decryption"
-	}
-	if strings.Contains(errorLower, ".app") || strings.Contains(errorLower, ".h3") {
-		return "Content Download"
-	}
-	if strings.Contains(errorLower, "content not found") {
-		return "Decryption"
-	}
-	if strings.Contains(errorLower, "status code") || strings.Contains(errorLower, "connection") ||
-		strings.Contains(errorLower, "timeout") || strings.Contains(errorLower, "network") {
+func detectErrorType(err error) string {
+	var (
+		hashErr   *wiiudownloader.HashMismatchError
+		sizeErr   *wiiudownloader.SizeMismatchError
+		mirrorErr *wiiudownloader.MirrorError
+		netErr    net.Error
+	)
+	switch {
+	case errors.Is(err, wiiudownloader.ErrCancelled):
+		return "Cancelled"
+	case errors.Is(err, wiiudownloader.ErrTitleNotFound):
+		return "Title Not Found"
+	case errors.Is(err, wiiudownloader.ErrTicketUnavailable):
+		return "Ticket Unavailable"
+	case errors.Is(err, wiiudownloader.ErrInvalidDecryptionKey):
+		return "Invalid Decryption Key"
+	case errors.Is(err, wiiudownloader.ErrDiskFull):
+		return "Disk Full"
+	case errors.As(err, &hashErr):
+		return "Hash Mismatch"
+	case errors.As(err, &sizeErr):
+		return "Size Mismatch"
+	case errors.As(err, &netErr), errors.As(err, &mirrorErr):
 		return "Network Error"
-	}
-	if strings.Contains(errorLower, "permission") || strings.Contains(errorLower, "no such file") {
+	case errors.Is(err, fs.ErrPermission), errors.Is(err, fs.ErrNotExist):
 		return "File I/O Error"
+	default:
+		return "Download Error"
 	}
-
-	return "Download Error"
 }
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	wiiudownloader "github.com/Xpl0itU/WiiUDownloader"
+)
+
+func runCemu(args []string) int {
+	flags := flag.NewFlagSet("cemu", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: wiiudl cemu [flags] <decrypted title folder>...")
+		flags.PrintDefaults()
+	}
+	layoutName := flags.String("layout", "mlc01", "mlc01 to install into usr/title of a Cemu mlc01 directory, merged for one folder per game with updates and DLC merged in")
+	outputDir := flags.String("o", "", "mlc01 directory, or the directory the merged game folders are created in")
+	if err := flags.Parse(args); err != nil {
+		return flagParseExitCode(err)
+	}
+	layout, err := wiiudownloader.ParseCemuLayout(*layoutName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "wiiudl: %v\n", err)
+		return EXIT_USAGE
+	}
+	if *outputDir == "" || flags.NArg() == 0 {
+		flags.Usage()
+		return EXIT_USAGE
+	}
+
+	if !installForCemu(flags.Args(), *outputDir, layout) {
+		return EXIT_FAILURE
+	}
+	return EXIT_OK
+}
+
+// installForCemu installs the decrypted title folders and prints where each
+// one went.
+func installForCemu(folders []string, dest string, layout wiiudownloader.CemuLayout) bool {
+	installs, err := wiiudownloader.InstallCemuTitles(folders, dest, layout)
+	for _, install := range installs {
+		fmt.Fprintf(os.Stderr, "OK     %s [%016x] -> %s\n", install.Source, install.TitleID, install.Target)
+	}
+	if err != nil {
+		printFailure("Cemu install", err)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	wiiudownloader "github.com/Xpl0itU/WiiUDownloader"
+)
+
+func runDecrypt(args []string) int {
+	flags := flag.NewFlagSet("decrypt", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: wiiudl decrypt [flags] <title folder>...")
+		flags.PrintDefaults()
+	}
+	deleteEncrypted := flags.Bool("delete-encrypted", false, "delete encrypted contents after decryption")
+	quiet := flags.Bool("q", false, "do not print progress")
+	workers := flags.Int("workers", 0, "number of files extracted in parallel (0 = one per CPU)")
+	include, exclude := addPathFilterFlags(flags)
+	if err := flags.Parse(args); err != nil {
+		return flagParseExitCode(err)
+	}
+	filter, ok := pathFilter(*include, *exclude)
+	if !ok {
+		return EXIT_USAGE
+	}
+	if *deleteEncrypted && !filter.IsZero() {
+		fmt.Fprintln(os.Stderr, "wiiudl: -delete-encrypted cannot be combined with -include or -exclude")
+		return EXIT_USAGE
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return EXIT_USAGE
+	}
+
+	reporter := NewTextProgressReporter(os.Stderr, *quiet)
+	stop := cancelOnInterrupt(reporter)
+	defer stop()
+
+	failed := 0
+	succeeded := 0
+	for _, path := range flags.Args() {
+		if reporter.Cancelled() {
+			break
+		}
+		reporter.SetGameTitle(path)
+		err := wiiudownloader.DecryptContentsWithOptions(context.Background(), path, wiiudownloader.DecryptContentsOptions{
+			ProgressReporter:        reporter,
+			DeleteEncryptedContents: *deleteEncrypted,
+			Workers:                 *workers,
+			Filter:                  filter,
+		})
+		reporter.Finish()
+		if errors.Is(err, wiiudownloader.ErrCancelled) || reporter.Cancelled() {
+			break
+		}
+		if err != nil {
+			failed++
+			printFailure(path, err)
+			if errors.Is(err, wiiudownloader.ErrDiskFull) {
+				break
+			}
+			continue
+		}
+		succeeded++
+		fmt.Fprintf(os.Stderr, "OK     %s\n", path)
+	}
+	return downloadExitCode(succeeded, failed, flags.NArg(), reporter.Cancelled())
+}
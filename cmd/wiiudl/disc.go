@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	wiiudownloader "github.com/Xpl0itU/WiiUDownloader"
+)
+
+func runDisc(args []string) int {
+	flags := flag.NewFlagSet("disc", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: wiiudl disc [flags] -o <output folder> <image.wud|image.wux>")
+		fmt.Fprintln(os.Stderr, "Extracts the game partitions of a Wii U disc image into one folder per title.")
+		fmt.Fprintln(os.Stderr, "The disc key defaults to the game.key file next to the image.")
+		flags.PrintDefaults()
+	}
+	key := flags.String("key", "", "disc key as 32 hexadecimal digits, or the path of a key file")
+	output := flags.String("o", "", "folder to write the title folders to")
+	encrypted := flags.Bool("encrypted", false, "write encrypted title folders, like downloads, instead of decrypting them")
+	quiet := flags.Bool("q", false, "do not print progress")
+	if err := flags.Parse(args); err != nil {
+		return flagParseExitCode(err)
+	}
+	if *output == "" || flags.NArg() != 1 {
+		flags.Usage()
+		return EXIT_USAGE
+	}
+	image := flags.Arg(0)
+	discKey, err := loadDiscKey(*key, image)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "wiiudl disc: %v\n", err)
+		return EXIT_USAGE
+	}
+
+	reporter := NewTextProgressReporter(os.Stderr, *quiet)
+	stop := cancelOnInterrupt(reporter)
+	defer stop()
+
+	reporter.SetGameTitle(image)
+	opts := wiiudownloader.DiscImageOptions{DiscKey: discKey, ProgressReporter: reporter}
+	var titles []wiiudownloader.DiscTitle
+	if *encrypted {
+		titles, err = wiiudownloader.ImportDiscImage(context.Background(), image, *output, opts)
+	} else {
+		titles, err = wiiudownloader.ExtractDiscImage(context.Background(), image, *output, opts)
+	}
+	reporter.Finish()
+	if errors.Is(err, wiiudownloader.ErrCancelled) {
+		return EXIT_INTERRUPTED
+	}
+	if err != nil {
+		printFailure(image, err)
+		return EXIT_FAILURE
+	}
+	for _, title := range titles {
+		fmt.Fprintf(os.Stderr, "OK     %s (%s) -> %s\n", image, title.Partition, title.Folder)
+	}
+	return EXIT_OK
+}
+
+// loadDiscKey parses key as a disc key, or reads it from the file key names,
+// or game.key next to the image when key is empty. Key files hold the key as
+// 16 bytes or as hexadecimal digits.
+func loadDiscKey(key, image string) ([]byte, error) {
+	if key == "" {
+		key = filepath.Join(filepath.Dir(image), "game.key")
+		if _, err := os.Stat(key); err != nil {
+			return nil, errors.New("no disc key; pass -key or put game.key next to the image")
+		}
+	} else if discKey, err := wiiudownloader.ParseDiscKey(key); err == nil {
+		return discKey, nil
+	} else if _, statErr := os.Stat(key); statErr != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 16 {
+		return data, nil
+	}
+	return wiiudownloader.ParseDiscKey(string(data))
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	wiiudownloader "github.com/Xpl0itU/WiiUDownloader"
+)
+
+func runDownload(args []string) int {
+	flags := flag.NewFlagSet("download", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: wiiudl download [flags] [title ID...]")
+		flags.PrintDefaults()
+	}
+	outputDir := flags.String("o", ".", "directory the title folders are created in")
+	listFile := flags.String("list", "", "file with one title ID per line ('#' starts a comment)")
+	searchTerm := flags.String("search", "", "download every title whose name or ID matches the search term")
+	category := flags.String("category", "game", "category used with -search: game, update, dlc, demo or all")
+	decrypt := flags.Bool("decrypt", false, "decrypt contents after downloading")
+	deleteEncrypted := flags.Bool("delete-encrypted", false, "delete encrypted contents after decryption (requires -decrypt)")
+	pipeline := flags.Bool("pipeline", false, "decrypt each content as soon as it is downloaded (requires -decrypt)")
+	include, exclude := addPathFilterFlags(flags)
+	onlyMatching := flags.Bool("only-matching", false, "download only the contents holding files selected by -include and -exclude")
+	cemuLayout := flags.String("cemu", "", "after decrypting, install the titles for Cemu with this layout: mlc01 or merged (requires -decrypt and -cemu-dir)")
+	cemuDir := flags.String("cemu-dir", "", "mlc01 directory, or the directory merged game folders are created in, for -cemu")
+	decryptWorkers := flags.Int("decrypt-workers", 0, "number of files extracted in parallel with -decrypt (0 = one per CPU)")
+	keepGoing := flags.Bool("keep-going", true, "continue with the remaining titles when one fails")
+	quiet := flags.Bool("q", false, "do not print progress")
+	concurrency := flags.Int("concurrency", 4, "number of contents downloaded in parallel")
+	connections := flags.Int("connections", 4, "number of connections used for each content of 64 MiB or more")
+	version := flags.Int("version", -1, "title version to download instead of the latest; requires a single title ID")
+	limit := flags.String("limit", "", "bandwidth limit for all downloads, e.g. 500K or 2M bytes/s")
+	schedule := flags.String("schedule", "", "daily bandwidth limits overriding -limit, e.g. '08:00-18:00=1M,18:00-08:00=unlimited'")
+	mirrors := addMirrorFlag(flags)
+	if err := flags.Parse(args); err != nil {
+		return flagParseExitCode(err)
+	}
+	if !applyMirrors(*mirrors) || !applyBandwidthFlags(*limit, *schedule) {
+		return EXIT_USAGE
+	}
+	if *deleteEncrypted && !*decrypt {
+		fmt.Fprintln(os.Stderr, "wiiudl: -delete-encrypted requires -decrypt")
+		return EXIT_USAGE
+	}
+	if *pipeline && !*decrypt {
+		fmt.Fprintln(os.Stderr, "wiiudl: -pipeline requires -decrypt")
+		return EXIT_USAGE
+	}
+	filter, ok := pathFilter(*include, *exclude)
+	if !ok {
+		return EXIT_USAGE
+	}
+	layout := wiiudownloader.CEMU_LAYOUT_MLC01
+	if *cemuLayout != "" {
+		parsed, err := wiiudownloader.ParseCemuLayout(*cemuLayout)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "wiiudl: %v\n", err)
+			return EXIT_USAGE
+		}
+		layout = parsed
+	}
+	switch {
+	case *cemuLayout != "" && (!*decrypt || *cemuDir == ""):
+		fmt.Fprintln(os.Stderr, "wiiudl: -cemu requires -decrypt and -cemu-dir")
+		return EXIT_USAGE
+	case *cemuDir != "" && *cemuLayout == "":
+		fmt.Fprintln(os.Stderr, "wiiudl: -cemu-dir requires -cemu")
+		return EXIT_USAGE
+	case *onlyMatching && filter.IsZero():
+		fmt.Fprintln(os.Stderr, "wiiudl: -only-matching requires -include or -exclude")
+		return EXIT_USAGE
+	case !filter.IsZero() && !*decrypt && !*onlyMatching:
+		fmt.Fprintln(os.Stderr, "wiiudl: -include and -exclude require -decrypt or -only-matching")
+		return EXIT_USAGE
+	case !filter.IsZero() && *deleteEncrypted:
+		fmt.Fprintln(os.Stderr, "wiiudl: -delete-encrypted cannot be combined with -include or -exclude")
+		return EXIT_USAGE
+	}
+
+	titles, err := resolveDownloadTargets(flags.Args(), *listFile, *searchTerm, *category)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "wiiudl: %v\n", err)
+		return EXIT_USAGE
+	}
+	if len(titles) == 0 {
+		fmt.Fprintln(os.Stderr, "wiiudl: nothing to download")
+		return EXIT_USAGE
+	}
+	titleVersion, err := parseTitleVersionFlag(*version, len(titles))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "wiiudl: %v\n", err)
+		return EXIT_USAGE
+	}
+
+	reporter := NewTextProgressReporter(os.Stderr, *quiet)
+	stop := cancelOnInterrupt(reporter)
+	defer stop()
+
+	client := buildHTTPClient()
+	failed := 0
+	succeeded := 0
+	var downloaded []string
+	for _, title := range titles {
+		if reporter.Cancelled() {
+			break
+		}
+		tidStr := fmt.Sprintf("%016x", title.TitleID)
+		titlePath := filepath.Join(*outputDir, titleDirectoryName(title, titleVersion))
+		result, err := wiiudownloader.DownloadTitleWithOptions(context.Background(), title.TitleID, wiiudownloader.DownloadTitleOptions{
+			OutputDirectory:         titlePath,
+			Decrypt:                 *decrypt,
+			DeleteEncryptedContents: *deleteEncrypted,
+			Client:                  client,
+			ProgressReporter:        reporter,
+			Concurrency:             *concurrency,
+			ConnectionsPerFile:      *connections,
+			TitleVersion:            titleVersion,
+			DecryptionWorkers:       *decryptWorkers,
+			DecryptWhileDownloading: *pipeline,
+			Filter:                  filter,
+			MatchingContentsOnly:    *onlyMatching,
+		})
+		reporter.Finish()
+		if errors.Is(err, wiiudownloader.ErrCancelled) || reporter.Cancelled() {
+			break
+		}
+		if err != nil {
+			failed++
+			printFailure(fmt.Sprintf("%s [%s]", title.Name, tidStr), err)
+			// Every following title would run out of space as well.
+			if !*keepGoing || errors.Is(err, wiiudownloader.ErrDiskFull) {
+				break
+			}
+			continue
+		}
+		succeeded++
+		downloaded = append(downloaded, titlePath)
+		fmt.Fprintf(os.Stderr, "OK     %s [%s] v%d -> %s (%s transferred, %s resumed%s)\n", title.Name, tidStr, result.TitleVersion, titlePath,
+			formatBytes(uint64(result.BytesTransferred)), formatBytes(uint64(result.BytesResumed)), ticketNote(result))
+	}
+
+	if *cemuLayout != "" && len(downloaded) > 0 && !reporter.Cancelled() && !installForCemu(downloaded, *cemuDir, layout) {
+		return EXIT_FAILURE
+	}
+	return downloadExitCode(succeeded, failed, len(titles), reporter.Cancelled())
+}
+
+func ticketNote(result *wiiudownloader.DownloadTitleResult) string {
+	if result.TicketGenerated {
+		return ", ticket generated"
+	}
+	return ""
+}
+
+func downloadExitCode(succeeded, failed, total int, cancelled bool) int {
+	switch {
+	case cancelled:
+		return EXIT_INTERRUPTED
+	case failed == 0 && succeeded == total:
+		return EXIT_OK
+	case succeeded == 0:
+		return EXIT_FAILURE
+	default:
+		return EXIT_PARTIAL
+	}
+}
+
+func resolveDownloadTargets(ids []string, listFile, searchTerm, category string) ([]wiiudownloader.TitleEntry, error) {
+	var titles []wiiudownloader.TitleEntry
+	seen := make(map[uint64]struct{})
+	add := func(entry wiiudownloader.TitleEntry) {
+		if _, ok := seen[entry.TitleID]; ok {
+			return
+		}
+		seen[entry.TitleID] = struct{}{}
+		titles = append(titles, entry)
+	}
+
+	if listFile != "" {
+		listIDs, err := readTitleIDList(listFile)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, listIDs...)
+	}
+	for _, id := range ids {
+		entry, err := titleEntryFromID(id)
+		if err != nil {
+			return nil, err
+		}
+		add(entry)
+	}
+
+	if searchTerm != "" {
+		cat, err := parseCategory(category)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range searchTitles(searchTerm, cat) {
+			add(entry)
+		}
+	}
+	return titles, nil
+}
+
+func readTitleIDList(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var ids []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if idx := strings.IndexByte(line, '#'); idx >= 0 {
+			line = line[:idx]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		ids = append(ids, strings.Fields(line)[0])
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+func parseTitleID(id string) (uint64, error) {
+	id = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(id)), "0x")
+	if len(id) != 16 {
+		return 0, fmt.Errorf("invalid title ID %q: expected 16 hex characters", id)
+	}
+	tid, err := strconv.ParseUint(id, 16, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid title ID %q: %w", id, err)
+	}
+	return tid, nil
+}
+
+func titleEntryFromID(id string) (wiiudownloader.TitleEntry, error) {
+	tid, err := parseTitleID(id)
+	if err != nil {
+		return wiiudownloader.TitleEntry{}, err
+	}
+	entry := wiiudownloader.GetTitleEntryFromTid(tid)
+	if entry.TitleID == 0 {
+		entry = wiiudownloader.TitleEntry{
+			Name:    fmt.Sprintf("%016x", tid),
+			TitleID: tid,
+			Key:     uint8(wiiudownloader.TITLE_KEY_mypass),
+		}
+	}
+	return entry, nil
+}
+
+func parseTitleVersionFlag(version, titleCount int) (*uint16, error) {
+	switch {
+	case version < 0:
+		return nil, nil
+	case version > math.MaxUint16:
+		return nil, fmt.Errorf("invalid title version %d", version)
+	case titleCount != 1:
+		return nil, errors.New("-version requires exactly one title")
+	}
+	titleVersion := uint16(version)
+	return &titleVersion, nil
+}
+
+// titleDirectoryName names the folder a title is downloaded into. A pinned
+// version is appended so it does not overwrite the latest version.
+func titleDirectoryName(title wiiudownloader.TitleEntry, version *uint16) string {
+	name := fmt.Sprintf("%s [%s] [%016x]", wiiudownloader.NormalizeFilename(title.Name), wiiudownloader.GetFormattedKind(title.TitleID), title.TitleID)
+	if version != nil {
+		name += fmt.Sprintf(" [v%d]", *version)
+	}
+	return name
+}
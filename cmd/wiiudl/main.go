@@ -0,0 +1,237 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	wiiudownloader "github.com/Xpl0itU/WiiUDownloader"
+)
+
+const (
+	EXIT_OK          = 0
+	EXIT_FAILURE     = 1
+	EXIT_USAGE       = 2
+	EXIT_PARTIAL     = 3
+	EXIT_INTERRUPTED = 130
+)
+
+const (
+	NETWORK_DIAL_TIMEOUT         = 30 * time.Second
+	NETWORK_DIAL_KEEP_ALIVE      = 30 * time.Second
+	HTTP_MAX_IDLE_CONNS          = 100
+	HTTP_MAX_IDLE_CONNS_PER_HOST = 100
+	HTTP_IDLE_CONN_TIMEOUT       = 90 * time.Second
+	HTTP_RESPONSE_HEADER_TIMEOUT = 10 * time.Second
+)
+
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) int
+}
+
+var commands = []command{
+	{name: "download", summary: "download titles by title ID, list file or search term", run: runDownload},
+	{name: "decrypt", summary: "decrypt already downloaded title folders", run: runDecrypt},
+	{name: "cemu", summary: "install decrypted title folders into a Cemu mlc01 directory or merged game folders", run: runCemu},
+	{name: "wua", summary: "pack title folders into a Cemu .wua archive", run: runWUA},
+	{name: "wad", summary: "build a WAD from a Wii or vWii title folder, or unpack one into a title folder", run: runWAD},
+	{name: "disc", summary: "extract the game partitions of a .wud or .wux disc image", run: runDisc},
+	{name: "verify", summary: "check downloaded title folders for damaged or missing contents without decrypting them to disk", run: runVerify},
+	{name: "repair", summary: "download damaged or missing contents of title folders again", run: runRepair},
+	{name: "repack", summary: "encrypt a decrypted title folder into an installable title folder", run: runRepack},
+	{name: "serve", summary: "serve the decrypted files of title folders read-only over HTTP and WebDAV", run: runServe},
+	{name: "search", summary: "search the title database", run: runSearch},
+	{name: "info", summary: "show information about a title ID or title folder", run: runInfo},
+}
+
+func main() {
+	os.Exit(run(os.Args[1:]))
+}
+
+func run(args []string) int {
+	if len(args) == 0 {
+		printUsage()
+		return EXIT_USAGE
+	}
+	switch args[0] {
+	case "-h", "-help", "--help", "help":
+		printUsage()
+		return EXIT_OK
+	}
+	for _, cmd := range commands {
+		if cmd.name == args[0] {
+			return cmd.run(args[1:])
+		}
+	}
+	fmt.Fprintf(os.Stderr, "wiiudl: unknown command %q\n\n", args[0])
+	printUsage()
+	return EXIT_USAGE
+}
+
+func printUsage() {
+	fmt.Fprintln(os.Stderr, "Usage: wiiudl <command> [flags] [arguments]")
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Commands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Run 'wiiudl <command> -h' for the flags of a command.")
+}
+
+func flagParseExitCode(err error) int {
+	if errors.Is(err, flag.ErrHelp) {
+		return EXIT_OK
+	}
+	return EXIT_USAGE
+}
+
+// stringList is a flag.Value collecting every occurrence of a repeatable flag.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+func addMirrorFlag(flags *flag.FlagSet) *stringList {
+	mirrors := &stringList{}
+	flags.Var(mirrors, "mirror", "CDN base URL to download from; repeat to add fallback mirrors, tried in order")
+	return mirrors
+}
+
+// addPathFilterFlags adds the repeatable -include and -exclude flags.
+func addPathFilterFlags(flags *flag.FlagSet) (include, exclude *stringList) {
+	include, exclude = &stringList{}, &stringList{}
+	flags.Var(include, "include", "only extract files matching this glob, e.g. 'content/Common' or '*.rpx'; repeat to add patterns")
+	flags.Var(exclude, "exclude", "do not extract files matching this glob; repeat to add patterns")
+	return include, exclude
+}
+
+func pathFilter(include, exclude stringList) (wiiudownloader.PathFilter, bool) {
+	filter := wiiudownloader.PathFilter{Include: include, Exclude: exclude}
+	if err := filter.Validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "wiiudl: %v\n", err)
+		return filter, false
+	}
+	return filter, true
+}
+
+func applyMirrors(mirrors stringList) bool {
+	if len(mirrors) == 0 {
+		return true
+	}
+	if err := wiiudownloader.SetCDNMirrors(mirrors); err != nil {
+		fmt.Fprintf(os.Stderr, "wiiudl: %v\n", err)
+		return false
+	}
+	return true
+}
+
+func applyBandwidthFlags(limitText, scheduleText string) bool {
+	limit, err := wiiudownloader.ParseBandwidthLimit(limitText)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "wiiudl: %v\n", err)
+		return false
+	}
+	schedule, err := wiiudownloader.ParseBandwidthSchedule(scheduleText)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "wiiudl: %v\n", err)
+		return false
+	}
+	wiiudownloader.SetBandwidthLimit(limit)
+	wiiudownloader.SetBandwidthSchedule(schedule)
+	return true
+}
+
+// failureHint suggests what to do about a failed title.
+func failureHint(err error) string {
+	var hashErr *wiiudownloader.HashMismatchError
+	var sizeErr *wiiudownloader.SizeMismatchError
+	switch {
+	case errors.Is(err, wiiudownloader.ErrTitleNotFound):
+		return "the CDN does not have this title; check the title ID"
+	case errors.Is(err, wiiudownloader.ErrTicketUnavailable):
+		return "no ticket was found and none could be generated for this title"
+	case errors.Is(err, wiiudownloader.ErrInvalidDecryptionKey):
+		return "the ticket does not match the title; delete title.tik and download again"
+	case errors.Is(err, wiiudownloader.ErrInvalidDiscKey):
+		return "the disc key does not match the image; use the game.key dumped with it"
+	case errors.Is(err, wiiudownloader.ErrDiskFull):
+		return "free some disk space and run the command again to resume"
+	case errors.Is(err, wiiudownloader.ErrNoFilesMatched):
+		return "patterns match paths below the title folder, e.g. 'code/app.xml', 'content/Common' or '*.rpx'"
+	case errors.Is(err, wiiudownloader.ErrNoFST):
+		return "only Wii U titles with a file system table can be served; use 'wiiudl decrypt' instead"
+	case errors.As(err, &hashErr):
+		return fmt.Sprintf("%s is damaged; delete it and download again", hashErr.File)
+	case errors.As(err, &sizeErr):
+		return fmt.Sprintf("%s is incomplete; run the download again to resume", sizeErr.File)
+	case errors.Is(err, fs.ErrNotExist):
+		return "a file is missing; run the download again to fetch it"
+	default:
+		return ""
+	}
+}
+
+func printFailure(subject string, err error) {
+	fmt.Fprintf(os.Stderr, "FAILED %s: %v\n", subject, err)
+	if hint := failureHint(err); hint != "" {
+		fmt.Fprintf(os.Stderr, "       %s\n", hint)
+	}
+}
+
+func buildHTTPClient() *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			DialContext: (&net.Dialer{
+				Timeout:   NETWORK_DIAL_TIMEOUT,
+				KeepAlive: NETWORK_DIAL_KEEP_ALIVE,
+			}).DialContext,
+			MaxIdleConns:          HTTP_MAX_IDLE_CONNS,
+			MaxIdleConnsPerHost:   HTTP_MAX_IDLE_CONNS_PER_HOST,
+			IdleConnTimeout:       HTTP_IDLE_CONN_TIMEOUT,
+			ResponseHeaderTimeout: HTTP_RESPONSE_HEADER_TIMEOUT,
+		},
+	}
+}
+
+// cancelOnInterrupt marks the reporter as cancelled on the first SIGINT so the
+// library can stop cleanly; a second SIGINT terminates immediately.
+func cancelOnInterrupt(reporter *TextProgressReporter) func() {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt)
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-signals:
+		case <-done:
+			return
+		}
+		fmt.Fprintln(os.Stderr, "\nCancelling, press Ctrl+C again to abort immediately...")
+		reporter.SetCancelled()
+		select {
+		case <-signals:
+			os.Exit(EXIT_INTERRUPTED)
+		case <-done:
+		}
+	}()
+	return func() {
+		signal.Stop(signals)
+		close(done)
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sync"
+	"time"
+)
+
+const PROGRESS_PRINT_INTERVAL = 500 * time.Millisecond
+
+// TextProgressReporter implements wiiudownloader.ProgressReporter by printing a
+// single, periodically refreshed status line.
+type TextProgressReporter struct {
+	out             io.Writer
+	quiet           bool
+	mu              sync.Mutex
+	title           string
+	cancelled       bool
+	totalToDownload int64
+	totalDownloaded int64
+	progressPerFile map[string]int64
+	fileMirrors     map[string]string
+	startTime       time.Time
+	lastPrint       time.Time
+	lineOpen        bool
+}
+
+func NewTextProgressReporter(out io.Writer, quiet bool) *TextProgressReporter {
+	return &TextProgressReporter{
+		out:             out,
+		quiet:           quiet,
+		progressPerFile: make(map[string]int64),
+		fileMirrors:     make(map[string]string),
+		startTime:       time.Now(),
+	}
+}
+
+func (r *TextProgressReporter) SetGameTitle(title string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.endLineLocked()
+	r.title = title
+	if !r.quiet {
+		fmt.Fprintf(r.out, "==> %s\n", title)
+	}
+}
+
+func (r *TextProgressReporter) UpdateDownloadProgress(downloaded int64, filename string) {
+	if downloaded == 0 {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.progressPerFile[filename]; !ok {
+		return
+	}
+	r.progressPerFile[filename] += downloaded
+	if time.Since(r.lastPrint) < PROGRESS_PRINT_INTERVAL {
+		return
+	}
+	r.lastPrint = time.Now()
+
+	total := r.totalDownloaded
+	for _, v := range r.progressPerFile {
+		total += v
+	}
+	percent := 0.0
+	if r.totalToDownload > 0 {
+		percent = float64(total) / float64(r.totalToDownload) * 100
+	}
+	speed := int64(0)
+	if elapsed := time.Since(r.startTime).Seconds(); elapsed > 0 {
+		speed = int64(float64(total) / elapsed)
+	}
+	r.printLineLocked(fmt.Sprintf("Downloading... %6.2f%% (%s/%s) (%s/s)", percent, formatBytes(uint64(total)), formatBytes(uint64(r.totalToDownload)), formatBytes(uint64(speed))))
+}
+
+func (r *TextProgressReporter) UpdateDecryptionProgress(progress float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if progress < 1 && time.Since(r.lastPrint) < PROGRESS_PRINT_INTERVAL {
+		return
+	}
+	r.lastPrint = time.Now()
+	r.printLineLocked(fmt.Sprintf("Decrypting... %6.2f%%", progress*100))
+}
+
+func (r *TextProgressReporter) Cancelled() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.cancelled
+}
+
+func (r *TextProgressReporter) SetCancelled() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cancelled = true
+}
+
+func (r *TextProgressReporter) SetDownloadSize(size int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.totalToDownload = size
+}
+
+func (r *TextProgressReporter) ResetTotals() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.endLineLocked()
+	r.progressPerFile = make(map[string]int64)
+	r.fileMirrors = make(map[string]string)
+	r.totalDownloaded = 0
+	r.totalToDownload = 0
+	r.startTime = time.Now()
+}
+
+func (r *TextProgressReporter) MarkFileAsDone(filename string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.totalDownloaded += r.progressPerFile[filename]
+	delete(r.progressPerFile, filename)
+}
+
+func (r *TextProgressReporter) SetTotalDownloadedForFile(filename string, downloaded int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.progressPerFile[filename] = downloaded
+}
+
+// SetFileMirror prints a line whenever a file moves on to another mirror, so
+// the mirror that served bad data can be identified.
+func (r *TextProgressReporter) SetFileMirror(filename, mirror string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if previous, ok := r.fileMirrors[filename]; ok && previous != mirror && !r.quiet {
+		r.endLineLocked()
+		fmt.Fprintf(r.out, "%s: %s failed, trying %s\n", filename, previous, mirror)
+	}
+	r.fileMirrors[filename] = mirror
+}
+
+func (r *TextProgressReporter) SetStartTime(startTime time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.startTime = startTime
+}
+
+// Finish terminates the status line so that following output starts on a
+// fresh line.
+func (r *TextProgressReporter) Finish() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.endLineLocked()
+}
+
+func (r *TextProgressReporter) printLineLocked(line string) {
+	if r.quiet {
+		return
+	}
+	fmt.Fprintf(r.out, "\r%-72s", line)
+	r.lineOpen = true
+}
+
+func (r *TextProgressReporter) endLineLocked() {
+	if r.lineOpen {
+		fmt.Fprintln(r.out)
+		r.lineOpen = false
+	}
+}
+
+func formatBytes(bytes uint64) string {
+	const unit = 1000
+
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+
+	value := float64(bytes)
+	units := []string{"B", "KB", "MB", "GB", "TB", "PB", "EB"}
+	unitIndex := 0
+	for value >= unit && unitIndex < len(units)-1 {
+		value /= unit
+		unitIndex++
+	}
+	value = math.Round(value*100) / 100
+	return fmt.Sprintf("%.2f %s", value, units[unitIndex])
+}
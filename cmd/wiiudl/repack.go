@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	wiiudownloader "github.com/Xpl0itU/WiiUDownloader"
+)
+
+func runRepack(args []string) int {
+	flags := flag.NewFlagSet("repack", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: wiiudl repack [flags] -original <title folder> -o <output folder> <decrypted title folder>")
+		fmt.Fprintln(os.Stderr, "Encrypts the code, content and meta folders of a decrypted Wii U title into an")
+		fmt.Fprintln(os.Stderr, "installable title folder, reusing the TMD and ticket of the original title.")
+		flags.PrintDefaults()
+	}
+	original := flags.String("original", "", "title folder holding the title.tmd and title.tik of the original title")
+	output := flags.String("o", "", "folder to write the encrypted title to")
+	quiet := flags.Bool("q", false, "do not print progress")
+	if err := flags.Parse(args); err != nil {
+		return flagParseExitCode(err)
+	}
+	if *original == "" || *output == "" || flags.NArg() != 1 {
+		flags.Usage()
+		return EXIT_USAGE
+	}
+
+	reporter := NewTextProgressReporter(os.Stderr, *quiet)
+	stop := cancelOnInterrupt(reporter)
+	defer stop()
+
+	src := flags.Arg(0)
+	reporter.SetGameTitle(src)
+	err := wiiudownloader.RepackTitle(context.Background(), src, *output, wiiudownloader.RepackTitleOptions{
+		Original:         *original,
+		ProgressReporter: reporter,
+	})
+	reporter.Finish()
+	if errors.Is(err, wiiudownloader.ErrCancelled) {
+		return EXIT_INTERRUPTED
+	}
+	if err != nil {
+		printFailure(src, err)
+		return EXIT_FAILURE
+	}
+	fmt.Fprintf(os.Stderr, "OK     %s -> %s\n", src, *output)
+	return EXIT_OK
+}
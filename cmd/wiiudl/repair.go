@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	wiiudownloader "github.com/Xpl0itU/WiiUDownloader"
+)
+
+func runRepair(args []string) int {
+	flags := flag.NewFlagSet("repair", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: wiiudl repair [flags] <title folder>...")
+		flags.PrintDefaults()
+	}
+	quiet := flags.Bool("q", false, "do not print progress")
+	concurrency := flags.Int("concurrency", 4, "number of contents downloaded in parallel")
+	connections := flags.Int("connections", 4, "number of connections used for each content of 64 MiB or more")
+	limit := flags.String("limit", "", "bandwidth limit for all downloads, e.g. 500K or 2M bytes/s")
+	schedule := flags.String("schedule", "", "daily bandwidth limits overriding -limit, e.g. '08:00-18:00=1M,18:00-08:00=unlimited'")
+	mirrors := addMirrorFlag(flags)
+	if err := flags.Parse(args); err != nil {
+		return flagParseExitCode(err)
+	}
+	if !applyMirrors(*mirrors) || !applyBandwidthFlags(*limit, *schedule) {
+		return EXIT_USAGE
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return EXIT_USAGE
+	}
+
+	reporter := NewTextProgressReporter(os.Stderr, *quiet)
+	stop := cancelOnInterrupt(reporter)
+	defer stop()
+
+	client := buildHTTPClient()
+	failed := 0
+	succeeded := 0
+	for _, path := range flags.Args() {
+		if reporter.Cancelled() {
+			break
+		}
+		reporter.SetGameTitle(path)
+		result, err := wiiudownloader.RepairTitle(context.Background(), path, wiiudownloader.RepairTitleOptions{
+			Client:             client,
+			ProgressReporter:   reporter,
+			Concurrency:        *concurrency,
+			ConnectionsPerFile: *connections,
+		})
+		reporter.Finish()
+		if errors.Is(err, wiiudownloader.ErrCancelled) || reporter.Cancelled() {
+			break
+		}
+		if err != nil {
+			failed++
+			printFailure(path, err)
+			if errors.Is(err, wiiudownloader.ErrDiskFull) {
+				break
+			}
+			continue
+		}
+		for _, content := range result.Repaired {
+			fmt.Fprintf(os.Stderr, "FIXED  %s.app\n", content.Content.CIDStr)
+		}
+		if len(result.Unrepaired) > 0 {
+			failed++
+			for _, content := range result.Unrepaired {
+				printFailure(path, content.Err)
+			}
+			continue
+		}
+		succeeded++
+		fmt.Fprintf(os.Stderr, "OK     %s (%d of %d contents repaired, %s transferred)\n", path, len(result.Repaired), len(result.Scan.Contents),
+			formatBytes(uint64(result.BytesTransferred)))
+	}
+	return downloadExitCode(succeeded, failed, flags.NArg(), reporter.Cancelled())
+}
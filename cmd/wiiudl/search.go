@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	wiiudownloader "github.com/Xpl0itU/WiiUDownloader"
+)
+
+func runSearch(args []string) int {
+	flags := flag.NewFlagSet("search", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: wiiudl search [flags] <term>")
+		flags.PrintDefaults()
+	}
+	category := flags.String("category", "all", "category to search: game, update, dlc, demo or all")
+	if err := flags.Parse(args); err != nil {
+		return flagParseExitCode(err)
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return EXIT_USAGE
+	}
+	cat, err := parseCategory(*category)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "wiiudl: %v\n", err)
+		return EXIT_USAGE
+	}
+
+	results := searchTitles(strings.Join(flags.Args(), " "), cat)
+	for _, entry := range results {
+		fmt.Printf("%016x  %-15s  %-17s  %s\n", entry.TitleID, wiiudownloader.GetFormattedKind(entry.TitleID), wiiudownloader.GetFormattedRegion(entry.Region), entry.Name)
+	}
+	if len(results) == 0 {
+		return EXIT_FAILURE
+	}
+	return EXIT_OK
+}
+
+func runInfo(args []string) int {
+	flags := flag.NewFlagSet("info", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: wiiudl info [flags] <title ID | title folder>...")
+		flags.PrintDefaults()
+	}
+	listVersions := flags.Bool("versions", false, "also list the title versions available on the CDN")
+	mirrors := addMirrorFlag(flags)
+	if err := flags.Parse(args); err != nil {
+		return flagParseExitCode(err)
+	}
+	if !applyMirrors(*mirrors) {
+		return EXIT_USAGE
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return EXIT_USAGE
+	}
+
+	client := buildHTTPClient()
+	failed := 0
+	for i, arg := range flags.Args() {
+		if i > 0 {
+			fmt.Println()
+		}
+		if err := printInfo(client, arg, *listVersions); err != nil {
+			fmt.Fprintf(os.Stderr, "wiiudl: %s: %v\n", arg, err)
+			failed++
+		}
+	}
+	return downloadExitCode(flags.NArg()-failed, failed, flags.NArg(), false)
+}
+
+func printInfo(client *http.Client, arg string, listVersions bool) error {
+	var (
+		tmd    *wiiudownloader.TMD
+		ticket *wiiudownloader.Ticket
+		source string
+	)
+	if info, err := os.Stat(arg); err == nil && info.IsDir() {
+		data, err := os.ReadFile(filepath.Join(arg, "title.tmd"))
+		if err != nil {
+			return err
+		}
+		if tmd, err = wiiudownloader.ParseTMD(data); err != nil {
+			return err
+		}
+		if data, err := os.ReadFile(filepath.Join(arg, "title.tik")); err == nil {
+			if ticket, err = wiiudownloader.ParseTicket(data); err != nil {
+				return fmt.Errorf("title.tik: %w", err)
+			}
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+		source = arg
+	} else {
+		tid, err := parseTitleID(arg)
+		if err != nil {
+			return err
+		}
+		if tmd, err = wiiudownloader.FetchTMD(context.Background(), client, wiiudownloader.DEFAULT_USER_AGENT, tid); err != nil {
+			return err
+		}
+		source = "CDN"
+	}
+
+	entry := wiiudownloader.GetTitleEntryFromTid(tmd.TitleID)
+	name := entry.Name
+	if name == "" {
+		name = "(not in title database)"
+	}
+	fmt.Printf("Title ID:      %016x\n", tmd.TitleID)
+	fmt.Printf("Name:          %s\n", name)
+	fmt.Printf("Kind:          %s\n", wiiudownloader.GetFormattedKind(tmd.TitleID))
+	if entry.TitleID != 0 {
+		fmt.Printf("Region:        %s\n", wiiudownloader.GetFormattedRegion(entry.Region))
+	}
+	fmt.Printf("Source:        %s\n", source)
+	fmt.Printf("Title version: %d\n", tmd.TitleVersion)
+	fmt.Printf("Requires:      %s\n", wiiudownloader.GetFormattedSystemVersion(tmd.SystemVersion))
+	if tmd.Version == wiiudownloader.TMD_VERSION_WII {
+		fmt.Printf("TMD region:    %s\n", wiiudownloader.GetFormattedTMDRegion(tmd.Region))
+	}
+	fmt.Printf("Contents:      %d\n", tmd.ContentCount)
+	fmt.Printf("Total size:    %s\n", formatBytes(tmd.CalculateTotalSize()))
+	if ticket != nil {
+		fmt.Printf("Ticket ID:     %016x\n", ticket.TicketID)
+		if ticket.ConsoleID != 0 {
+			fmt.Printf("Console ID:    %08x\n", ticket.ConsoleID)
+		}
+		fmt.Printf("Common key:    %d\n", ticket.CommonKeyIndex)
+		fmt.Printf("Ticket issuer: %s\n", ticket.Issuer)
+	}
+	for _, content := range tmd.Contents {
+		hashed := ""
+		if content.Type&wiiudownloader.CONTENT_TYPE_HASHED == wiiudownloader.CONTENT_TYPE_HASHED {
+			hashed = " (hashed)"
+		}
+		fmt.Printf("  %08X  %12d bytes%s\n", content.ID, content.Size, hashed)
+	}
+	if !listVersions {
+		return nil
+	}
+	versions, err := wiiudownloader.ListTitleVersions(context.Background(), client, wiiudownloader.DEFAULT_USER_AGENT, tmd.TitleID)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Versions:      %s\n", formatVersions(versions.Available))
+	if len(versions.Unknown) > 0 {
+		fmt.Printf("Unknown:       %s (the CDN did not answer)\n", formatVersions(versions.Unknown))
+	}
+	if versions.Truncated {
+		fmt.Printf("Older versions were not checked, only the newest %d.\n", wiiudownloader.MAX_TITLE_VERSION_PROBES)
+	}
+	return nil
+}
+
+func formatVersions(versions []uint16) string {
+	names := make([]string, len(versions))
+	for i, version := range versions {
+		names[i] = fmt.Sprintf("v%d", version)
+	}
+	return strings.Join(names, " ")
+}
+
+func parseCategory(category string) (uint8, error) {
+	switch strings.ToLower(category) {
+	case "game":
+		return wiiudownloader.TITLE_CATEGORY_GAME, nil
+	case "update":
+		return wiiudownloader.TITLE_CATEGORY_UPDATE, nil
+	case "dlc":
+		return wiiudownloader.TITLE_CATEGORY_DLC, nil
+	case "demo":
+		return wiiudownloader.TITLE_CATEGORY_DEMO, nil
+	case "all":
+		return wiiudownloader.TITLE_CATEGORY_ALL, nil
+	default:
+		return 0, fmt.Errorf("unknown category %q", category)
+	}
+}
+
+func searchTitles(term string, category uint8) []wiiudownloader.TitleEntry {
+	term = strings.ToLower(strings.TrimSpace(term))
+	var results []wiiudownloader.TitleEntry
+	for _, entry := range wiiudownloader.GetTitleEntries(category) {
+		if strings.Contains(fmt.Sprintf("%016x", entry.TitleID), term) || strings.Contains(strings.ToLower(entry.Name), term) {
+			results = append(results, entry)
+		}
+	}
+	return results
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	wiiudownloader "github.com/Xpl0itU/WiiUDownloader"
+	"golang.org/x/net/webdav"
+)
+
+const (
+	SERVE_SHUTDOWN_TIMEOUT = 5 * time.Second
+	SERVE_ALLOWED_METHODS  = "OPTIONS, GET, HEAD, PROPFIND"
+)
+
+func runServe(args []string) int {
+	flags := flag.NewFlagSet("serve", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: wiiudl serve [flags] <title folder>...")
+		fmt.Fprintln(os.Stderr, "Serves the decrypted files of Wii U title folders read-only over HTTP and WebDAV,")
+		fmt.Fprintln(os.Stderr, "each in a directory named after its folder. There is no authentication.")
+		flags.PrintDefaults()
+	}
+	addr := flags.String("addr", "127.0.0.1:8080", "address to listen on; use ':8080' to accept connections from other machines")
+	if err := flags.Parse(args); err != nil {
+		return flagParseExitCode(err)
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return EXIT_USAGE
+	}
+
+	titles := &titleDirectoryFS{modTime: time.Now()}
+	for _, path := range flags.Args() {
+		title, err := wiiudownloader.OpenTitleFS(path)
+		if err != nil {
+			printFailure(path, err)
+			continue
+		}
+		titles.add(filepath.Base(filepath.Clean(path)), title)
+	}
+	if len(titles.names) == 0 {
+		return EXIT_FAILURE
+	}
+
+	listener, err := net.Listen("tcp", *addr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "wiiudl: %v\n", err)
+		return EXIT_FAILURE
+	}
+	server := &http.Server{Handler: newServeHandler(titles)}
+	fmt.Fprintf(os.Stderr, "Serving %d title(s) on http://%s/, press Ctrl+C to stop\n", len(titles.names), listener.Addr())
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), SERVE_SHUTDOWN_TIMEOUT)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+	if err := server.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+		fmt.Fprintf(os.Stderr, "wiiudl: %v\n", err)
+		return EXIT_FAILURE
+	}
+	return EXIT_OK
+}
+
+// newServeHandler serves fsys to browsers with directory listings and range
+// requests, and to WebDAV clients with PROPFIND. Requests that would change
+// anything are refused.
+func newServeHandler(fsys fs.FS) http.Handler {
+	files := http.FileServerFS(fsys)
+	dav := &webdav.Handler{
+		FileSystem: readOnlyDAVFileSystem{http.FS(fsys)},
+		LockSystem: webdav.NewMemLS(),
+		Logger: func(r *http.Request, err error) {
+			if err != nil && !errors.Is(err, fs.ErrNotExist) {
+				log.Printf("%s %s: %v", r.Method, r.URL.Path, err)
+			}
+		},
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+			files.ServeHTTP(w, r)
+		case "PROPFIND":
+			dav.ServeHTTP(w, r)
+		case http.MethodOptions:
+			// Only advertise class 1, so that clients mount the share read-only
+			// instead of trying to lock files.
+			w.Header().Set("Allow", SERVE_ALLOWED_METHODS)
+			w.Header().Set("DAV", "1")
+		default:
+			w.Header().Set("Allow", SERVE_ALLOWED_METHODS)
+			http.Error(w, "read-only", http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+// readOnlyDAVFileSystem adapts an http.FileSystem to webdav.FileSystem. WebDAV
+// names can end in a slash, which http.FS does not accept, so they are cleaned
+// first.
+type readOnlyDAVFileSystem struct {
+	fs http.FileSystem
+}
+
+func (d readOnlyDAVFileSystem) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	return os.ErrPermission
+}
+
+func (d readOnlyDAVFileSystem) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0 {
+		return nil, os.ErrPermission
+	}
+	file, err := d.fs.Open(path.Clean(name))
+	if err != nil {
+		return nil, err
+	}
+	return readOnlyDAVFile{file}, nil
+}
+
+func (d readOnlyDAVFileSystem) RemoveAll(ctx context.Context, name string) error {
+	return os.ErrPermission
+}
+
+func (d readOnlyDAVFileSystem) Rename(ctx context.Context, oldName, newName string) error {
+	return os.ErrPermission
+}
+
+func (d readOnlyDAVFileSystem) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	file, err := d.fs.Open(path.Clean(name))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return file.Stat()
+}
+
+type readOnlyDAVFile struct {
+	http.File
+}
+
+func (f readOnlyDAVFile) Write([]byte) (int, error) {
+	return 0, os.ErrPermission
+}
+
+// titleDirectoryFS lists every served title as a directory of the root.
+type titleDirectoryFS struct {
+	modTime time.Time
+	names   []string
+	titles  map[string]*wiiudownloader.TitleFS
+}
+
+// add serves title as name, or as "name (2)" and so on when the name is taken.
+func (t *titleDirectoryFS) add(name string, title *wiiudownloader.TitleFS) {
+	if t.titles == nil {
+		t.titles = make(map[string]*wiiudownloader.TitleFS)
+	}
+	unique := name
+	for i := 2; t.titles[unique] != nil; i++ {
+		unique = fmt.Sprintf("%s (%d)", name, i)
+	}
+	t.titles[unique] = title
+	t.names = append(t.names, unique)
+	slices.Sort(t.names)
+}
+
+func (t *titleDirectoryFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	if name == "." {
+		return &titleRootDir{fsys: t}, nil
+	}
+	titleName, rest, _ := strings.Cut(name, "/")
+	title, ok := t.titles[titleName]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	if rest == "" {
+		rest = "."
+	}
+	file, err := title.Open(rest)
+	if pathErr := (*fs.PathError)(nil); errors.As(err, &pathErr) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: pathErr.Err}
+	} else if err != nil {
+		return nil, err
+	}
+	if rest == "." {
+		return renamedDir{file.(fs.ReadDirFile), titleName}, nil
+	}
+	return file, nil
+}
+
+type titleRootDir struct {
+	fsys   *titleDirectoryFS
+	offset int
+}
+
+func (d *titleRootDir) Stat() (fs.FileInfo, error) {
+	return dirInfo{name: ".", modTime: d.fsys.modTime}, nil
+}
+
+func (d *titleRootDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: ".", Err: errors.New("is a directory")}
+}
+
+func (d *titleRootDir) Close() error {
+	return nil
+}
+
+func (d *titleRootDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	names := d.fsys.names[d.offset:]
+	if n > 0 {
+		if len(names) == 0 {
+			return nil, io.EOF
+		}
+		names = names[:min(n, len(names))]
+	}
+	d.offset += len(names)
+	entries := make([]fs.DirEntry, len(names))
+	for i, name := range names {
+		info, err := d.fsys.titles[name].Stat(".")
+		if err != nil {
+			return nil, err
+		}
+		entries[i] = fs.FileInfoToDirEntry(dirInfo{name: name, modTime: info.ModTime()})
+	}
+	return entries, nil
+}
+
+// renamedDir is the root directory of a title, named after the title.
+type renamedDir struct {
+	fs.ReadDirFile
+	name string
+}
+
+func (d renamedDir) Stat() (fs.FileInfo, error) {
+	info, err := d.ReadDirFile.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return dirInfo{name: d.name, modTime: info.ModTime()}, nil
+}
+
+type dirInfo struct {
+	name    string
+	modTime time.Time
+}
+
+func (fi dirInfo) Name() string       { return fi.name }
+func (fi dirInfo) Size() int64        { return 0 }
+func (fi dirInfo) Mode() fs.FileMode  { return fs.ModeDir | 0o555 }
+func (fi dirInfo) ModTime() time.Time { return fi.modTime }
+func (fi dirInfo) IsDir() bool        { return true }
+func (fi dirInfo) Sys() any           { return nil }
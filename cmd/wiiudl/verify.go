@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	wiiudownloader "github.com/Xpl0itU/WiiUDownloader"
+)
+
+func runVerify(args []string) int {
+	flags := flag.NewFlagSet("verify", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: wiiudl verify [flags] <title folder>...")
+		flags.PrintDefaults()
+	}
+	quiet := flags.Bool("q", false, "do not print progress")
+	verbose := flags.Bool("v", false, "print the result of every content")
+	signatures := flags.Bool("signatures", false, "also check the signatures of title.tmd and title.tik against their certificate chain")
+	rootKeyPath := flags.String("root-key", "", "file holding the root key modulus, raw or in hexadecimal, to check the CA certificates with (implies -signatures)")
+	if err := flags.Parse(args); err != nil {
+		return flagParseExitCode(err)
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return EXIT_USAGE
+	}
+	var signatureOpts wiiudownloader.SignatureOptions
+	if *rootKeyPath != "" {
+		rootKey, err := loadRootKey(*rootKeyPath)
+		if err != nil {
+			printFailure(*rootKeyPath, err)
+			return EXIT_USAGE
+		}
+		signatureOpts.RootKey = rootKey
+		*signatures = true
+	}
+
+	reporter := NewTextProgressReporter(os.Stderr, *quiet)
+	stop := cancelOnInterrupt(reporter)
+	defer stop()
+
+	failed := 0
+	succeeded := 0
+	for _, path := range flags.Args() {
+		if reporter.Cancelled() {
+			break
+		}
+		reporter.SetGameTitle(path)
+		report, err := wiiudownloader.VerifyTitle(path, reporter)
+		reporter.Finish()
+		if errors.Is(err, wiiudownloader.ErrCancelled) {
+			break
+		}
+		if err != nil {
+			failed++
+			printFailure(path, err)
+			continue
+		}
+		if *verbose {
+			for _, content := range report.Contents {
+				fmt.Println(content)
+			}
+		}
+		if contents := report.Failed(); len(contents) > 0 {
+			failed++
+			for _, content := range contents {
+				printFailure(path, content.Err)
+			}
+			continue
+		}
+		if *signatures && !checkSignatures(path, signatureOpts) {
+			failed++
+			continue
+		}
+		succeeded++
+		fmt.Fprintf(os.Stderr, "OK     %s (%d contents)\n", path, len(report.Contents))
+	}
+	return downloadExitCode(succeeded, failed, flags.NArg(), reporter.Cancelled())
+}
+
+// checkSignatures prints the signature status of title.tmd and title.tik in
+// path and reports whether neither is invalid.
+func checkSignatures(path string, opts wiiudownloader.SignatureOptions) bool {
+	report, err := wiiudownloader.VerifyTitleSignatures(path, opts)
+	if err != nil {
+		printFailure(path, err)
+		return false
+	}
+	for _, file := range []struct {
+		name   string
+		status wiiudownloader.SignatureStatus
+		err    error
+	}{
+		{"title.tmd", report.TMD, report.TMDErr},
+		{"title.tik", report.Ticket, report.TicketErr},
+	} {
+		if file.err != nil {
+			printFailure(filepath.Join(path, file.name), fmt.Errorf("invalid signature: %w", file.err))
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "       %s: %s\n", file.name, file.status)
+	}
+	if report.TMD == wiiudownloader.SignatureChainUnverified || report.Ticket == wiiudownloader.SignatureChainUnverified {
+		fmt.Fprintln(os.Stderr, "       pass -root-key to tell whether it is signed by Nintendo")
+	}
+	return report.OK()
+}
+
+// loadRootKey reads the root key modulus from a file holding it raw or as
+// hexadecimal digits.
+func loadRootKey(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == wiiudownloader.ROOT_KEY_SIZE {
+		return data, nil
+	}
+	key, err := hex.DecodeString(strings.Join(strings.Fields(string(data)), ""))
+	if err != nil || len(key) != wiiudownloader.ROOT_KEY_SIZE {
+		return nil, fmt.Errorf("root key must be %d bytes, raw or in hexadecimal", wiiudownloader.ROOT_KEY_SIZE)
+	}
+	return key, nil
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	wiiudownloader "github.com/Xpl0itU/WiiUDownloader"
+)
+
+func runWAD(args []string) int {
+	flags := flag.NewFlagSet("wad", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: wiiudl wad [flags] -o <file.wad> <title folder>")
+		fmt.Fprintln(os.Stderr, "       wiiudl wad -extract [flags] -o <title folder> <file.wad>")
+		fmt.Fprintln(os.Stderr, "Builds an installable WAD from an encrypted Wii or vWii title folder, or unpacks")
+		fmt.Fprintln(os.Stderr, "a WAD into a title folder that can be verified and decrypted.")
+		flags.PrintDefaults()
+	}
+	extract := flags.Bool("extract", false, "unpack a WAD instead of building one")
+	output := flags.String("o", "", "path of the WAD, or with -extract of the title folder, to write")
+	quiet := flags.Bool("q", false, "do not print progress")
+	if err := flags.Parse(args); err != nil {
+		return flagParseExitCode(err)
+	}
+	if *output == "" || flags.NArg() != 1 {
+		flags.Usage()
+		return EXIT_USAGE
+	}
+
+	reporter := NewTextProgressReporter(os.Stderr, *quiet)
+	stop := cancelOnInterrupt(reporter)
+	defer stop()
+
+	src := flags.Arg(0)
+	reporter.SetGameTitle(src)
+	var err error
+	if *extract {
+		_, err = wiiudownloader.ImportWAD(src, *output, reporter)
+	} else {
+		err = wiiudownloader.ExportWAD(src, *output, reporter)
+	}
+	reporter.Finish()
+	if errors.Is(err, wiiudownloader.ErrCancelled) {
+		return EXIT_INTERRUPTED
+	}
+	if err != nil {
+		printFailure(src, err)
+		return EXIT_FAILURE
+	}
+	fmt.Fprintf(os.Stderr, "OK     %s -> %s\n", src, *output)
+	return EXIT_OK
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	wiiudownloader "github.com/Xpl0itU/WiiUDownloader"
+)
+
+func runWUA(args []string) int {
+	flags := flag.NewFlagSet("wua", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: wiiudl wua [flags] -o <file.wua> <title folder>...")
+		fmt.Fprintln(os.Stderr, "Packs a game with its update and DLC into a single .wua archive for Cemu.")
+		fmt.Fprintln(os.Stderr, "Title folders can be decrypted or still encrypted.")
+		flags.PrintDefaults()
+	}
+	output := flags.String("o", "", "path of the .wua archive to write")
+	quiet := flags.Bool("q", false, "do not print progress")
+	if err := flags.Parse(args); err != nil {
+		return flagParseExitCode(err)
+	}
+	if *output == "" || flags.NArg() == 0 {
+		flags.Usage()
+		return EXIT_USAGE
+	}
+
+	reporter := NewTextProgressReporter(os.Stderr, *quiet)
+	stop := cancelOnInterrupt(reporter)
+	defer stop()
+
+	reporter.SetGameTitle(*output)
+	titles, err := wiiudownloader.ExportWUA(*output, flags.Args(), reporter)
+	reporter.Finish()
+	if errors.Is(err, wiiudownloader.ErrCancelled) {
+		return EXIT_INTERRUPTED
+	}
+	if err != nil {
+		printFailure(*output, err)
+		return EXIT_FAILURE
+	}
+	for _, title := range titles {
+		fmt.Fprintf(os.Stderr, "OK     %s -> %s in %s\n", title.Source, title.Folder, *output)
+	}
+	return EXIT_OK
+}
@@ -0,0 +1,194 @@
+package wiiudownloader
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Contents without a hash tree are decrypted in pieces of at most this size.
+const contentReaderChunkSize = 1 << 20
+
+// contentReader decrypts a content on demand. It implements io.ReaderAt, so
+// only the parts of a content that are read are decrypted and memory use does
+// not depend on the size of the content. Contents with a hash tree are read as
+// their decrypted 0x400 byte hash headers followed by 0xFC00 bytes of data per
+// block, and every block is checked against the hash tree when it is read.
+type contentReader struct {
+	file           *os.File
+	content        Content
+	cipherHashTree cipher.Block
+	size           int64
+	// plain is set by Verify for contents that are already decrypted.
+	plain bool
+
+	mu          sync.Mutex
+	h3Data      []byte
+	encrypted   []byte
+	hashes      []byte
+	data        []byte
+	cachedBlock int64
+}
+
+// openContentReader opens the .app file of content in the title folder path.
+func openContentReader(path string, content Content, cipherHashTree cipher.Block) (*contentReader, error) {
+	file, err := os.Open(filepath.Join(path, content.CIDStr+".app"))
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	r := &contentReader{
+		file:           file,
+		content:        content,
+		cipherHashTree: cipherHashTree,
+		cachedBlock:    -1,
+	}
+	if content.Type&CONTENT_TYPE_HASHED != 0 {
+		r.h3Data, err = readH3File(filepath.Join(path, content.CIDStr+".h3"), content)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		r.size = info.Size() / BLOCK_SIZE_HASHED * BLOCK_SIZE_HASHED
+		r.encrypted = make([]byte, BLOCK_SIZE_HASHED)
+		r.hashes = make([]byte, HASHES_SIZE)
+		r.data = make([]byte, HASH_BLOCK_SIZE)
+	} else {
+		r.size = int64(content.Size)
+		r.encrypted = make([]byte, aes.BlockSize+contentReaderChunkSize)
+		r.data = make([]byte, contentReaderChunkSize)
+	}
+	return r, nil
+}
+
+// Size returns the number of bytes that can be read from r.
+func (r *contentReader) Size() int64 {
+	return r.size
+}
+
+// Verify checks a content without a hash tree against the content hash in the
+// TMD by decrypting it once. A content whose file already matches the hash is
+// taken as decrypted and read as it is from then on. Contents with a hash tree
+// are checked block by block as they are read instead.
+func (r *contentReader) Verify() error {
+	if r.content.Type&CONTENT_TYPE_HASHED != 0 {
+		return nil
+	}
+	info, err := r.file.Stat()
+	if err != nil {
+		return err
+	}
+	rawHash := sha1.New()
+	src := io.TeeReader(io.NewSectionReader(r.file, 0, info.Size()), rawHash)
+	err = decryptContent(src, io.Discard, r.cipherHashTree, r.content)
+	if len(r.content.Hash) >= sha1.Size {
+		if _, copyErr := io.Copy(io.Discard, src); copyErr == nil && bytes.Equal(r.content.Hash[:sha1.Size], rawHash.Sum(nil)) {
+			r.mu.Lock()
+			r.plain = true
+			r.size = info.Size()
+			r.mu.Unlock()
+			return nil
+		}
+	}
+	return err
+}
+
+// ReadAt implements io.ReaderAt.
+func (r *contentReader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("contentReader.ReadAt: negative offset")
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	n := 0
+	for n < len(p) && off < r.size {
+		var m int
+		var err error
+		switch {
+		case r.plain:
+			m, err = r.file.ReadAt(p[n:min(int64(len(p)), int64(n)+r.size-off)], off)
+		case r.content.Type&CONTENT_TYPE_HASHED != 0:
+			m, err = r.readHashedAt(p[n:], off)
+		default:
+			m, err = r.readEncryptedAt(p[n:], off)
+		}
+		n += m
+		off += int64(m)
+		if err != nil && (err != io.EOF || off < r.size) {
+			return n, err
+		}
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// readHashedAt copies from the block that contains off, decrypting it first
+// unless it was the last one read.
+func (r *contentReader) readHashedAt(p []byte, off int64) (int, error) {
+	block := off / BLOCK_SIZE_HASHED
+	if block != r.cachedBlock {
+		r.cachedBlock = -1
+		if _, err := r.file.ReadAt(r.encrypted, block*BLOCK_SIZE_HASHED); err != nil {
+			return 0, fmt.Errorf("failed to read block %d of %s.app: %w", block, r.content.CIDStr, err)
+		}
+		if err := decryptHashedBlock(r.encrypted, block, r.h3Data, r.content, r.cipherHashTree, r.hashes, r.data); err != nil {
+			return 0, err
+		}
+		r.cachedBlock = block
+	}
+	within := off % BLOCK_SIZE_HASHED
+	if within < HASHES_SIZE {
+		return copy(p, r.hashes[within:]), nil
+	}
+	return copy(p, r.data[within-HASHES_SIZE:]), nil
+}
+
+// readEncryptedAt decrypts the AES blocks covering p. The CBC IV of a block
+// is the encrypted block before it, or the content index for the first one.
+func (r *contentReader) readEncryptedAt(p []byte, off int64) (int, error) {
+	start := off &^ (aes.BlockSize - 1)
+	end := int64(alignToAESBlockSize(uint64(min(off+int64(len(p)), r.size))))
+	end = min(end, start+contentReaderChunkSize)
+
+	var iv []byte
+	encrypted := r.encrypted[:aes.BlockSize+end-start]
+	if start == 0 {
+		iv = make([]byte, aes.BlockSize)
+		copy(iv, r.content.Index)
+		encrypted = encrypted[aes.BlockSize:]
+		if _, err := r.file.ReadAt(encrypted, 0); err != nil {
+			return 0, fmt.Errorf("failed to read %s.app at offset 0: %w", r.content.CIDStr, err)
+		}
+	} else {
+		if _, err := r.file.ReadAt(encrypted, start-aes.BlockSize); err != nil {
+			return 0, fmt.Errorf("failed to read %s.app at offset %d: %w", r.content.CIDStr, start, err)
+		}
+		iv, encrypted = encrypted[:aes.BlockSize], encrypted[aes.BlockSize:]
+	}
+
+	data := r.data[:len(encrypted)]
+	cipher.NewCBCDecrypter(r.cipherHashTree, iv).CryptBlocks(data, encrypted)
+	within := off - start
+	limit := min(int64(len(data)), r.size-start)
+	return copy(p, data[within:limit]), nil
+}
+
+// Close closes the underlying .app file.
+func (r *contentReader) Close() error {
+	return r.file.Close()
+}
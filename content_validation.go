@@ -1,11 +1,16 @@
 package wiiudownloader
 
 import (
+	"bufio"
 	"bytes"
+	"crypto/cipher"
 	"crypto/sha1"
-	"encoding/binary"
 	"errors"
+	"fmt"
+	"io"
 	"os"
+
+	ticketfmt "github.com/Xpl0itU/WiiUDownloader/internal/formats/ticket"
 )
 
 const tidHighWiiSystemApp = 0x00010002
@@ -53,18 +58,50 @@ func expectedH3DownloadSize(content Content) int64 {
 }
 
 func verifyH3File(path string, content Content) error {
+	_, err := readH3File(path, content)
+	return err
+}
+
+// readH3File reads the H3 table of a hashed content and checks it against the
+// content hash in the TMD.
+func readH3File(path string, content Content) ([]byte, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	sum := sha1.Sum(data)
 	if len(content.Hash) < sha1.Size || !bytes.Equal(sum[:], content.Hash[:sha1.Size]) {
-		return errors.New("H3 Hash mismatch")
+		return nil, &HashMismatchError{File: fmt.Sprintf("%08X.h3", content.ID), ContentID: content.ID, Hash: "H3"}
 	}
-	return nil
+	return data, nil
 }
 
-func validateTMDFile(path string, expectedTitleID uint64) error {
+// validateContentFile checks a downloaded .app file against the H0-H3 hash tree
+// whose H3 table is in h3Path for hashed contents, or against the TMD hash for
+// the others. The .h3 file must have been downloaded already.
+func validateContentFile(path, h3Path string, content Content, cipherHashTree cipher.Block) error {
+	// Errors name the .app file after CIDStr, which ParseTMD leaves empty.
+	content.CIDStr = fmt.Sprintf("%08X", content.ID)
+	var h3Data []byte
+	if content.Type&CONTENT_TYPE_HASHED != 0 {
+		var err error
+		if h3Data, err = readH3File(h3Path, content); err != nil {
+			return err
+		}
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	reader := bufio.NewReaderSize(file, BLOCK_SIZE_HASHED)
+	if h3Data != nil {
+		return verifyHashedContent(reader, h3Data, content, cipherHashTree)
+	}
+	return decryptContent(reader, io.Discard, cipherHashTree, content)
+}
+
+func validateTMDFile(path string, expectedTitleID uint64, expectedTitleVersion *uint16) error {
 	data, err := os.ReadFile(path)
 	if err != nil {
 		return err
@@ -76,6 +113,9 @@ func validateTMDFile(path string, expectedTitleID uint64) error {
 	if !titleIDsMatchTMD(expectedTitleID, tmd.TitleID, tmd.Version) {
 		return errors.New("title.tmd title ID mismatch")
 	}
+	if expectedTitleVersion != nil && tmd.TitleVersion != *expectedTitleVersion {
+		return fmt.Errorf("title.tmd is version %d, expected %d", tmd.TitleVersion, *expectedTitleVersion)
+	}
 	return nil
 }
 
@@ -84,15 +124,14 @@ func validateTicketFile(path string, expectedTitleID uint64, expectedTitleVersio
 	if err != nil {
 		return err
 	}
-	if len(data) < TICKET_TITLE_VERSION_OFFSET+TICKET_TITLE_VERSION_SIZE {
-		return errors.New("title.tik too small")
+	ticket, err := ticketfmt.Parse(data)
+	if err != nil {
+		return fmt.Errorf("title.tik: %w", err)
 	}
-	gotTitleID := binary.BigEndian.Uint64(data[TICKET_TITLE_ID_OFFSET : TICKET_TITLE_ID_OFFSET+TICKET_TITLE_ID_SIZE])
-	if expectedTitleID != 0 && gotTitleID != expectedTitleID {
+	if expectedTitleID != 0 && ticket.TitleID != expectedTitleID {
 		return errors.New("title.tik title ID mismatch")
 	}
-	gotTitleVersion := binary.BigEndian.Uint16(data[TICKET_TITLE_VERSION_OFFSET : TICKET_TITLE_VERSION_OFFSET+TICKET_TITLE_VERSION_SIZE])
-	if expectedTitleVersion != 0 && gotTitleVersion != expectedTitleVersion {
+	if expectedTitleVersion != 0 && ticket.TitleVersion != expectedTitleVersion {
 		return errors.New("title.tik title version mismatch")
 	}
 	return nil
@@ -0,0 +1,56 @@
+package wiiudownloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestValidateContentFileNamesContent(t *testing.T) {
+	dir := t.TempDir()
+	src, original, title := filepath.Join(dir, "src"), filepath.Join(dir, "original"), filepath.Join(dir, "title")
+	writeTestFiles(t, src, testTitleFiles())
+	writeTestWiiUOriginal(t, original)
+	if err := RepackTitle(context.Background(), src, title, RepackTitleOptions{Original: original}); err != nil {
+		t.Fatalf("RepackTitle: %v", err)
+	}
+	tmd, err := readTitleTMD(title)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cipherHashTree, err := titleKeyCipher(title, tmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The last content holds content/big.bin, which spans several blocks.
+	content := tmd.Contents[len(tmd.Contents)-1]
+	if content.Type&CONTENT_TYPE_HASHED == 0 || content.Size < 2*BLOCK_SIZE_HASHED {
+		t.Fatalf("content %08X is not a hashed content of several blocks", content.ID)
+	}
+	name := fmt.Sprintf("%08X", content.ID)
+	appPath, h3Path := filepath.Join(title, name+".app"), filepath.Join(title, name+".h3")
+	if err := validateContentFile(appPath, h3Path, content, cipherHashTree); err != nil {
+		t.Fatalf("validateContentFile of the repacked content: %v", err)
+	}
+
+	data, err := os.ReadFile(appPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[BLOCK_SIZE_HASHED+HASHES_SIZE+100] ^= 0xFF
+	if err := os.WriteFile(appPath, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	err = validateContentFile(appPath, h3Path, content, cipherHashTree)
+	var hashErr *HashTreeError
+	if !errors.As(err, &hashErr) {
+		t.Fatalf("validateContentFile of a corrupted block = %v, want a HashTreeError", err)
+	}
+	if hashErr.File != name+".app" || hashErr.Block != 1 || hashErr.Level != "H0" {
+		t.Fatalf("HashTreeError = %+v, want H0 of block 1 of %s.app", hashErr, name)
+	}
+}
@@ -1,15 +1,18 @@
 package wiiudownloader
 
 import (
+	"context"
 	"crypto/aes"
 	"crypto/cipher"
 	"encoding/binary"
 	"errors"
 	"fmt"
-	"io"
 	"log"
 	"os"
 	"path/filepath"
+	"runtime"
+
+	ticketfmt "github.com/Xpl0itU/WiiUDownloader/internal/formats/ticket"
 )
 
 var wiiUCommonKey = []byte{0xD7, 0xB0, 0x04, 0x02, 0x65, 0x9B, 0xA2, 0xAB, 0xD2, 0xCB, 0x0D, 0xB2, 0x7F, 0xA2, 0xB6, 0x56}
@@ -41,41 +44,133 @@ type Content struct {
 	CIDStr string
 }
 
-func DecryptContents(path string, progressReporter ProgressReporter, deleteEncryptedContents bool) (err error) {
+// DecryptContentsOptions configures DecryptContentsWithOptions.
+type DecryptContentsOptions struct {
+	// ProgressReporter may be nil.
+	ProgressReporter        ProgressReporter
+	DeleteEncryptedContents bool
+	// Workers is the number of files extracted in parallel. Zero uses one
+	// worker per CPU.
+	Workers int
+	// Filter selects the files of a Wii U title to extract; the zero value
+	// extracts all of them. Contents that hold no selected file may be missing
+	// from the folder. DeleteEncryptedContents is ignored with a filter, as
+	// the encrypted contents still hold the other files.
+	Filter PathFilter
+}
+
+func DecryptContents(path string, progressReporter ProgressReporter, deleteEncryptedContents bool) error {
+	return DecryptContentsWithOptions(context.Background(), path, DecryptContentsOptions{
+		ProgressReporter:        progressReporter,
+		DeleteEncryptedContents: deleteEncryptedContents,
+	})
+}
+
+// DecryptContentsWithOptions decrypts the title folder in path. The files of a
+// Wii U title are extracted in parallel. It returns ErrCancelled when ctx or
+// the progress reporter is cancelled; files extracted until then are kept and
+// recorded in a journal in path, so that the next run only checks them
+// instead of extracting them again.
+func DecryptContentsWithOptions(ctx context.Context, path string, opts DecryptContentsOptions) (err error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	if err := opts.Filter.Validate(); err != nil {
+		return err
+	}
+	progressReporter := opts.ProgressReporter
+	deleteEncryptedContents := opts.DeleteEncryptedContents && opts.Filter.IsZero()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stopMonitor := monitorCancellation(ctx, cancel, progressReporter)
+	defer stopMonitor()
+
 	defer func() {
-		if err != nil {
-			err = fmt.Errorf("decryption error: %w", err)
+		if err != nil && (isCancelled(progressReporter) || ctx.Err() != nil) {
+			err = ErrCancelled
+		}
+		if err != nil && err != ErrCancelled {
+			err = fmt.Errorf("decryption error: %w", wrapDiskFull(err))
 		}
 	}()
 
-	tmdPath := filepath.Join(path, "title.tmd")
-	if _, statErr := os.Stat(tmdPath); os.IsNotExist(statErr) {
-		return statErr
-	}
-
-	tmdData, err := os.ReadFile(tmdPath)
+	tmd, cipherHashTree, err := loadTitleCipher(path, opts.Filter.IsZero())
 	if err != nil {
 		return err
 	}
 
-	tmd, err := ParseTMD(tmdData)
+	if tmd.Version == TMD_VERSION_WIIU {
+		if err := extractWiiUContents(ctx, path, tmd, cipherHashTree, progressReporter, deleteEncryptedContents, workers, opts.Filter); err != nil {
+			return err
+		}
+	} else {
+		if err := extractWiiContents(ctx, path, tmd, cipherHashTree, progressReporter, deleteEncryptedContents); err != nil {
+			return err
+		}
+	}
+
+	if progressReporter != nil {
+		progressReporter.UpdateDecryptionProgress(1.0)
+	}
+	if deleteEncryptedContents {
+		if err := doDeleteEncryptedContents(path); err != nil {
+			log.Printf("failed to remove encrypted contents in %q: %v", path, err)
+		}
+	}
+	return nil
+}
+
+// loadTitleCipher reads the TMD and ticket of the title in path and returns
+// the TMD together with a cipher for its decrypted title key. Unless
+// allContents is set, contents missing from path keep their upper case names
+// instead of failing.
+func loadTitleCipher(path string, allContents bool) (*TMD, cipher.Block, error) {
+	tmd, err := readTitleTMD(path)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
+	if err := resolveContentFileNames(path, tmd); err != nil && allContents {
+		return nil, nil, err
+	}
+	cipherHashTree, err := titleKeyCipher(path, tmd)
+	if err != nil {
+		return nil, nil, err
+	}
+	return tmd, cipherHashTree, nil
+}
 
-	if err := resolveContentFileNames(path, tmd); err != nil {
-		return err
+func readTitleTMD(path string) (*TMD, error) {
+	tmdPath := filepath.Join(path, "title.tmd")
+	if _, statErr := os.Stat(tmdPath); os.IsNotExist(statErr) {
+		return nil, statErr
 	}
 
+	tmdData, err := os.ReadFile(tmdPath)
+	if err != nil {
+		return nil, err
+	}
+	return ParseTMD(tmdData)
+}
+
+// titleKeyCipher decrypts the title key in the ticket of the title in path.
+func titleKeyCipher(path string, tmd *TMD) (cipher.Block, error) {
 	encryptedTitleKey, ticketKeyIndex, err := readTicketData(filepath.Join(path, "title.tik"))
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if encryptedTitleKey == nil {
+		return nil, fmt.Errorf("%w: title.tik not found", ErrTicketUnavailable)
 	}
 
 	selectedCommonKey := chooseCommonKey(tmd.Version, ticketKeyIndex)
 	cbcCipher, err := aes.NewCipher(selectedCommonKey)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	var titleIDBytes [8]byte
@@ -89,76 +184,54 @@ func DecryptContents(path string, progressReporter ProgressReporter, deleteEncry
 
 	cipherHashTree, err := aes.NewCipher(decryptedTitleKey)
 	if err != nil {
-		return fmt.Errorf("failed to create AES cipher: %w", err)
+		return nil, fmt.Errorf("failed to create AES cipher: %w", err)
 	}
-
-	if tmd.Version == TMD_VERSION_WIIU {
-		if err := extractWiiUContents(path, tmd, cipherHashTree, progressReporter, deleteEncryptedContents); err != nil {
-			return err
-		}
-	} else {
-		if err := extractWiiContents(path, tmd, cipherHashTree, progressReporter, deleteEncryptedContents); err != nil {
-			return err
-		}
-	}
-
-	if progressReporter != nil {
-		progressReporter.UpdateDecryptionProgress(1.0)
-	}
-	if deleteEncryptedContents {
-		if err := doDeleteEncryptedContents(path); err != nil {
-			log.Printf("failed to remove encrypted contents in %q: %v", path, err)
-		}
-	}
-	return nil
+	return cipherHashTree, nil
 }
 
+// resolveContentFileNames resolves the names of every content, even after
+// one of them is found missing.
 func resolveContentFileNames(path string, tmd *TMD) error {
+	var err error
 	for i := range tmd.Contents {
-		tmd.Contents[i].CIDStr = fmt.Sprintf("%08X", tmd.Contents[i].ID)
-		if _, err := os.Stat(filepath.Join(path, tmd.Contents[i].CIDStr+".app")); err == nil {
-			continue
+		if resolveErr := resolveContentFileName(path, &tmd.Contents[i]); resolveErr != nil && err == nil {
+			err = errors.New("content not found")
 		}
+	}
+	return err
+}
 
-		tmd.Contents[i].CIDStr = fmt.Sprintf("%08x", tmd.Contents[i].ID)
-		if _, err := os.Stat(filepath.Join(path, tmd.Contents[i].CIDStr+".app")); err != nil {
-			return errors.New("content not found")
-		}
+// resolveContentFileName sets CIDStr to the spelling of the content ID used by
+// the .app file in path, which may be upper or lower case.
+func resolveContentFileName(path string, content *Content) error {
+	content.CIDStr = fmt.Sprintf("%08X", content.ID)
+	if _, err := os.Stat(filepath.Join(path, content.CIDStr+".app")); err == nil {
+		return nil
 	}
+
+	lower := fmt.Sprintf("%08x", content.ID)
+	if _, err := os.Stat(filepath.Join(path, lower+".app")); err != nil {
+		return err
+	}
+	content.CIDStr = lower
 	return nil
 }
 
 func readTicketData(ticketPath string) ([]byte, byte, error) {
 	const TICKET_KEY_INDEX_UNKNOWN = 0xFF
 
-	ticketKeyIndex := byte(TICKET_KEY_INDEX_UNKNOWN)
-	if _, err := os.Stat(ticketPath); err != nil {
+	data, err := os.ReadFile(ticketPath)
+	if err != nil {
 		if os.IsNotExist(err) {
-			return nil, ticketKeyIndex, nil
+			return nil, TICKET_KEY_INDEX_UNKNOWN, nil
 		}
-		return nil, ticketKeyIndex, err
+		return nil, TICKET_KEY_INDEX_UNKNOWN, err
 	}
-
-	cetk, err := os.Open(ticketPath)
+	ticket, err := ticketfmt.Parse(data)
 	if err != nil {
-		return nil, ticketKeyIndex, err
-	}
-	defer cetk.Close()
-
-	if _, err := cetk.Seek(TICKET_ENCRYPTED_KEY_OFFSET, io.SeekStart); err != nil {
-		return nil, ticketKeyIndex, err
-	}
-	encryptedTitleKey := make([]byte, TICKET_ENCRYPTED_KEY_SIZE)
-	if _, err := io.ReadFull(cetk, encryptedTitleKey); err != nil {
-		return nil, ticketKeyIndex, err
-	}
-	if _, err := cetk.Seek(TICKET_KEY_INDEX_OFFSET, io.SeekStart); err != nil {
-		return nil, ticketKeyIndex, err
-	}
-	if err := binary.Read(cetk, binary.BigEndian, &ticketKeyIndex); err != nil {
-		return nil, ticketKeyIndex, err
+		return nil, TICKET_KEY_INDEX_UNKNOWN, fmt.Errorf("title.tik: %w", err)
 	}
-	return encryptedTitleKey, ticketKeyIndex, nil
+	return ticket.TitleKey[:], ticket.CommonKeyIndex, nil
 }
 
 func chooseCommonKey(tmdVersion byte, ticketKeyIndex byte) []byte {
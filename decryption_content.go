@@ -3,14 +3,13 @@ package wiiudownloader
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/sha1"
-	"errors"
 	"fmt"
 	"io"
 	"os"
-	"path/filepath"
 )
 
 const (
@@ -26,9 +25,12 @@ const (
 	HASH_H2_END   = 0x3c0
 )
 
-func extractFileHash(src *os.File, partDataOffset uint64, fileOffset uint64, size uint64, path string, contentID uint16, cipherHashTree cipher.Block) error {
+// extractFileHash writes size bytes at fileOffset of the data of a hashed
+// content to path, and to digest unless it is nil. h3Data is the H3 table of
+// the content, already checked by readH3File.
+func extractFileHash(ctx context.Context, src *os.File, partDataOffset uint64, fileOffset uint64, size uint64, path string, content Content, h3Data []byte, cipherHashTree cipher.Block, digest io.Writer) error {
 	writeSize := HASH_BLOCK_SIZE
-	blockNumber := (fileOffset / HASH_BLOCK_SIZE) & (HASH_ENTRIES_PER_LEVEL - 1)
+	block := int64(fileOffset / HASH_BLOCK_SIZE)
 
 	dst, err := os.Create(path)
 	if err != nil {
@@ -38,6 +40,10 @@ func extractFileHash(src *os.File, partDataOffset uint64, fileOffset uint64, siz
 
 	bw := bufio.NewWriterSize(dst, BLOCK_SIZE_HASHED)
 	defer bw.Flush()
+	var out io.Writer = bw
+	if digest != nil {
+		out = io.MultiWriter(bw, digest)
+	}
 
 	readOffset := fileOffset / HASH_BLOCK_SIZE * BLOCK_SIZE_HASHED
 	subOffset := fileOffset - (fileOffset / HASH_BLOCK_SIZE * HASH_BLOCK_SIZE)
@@ -53,70 +59,28 @@ func extractFileHash(src *os.File, partDataOffset uint64, fileOffset uint64, siz
 	decryptedHashedContentBuffer := make([]byte, HASH_BLOCK_SIZE)
 	hashes := make([]byte, HASHES_SIZE)
 
-	fileInfo, err := src.Stat()
-	if err != nil {
-		return err
-	}
-	fileSize := fileInfo.Size()
-
 	for size > 0 {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 		if uint64(writeSize) > size {
 			writeSize = int(size)
 		}
 
-		currentPos := int64(partDataOffset + readOffset)
-		remainingFile := fileSize - currentPos
-		if remainingFile <= 0 {
-			return io.ErrUnexpectedEOF
-		}
-
-		readLen := BLOCK_SIZE_HASHED
-		if int64(readLen) > remainingFile {
-			readLen = int(remainingFile)
-		}
-		if readLen%aes.BlockSize != 0 {
-			return fmt.Errorf("read length %d is not a multiple of AES block size", readLen)
-		}
-		if readLen < HASHES_SIZE {
-			return io.ErrUnexpectedEOF
-		}
-
-		if _, err := io.ReadFull(src, encryptedHashedContentBuffer[:readLen]); err != nil {
+		if _, err := io.ReadFull(src, encryptedHashedContentBuffer); err != nil {
 			return fmt.Errorf("failed to read encrypted content block at offset %d: %w", partDataOffset+readOffset, err)
 		}
-
-		var iv [aes.BlockSize]byte
-		iv[1] = byte(contentID)
-		cipher.NewCBCDecrypter(cipherHashTree, iv[:]).CryptBlocks(hashes, encryptedHashedContentBuffer[:HASHES_SIZE])
-
-		h0RangeStart := int(HASH_ENTRY_SIZE * blockNumber)
-		h0RangeEnd := h0RangeStart + sha1.Size
-		h0Hash := hashes[h0RangeStart:h0RangeEnd]
-		var ivBlock [aes.BlockSize]byte
-		copy(ivBlock[:], hashes[h0RangeStart:h0RangeStart+aes.BlockSize])
-		if blockNumber == 0 {
-			ivBlock[1] ^= byte(contentID)
-		}
-
-		cipher.NewCBCDecrypter(cipherHashTree, ivBlock[:]).CryptBlocks(decryptedHashedContentBuffer, encryptedHashedContentBuffer[HASHES_SIZE:readLen])
-		hash := sha1.Sum(decryptedHashedContentBuffer[:HASH_BLOCK_SIZE])
-		if blockNumber == 0 {
-			hash[1] ^= byte(contentID)
-		}
-		if !bytes.Equal(hash[:], h0Hash) {
-			return errors.New("h0 hash mismatch")
+		if err := decryptHashedBlock(encryptedHashedContentBuffer, block, h3Data, content, cipherHashTree, hashes, decryptedHashedContentBuffer); err != nil {
+			return err
 		}
 
-		n, err := bw.Write(decryptedHashedContentBuffer[subOffset : subOffset+uint64(writeSize)])
+		n, err := out.Write(decryptedHashedContentBuffer[subOffset : subOffset+uint64(writeSize)])
 		if err != nil {
 			return err
 		}
 		size -= uint64(n)
 
-		blockNumber++
-		if blockNumber >= HASH_ENTRIES_PER_LEVEL {
-			blockNumber = 0
-		}
+		block++
 		if subOffset != 0 {
 			writeSize = HASH_BLOCK_SIZE
 			subOffset = 0
@@ -126,7 +90,72 @@ func extractFileHash(src *os.File, partDataOffset uint64, fileOffset uint64, siz
 	return nil
 }
 
-func extractFile(src *os.File, partDataOffset uint64, fileOffset uint64, size uint64, path string, contentID uint16, cipherHashTree cipher.Block) error {
+// decryptHashedBlock decrypts one 0x10000 byte block of a hashed content into
+// its 0x400 byte hash header and 0xFC00 bytes of data, and checks the data
+// against the H0 table and every hash table up to the H3 table of the content.
+func decryptHashedBlock(encrypted []byte, block int64, h3Data []byte, content Content, cipherHashTree cipher.Block, hashes []byte, data []byte) error {
+	var zeroIV [aes.BlockSize]byte
+	cipher.NewCBCDecrypter(cipherHashTree, zeroIV[:]).CryptBlocks(hashes, encrypted[:HASHES_SIZE])
+
+	h0Hashes := hashes[HASH_H0_START:HASH_H1_START]
+	h1Hashes := hashes[HASH_H1_START:HASH_H2_START]
+	h2Hashes := hashes[HASH_H2_START:HASH_H2_END]
+
+	h0Index := block % HASH_ENTRIES_PER_LEVEL
+	h1Index := block / HASH_ENTRIES_PER_LEVEL % HASH_ENTRIES_PER_LEVEL
+	h2Index := block / (HASH_ENTRIES_PER_LEVEL * HASH_ENTRIES_PER_LEVEL) % HASH_ENTRIES_PER_LEVEL
+	h3Index := block / (HASH_ENTRIES_PER_LEVEL * HASH_ENTRIES_PER_LEVEL * HASH_ENTRIES_PER_LEVEL)
+
+	treeError := func(level string) error {
+		return &HashTreeError{File: content.CIDStr + ".app", ContentID: content.ID, Block: block, Level: level}
+	}
+
+	if (h3Index+1)*HASH_ENTRY_SIZE > int64(len(h3Data)) {
+		return treeError("H3")
+	}
+	h0HashesHash := sha1.Sum(h0Hashes)
+	if !bytes.Equal(h0HashesHash[:], hashEntryAt(h1Hashes, h1Index)) {
+		return treeError("H1")
+	}
+	h1HashesHash := sha1.Sum(h1Hashes)
+	if !bytes.Equal(h1HashesHash[:], hashEntryAt(h2Hashes, h2Index)) {
+		return treeError("H2")
+	}
+	h2HashesHash := sha1.Sum(h2Hashes)
+	if !bytes.Equal(h2HashesHash[:], hashEntryAt(h3Data, h3Index)) {
+		return treeError("H3")
+	}
+
+	h0Hash := hashEntryAt(h0Hashes, h0Index)
+	cipher.NewCBCDecrypter(cipherHashTree, h0Hash[:aes.BlockSize]).CryptBlocks(data, encrypted[HASHES_SIZE:])
+	dataHash := sha1.Sum(data)
+	if !bytes.Equal(dataHash[:], h0Hash) {
+		return treeError("H0")
+	}
+	return nil
+}
+
+// verifyHashedContent checks every block of a hashed content against its hash
+// tree without writing anything.
+func verifyHashedContent(src io.Reader, h3Data []byte, content Content, cipherHashTree cipher.Block) error {
+	encrypted := make([]byte, BLOCK_SIZE_HASHED)
+	hashes := make([]byte, HASHES_SIZE)
+	data := make([]byte, HASH_BLOCK_SIZE)
+	blockCount := int64(content.Size / BLOCK_SIZE_HASHED)
+	for block := int64(0); block < blockCount; block++ {
+		if _, err := io.ReadFull(src, encrypted); err != nil {
+			return fmt.Errorf("failed to read block %d of %s.app: %w", block, content.CIDStr, err)
+		}
+		if err := decryptHashedBlock(encrypted, block, h3Data, content, cipherHashTree, hashes, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// extractFile writes size bytes at fileOffset of a content without a hash tree
+// to path, and to digest unless it is nil.
+func extractFile(ctx context.Context, src *os.File, partDataOffset uint64, fileOffset uint64, size uint64, path string, contentID uint16, cipherHashTree cipher.Block, digest io.Writer) error {
 	writeSize := BLOCK_SIZE
 
 	dst, err := os.Create(path)
@@ -137,6 +166,10 @@ func extractFile(src *os.File, partDataOffset uint64, fileOffset uint64, size ui
 
 	bw := bufio.NewWriterSize(dst, BLOCK_SIZE)
 	defer bw.Flush()
+	var out io.Writer = bw
+	if digest != nil {
+		out = io.MultiWriter(bw, digest)
+	}
 
 	readOffset := fileOffset / BLOCK_SIZE * BLOCK_SIZE
 	subOffset := fileOffset - (fileOffset / BLOCK_SIZE * BLOCK_SIZE)
@@ -162,6 +195,9 @@ func extractFile(src *os.File, partDataOffset uint64, fileOffset uint64, size ui
 	fileSize := fileInfo.Size()
 
 	for size > 0 {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 		if uint64(writeSize) > size {
 			writeSize = int(size)
 		}
@@ -186,7 +222,7 @@ func extractFile(src *os.File, partDataOffset uint64, fileOffset uint64, size ui
 
 		aesCipher.CryptBlocks(decryptedContentBuffer[:readLen], encryptedContentBuffer[:readLen])
 
-		n, err := bw.Write(decryptedContentBuffer[subOffset : subOffset+uint64(writeSize)])
+		n, err := out.Write(decryptedContentBuffer[subOffset : subOffset+uint64(writeSize)])
 		if err != nil {
 			return err
 		}
@@ -201,119 +237,9 @@ func extractFile(src *os.File, partDataOffset uint64, fileOffset uint64, size ui
 	return nil
 }
 
-func decryptContentToBuffer(encryptedFile *os.File, decryptedBuffer *bytes.Buffer, cipherHashTree cipher.Block, content Content) error {
-	hasHashTree := content.Type&CONTENT_TYPE_HASHED != 0
-	encryptedStat, err := encryptedFile.Stat()
-	if err != nil {
-		return err
-	}
-	encryptedSize := encryptedStat.Size()
-	path := filepath.Dir(encryptedFile.Name())
-
-	growSize := int64(content.Size)
-	if hasHashTree {
-		growSize = encryptedSize
-	}
-	if growSize > MAX_FST_SIZE {
-		return fmt.Errorf("FST size %d exceeds maximum limit of %d", growSize, MAX_FST_SIZE)
-	}
-	decryptedBuffer.Grow(int(growSize))
-
-	if hasHashTree {
-		chunkCount := encryptedSize / BLOCK_SIZE_HASHED
-		h3Data, err := os.ReadFile(filepath.Join(path, fmt.Sprintf("%s.h3", content.CIDStr)))
-		if err != nil {
-			return err
-		}
-		h3BytesSHASum := sha1.Sum(h3Data)
-		if len(content.Hash) < sha1.Size || !bytes.Equal(h3BytesSHASum[:], content.Hash[:sha1.Size]) {
-			return errors.New("H3 Hash mismatch")
-		}
-
-		h0HashNum := int64(0)
-		h1HashNum := int64(0)
-		h2HashNum := int64(0)
-		h3HashNum := int64(0)
-
-		hashes := make([]byte, HASHES_SIZE)
-		hashesBuffer := make([]byte, HASHES_SIZE)
-		decryptedDataBuffer := make([]byte, HASH_BLOCK_SIZE)
-
-		for chunkNum := int64(0); chunkNum < chunkCount; chunkNum++ {
-			if _, err := io.ReadFull(encryptedFile, hashesBuffer); err != nil {
-				return err
-			}
-			var zeroIV [aes.BlockSize]byte
-			cipher.NewCBCDecrypter(cipherHashTree, zeroIV[:]).CryptBlocks(hashes, hashesBuffer)
-
-			h0Hashes := hashes[HASH_H0_START:HASH_H1_START]
-			h1Hashes := hashes[HASH_H1_START:HASH_H2_START]
-			h2Hashes := hashes[HASH_H2_START:HASH_H2_END]
-
-			h0Hash := hashEntryAt(h0Hashes, h0HashNum)
-			h1Hash := hashEntryAt(h1Hashes, h1HashNum)
-			h2Hash := hashEntryAt(h2Hashes, h2HashNum)
-			h3Hash := hashEntryAt(h3Data, h3HashNum)
-
-			h0HashesHash := sha1.Sum(h0Hashes)
-			h1HashesHash := sha1.Sum(h1Hashes)
-			h2HashesHash := sha1.Sum(h2Hashes)
-
-			if !bytes.Equal(h0HashesHash[:], h1Hash) {
-				return errors.New("h0 Hashes Hash mismatch")
-			}
-			if !bytes.Equal(h1HashesHash[:], h2Hash) {
-				return errors.New("h1 Hashes Hash mismatch")
-			}
-			if !bytes.Equal(h2HashesHash[:], h3Hash) {
-				return errors.New("h2 Hashes Hash mismatch")
-			}
-
-			if _, err := io.ReadFull(encryptedFile, decryptedDataBuffer); err != nil {
-				return err
-			}
-
-			cipher.NewCBCDecrypter(cipherHashTree, h0Hash[:16]).CryptBlocks(decryptedDataBuffer, decryptedDataBuffer)
-			decryptedDataHash := sha1.Sum(decryptedDataBuffer)
-			if !bytes.Equal(decryptedDataHash[:], h0Hash) {
-				return errors.New("data block hash invalid")
-			}
-
-			if _, err = decryptedBuffer.Write(hashes); err != nil {
-				return err
-			}
-			if _, err = decryptedBuffer.Write(decryptedDataBuffer); err != nil {
-				return err
-			}
-
-			h0HashNum, h1HashNum, h2HashNum, h3HashNum = advanceHashIndices(h0HashNum, h1HashNum, h2HashNum, h3HashNum)
-		}
-		return nil
-	}
-
-	if len(content.Hash) >= sha1.Size {
-		if _, err := encryptedFile.Seek(0, io.SeekStart); err != nil {
-			return err
-		}
-		h := sha1.New()
-		if _, err := io.Copy(h, encryptedFile); err == nil {
-			if bytes.Equal(content.Hash[:sha1.Size], h.Sum(nil)) {
-				if _, err := encryptedFile.Seek(0, io.SeekStart); err != nil {
-					return err
-				}
-				decryptedBuffer.Reset()
-				if _, err := io.Copy(decryptedBuffer, encryptedFile); err != nil {
-					return err
-				}
-				return nil
-			}
-		}
-	}
-
-	if _, err := encryptedFile.Seek(0, io.SeekStart); err != nil {
-		return err
-	}
-
+// decryptContent decrypts a content without a hash tree from src into dst and
+// checks it against the content hash in the TMD.
+func decryptContent(src io.Reader, dst io.Writer, cipherHashTree cipher.Block, content Content) error {
 	var ivContent [aes.BlockSize]byte
 	copy(ivContent[:], content.Index)
 	cipherContent := cipher.NewCBCDecrypter(cipherHashTree, ivContent[:])
@@ -329,7 +255,7 @@ func decryptContentToBuffer(encryptedFile *os.File, decryptedBuffer *bytes.Buffe
 		toReadHash := min(READ_SIZE, leftHash)
 		toReadAligned := alignToAESBlockSize(toRead)
 
-		if _, err := io.ReadFull(encryptedFile, readSizedBuffer[:toReadAligned]); err != nil {
+		if _, err := io.ReadFull(src, readSizedBuffer[:toReadAligned]); err != nil {
 			return err
 		}
 
@@ -337,7 +263,7 @@ func decryptContentToBuffer(encryptedFile *os.File, decryptedBuffer *bytes.Buffe
 		if _, err := contentHash.Write(decBuf[:toReadHash]); err != nil {
 			return err
 		}
-		if _, err = decryptedBuffer.Write(decBuf[:toRead]); err != nil {
+		if _, err := dst.Write(decBuf[:toRead]); err != nil {
 			return err
 		}
 
@@ -349,7 +275,7 @@ func decryptContentToBuffer(encryptedFile *os.File, decryptedBuffer *bytes.Buffe
 	}
 
 	if len(content.Hash) >= sha1.Size && !bytes.Equal(content.Hash[:sha1.Size], contentHash.Sum(nil)) {
-		return errors.New("content hash mismatch")
+		return &HashMismatchError{File: content.CIDStr + ".app", ContentID: content.ID, Hash: "content"}
 	}
 	return nil
 }
@@ -360,23 +286,6 @@ func hashEntryAt(data []byte, index int64) []byte {
 	return data[start:end]
 }
 
-func advanceHashIndices(h0, h1, h2, h3 int64) (int64, int64, int64, int64) {
-	h0++
-	if h0 >= HASH_ENTRIES_PER_LEVEL {
-		h0 = 0
-		h1++
-	}
-	if h1 >= HASH_ENTRIES_PER_LEVEL {
-		h1 = 0
-		h2++
-	}
-	if h2 >= HASH_ENTRIES_PER_LEVEL {
-		h2 = 0
-		h3++
-	}
-	return h0, h1, h2, h3
-}
-
 func alignToAESBlockSize(size uint64) uint64 {
 	mask := uint64(aes.BlockSize - 1)
 	return (size + mask) &^ mask
@@ -1,16 +1,20 @@
 package wiiudownloader
 
 import (
-	"bytes"
+	"context"
 	"crypto/cipher"
+	"crypto/sha1"
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 
 	fstfmt "github.com/Xpl0itU/WiiUDownloader/internal/formats/fst"
+	"golang.org/x/sync/errgroup"
 )
 
 const (
@@ -22,154 +26,366 @@ const (
 	// If unset, entry offsets are scaled by FST factor.
 	FST_CONTENT_FACTOR_FLAG = 0x04
 	FST_HASHED_CONTENT_TYPE = 0x02
+	FST_MAGIC               = "FST\x00"
 )
 
-func extractWiiUContents(path string, tmd *TMD, cipherHashTree cipher.Block, progressReporter ProgressReporter, deleteEncryptedContents bool) error {
-	fstEncFile, err := os.Open(filepath.Join(path, tmd.Contents[0].CIDStr+".app"))
+// fstFileJob is a file entry of the FST with its resolved output path.
+type fstFileJob struct {
+	// entry is the index of the file in the FST.
+	entry uint32
+	name  string
+	// relPath is the slash separated path of the file below the title root.
+	relPath    string
+	targetPath string
+	content    Content
+	contentID  uint16
+	offset     uint64
+	length     uint64
+	// h3 is shared by the jobs of a hashed content and nil for the others.
+	h3 *h3Table
+}
+
+// h3Table reads and checks the H3 table of a hashed content the first time one
+// of its files is extracted. It is not read when the jobs are resolved since
+// the download pipeline resolves them before the .h3 files arrive.
+type h3Table struct {
+	once    sync.Once
+	path    string
+	content Content
+	data    []byte
+	err     error
+}
+
+func (t *h3Table) load() ([]byte, error) {
+	t.once.Do(func() {
+		t.data, t.err = readH3File(t.path, t.content)
+	})
+	return t.data, t.err
+}
+
+func extractWiiUContents(ctx context.Context, path string, tmd *TMD, cipherHashTree cipher.Block, progressReporter ProgressReporter, deleteEncryptedContents bool, workers int, filter PathFilter) error {
+	jobs, ok, err := loadFSTFileJobs(path, tmd, cipherHashTree, filter)
 	if err != nil {
 		return err
 	}
-	defer fstEncFile.Close()
-
-	var decryptedBuffer bytes.Buffer
-	if err := decryptContentToBuffer(fstEncFile, &decryptedBuffer, cipherHashTree, tmd.Contents[0]); err != nil {
+	if !ok {
+		return extractRawWiiUContents(ctx, path, tmd, cipherHashTree, progressReporter, deleteEncryptedContents)
+	}
+	journal, err := openDecryptJournal(path, tmd)
+	if err != nil {
 		return err
 	}
+	err = extractFSTFiles(ctx, path, jobs, cipherHashTree, progressReporter, workers, journal)
+	if finishErr := journal.finish(err == nil); err == nil {
+		err = finishErr
+	}
+	return err
+}
+
+// loadFSTFileJobs decrypts the FST in content 0 and resolves the file entries
+// selected by filter. ok is false when content 0 holds no usable FST, in which
+// case the contents are extracted raw and filter does not apply.
+func loadFSTFileJobs(path string, tmd *TMD, cipherHashTree cipher.Block, filter PathFilter) (jobs []fstFileJob, ok bool, err error) {
+	table, err := readFST(path, tmd, cipherHashTree)
+	if err != nil || table == nil {
+		return nil, false, err
+	}
+	jobs, err = resolveFSTFileJobs(path, tmd, table, filter)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(jobs) == 0 && !filter.IsZero() {
+		return nil, false, ErrNoFilesMatched
+	}
+	return jobs, true, nil
+}
 
-	table, err := fstfmt.Parse(decryptedBuffer.Bytes())
+// readFST decrypts and parses the FST in content 0. The table is nil when
+// content 0 holds no usable FST.
+func readFST(path string, tmd *TMD, cipherHashTree cipher.Block) (*fstfmt.Table, error) {
+	fstReader, err := openContentReader(path, tmd.Contents[0], cipherHashTree)
+	if err != nil {
+		return nil, err
+	}
+	defer fstReader.Close()
+
+	if fstReader.Size() > MAX_FST_SIZE {
+		return nil, fmt.Errorf("FST size %d exceeds maximum limit of %d", fstReader.Size(), MAX_FST_SIZE)
+	}
+	if err := fstReader.Verify(); err != nil {
+		// A wrong title key turns the FST into noise, while a damaged download
+		// still decrypts to a recognisable header.
+		var hashErr *HashMismatchError
+		magic := make([]byte, len(FST_MAGIC))
+		if _, readErr := fstReader.ReadAt(magic, 0); errors.As(err, &hashErr) && (readErr != nil || string(magic) != FST_MAGIC) {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidDecryptionKey, err)
+		}
+		return nil, err
+	}
+	fstData, err := io.ReadAll(io.NewSectionReader(fstReader, 0, fstReader.Size()))
 	if err != nil {
-		return extractRawWiiUContents(path, tmd, cipherHashTree, progressReporter, deleteEncryptedContents)
+		return nil, err
 	}
-	if len(table.Entries) == 0 {
-		return extractRawWiiUContents(path, tmd, cipherHashTree, progressReporter, deleteEncryptedContents)
+	if err := fstReader.Close(); err != nil {
+		return nil, err
 	}
 
+	table, err := fstfmt.Parse(fstData)
+	if err != nil || len(table.Entries) == 0 {
+		return nil, nil
+	}
+	return table, nil
+}
+
+// walkFST calls fn for every entry of the FST after the root, in order, with
+// its index and the names of the directories that contain it.
+func walkFST(table *fstfmt.Table, fn func(index uint32, dirs []string, name string, entry fstfmt.Entry) error) error {
 	entry := make([]uint32, MAX_LEVELS)
+	dirs := make([]string, 0, MAX_LEVELS)
 	level := uint32(0)
 	entriesLen := uint32(len(table.Entries))
 
 	for i := uint32(1); i < entriesLen; i++ {
-		if progressReporter != nil && entriesLen > 1 {
-			progressReporter.UpdateDecryptionProgress(float64(i) / float64(entriesLen-1))
-		}
-
 		if level > 0 {
 			for level >= 1 && table.Entries[entry[level-1]].Length == i {
 				level--
+				dirs = dirs[:level]
 			}
 		}
 
 		currentEntry := table.Entries[i]
+		name, err := table.NameAt(currentEntry.NameOffset & FST_NAME_OFFSET_MASK)
+		if err != nil {
+			if currentEntry.Type&FST_DIRECTORY_TYPE_FLAG != 0 {
+				return fmt.Errorf("failed to read directory name: %w", err)
+			}
+			return fmt.Errorf("failed to read file name: %w", err)
+		}
+		if err := fn(i, dirs, name, currentEntry); err != nil {
+			return err
+		}
 		if currentEntry.Type&FST_DIRECTORY_TYPE_FLAG != 0 {
 			entry[level] = i
+			dirs = append(dirs, name)
 			level++
 			if level >= MAX_LEVELS {
 				return errors.New("level >= MAX_LEVELS")
 			}
+		}
+	}
+	return nil
+}
 
-			// Create the directory immediately to support empty folders
-			currentOutputPath := path
-			for j := uint32(0); j < level; j++ {
-				directory, err := table.NameAt(table.Entries[entry[j]].NameOffset & FST_NAME_OFFSET_MASK)
-				if err != nil {
-					return fmt.Errorf("failed to read directory name: %w", err)
-				}
-				currentOutputPath, err = safeJoinUnderBase(path, currentOutputPath, directory)
-				if err != nil {
-					return err
-				}
-			}
-			if err := os.MkdirAll(currentOutputPath, 0o755); err != nil {
-				return fmt.Errorf("failed to create directory: %w", err)
-			}
+// fstFileOffset returns the offset of the data of a file entry within its
+// content.
+func fstFileOffset(table *fstfmt.Table, entry fstfmt.Entry) uint64 {
+	offset := uint64(entry.Offset)
+	if entry.Flags&FST_CONTENT_FACTOR_FLAG == 0 {
+		offset *= uint64(table.Factor)
+	}
+	return offset
+}
 
-			continue
-		}
+// resolveFSTFileJobs walks the FST in order and returns the files selected by
+// filter. When filter selects all files every directory is created on the way,
+// so that empty directories are kept; the directories of the files themselves
+// are created when they are extracted. When several entries resolve to the same path only the last
+// one is kept, as it would overwrite the others.
+func resolveFSTFileJobs(path string, tmd *TMD, table *fstfmt.Table, filter PathFilter) ([]fstFileJob, error) {
+	var jobs []fstFileJob
+	jobIndex := make(map[string]int)
+	h3Tables := make(map[uint16]*h3Table)
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
 
+	err = walkFST(table, func(index uint32, dirs []string, name string, currentEntry fstfmt.Entry) error {
 		currentOutputPath := path
-		for j := uint32(0); j < level; j++ {
-			directory, err := table.NameAt(table.Entries[entry[j]].NameOffset & FST_NAME_OFFSET_MASK)
+		for _, directory := range dirs {
+			var err error
+			currentOutputPath, err = safeJoinUnderBase(path, currentOutputPath, directory)
 			if err != nil {
-				return fmt.Errorf("failed to read directory name: %w", err)
+				return err
 			}
-			currentOutputPath, err = safeJoinUnderBase(path, currentOutputPath, directory)
+		}
+
+		if currentEntry.Type&FST_DIRECTORY_TYPE_FLAG != 0 {
+			if !filter.IsZero() {
+				return nil
+			}
+			// Create the directory immediately to support empty folders
+			currentOutputPath, err := safeJoinUnderBase(path, currentOutputPath, name)
 			if err != nil {
 				return err
 			}
 			if err := os.MkdirAll(currentOutputPath, 0o755); err != nil {
 				return fmt.Errorf("failed to create directory: %w", err)
 			}
+			return nil
 		}
 
-		fileName, err := table.NameAt(currentEntry.NameOffset & FST_NAME_OFFSET_MASK)
+		targetPath, err := safeJoinUnderBase(path, currentOutputPath, name)
 		if err != nil {
-			return fmt.Errorf("failed to read file name: %w", err)
+			return err
 		}
-		targetPath, err := safeJoinUnderBase(path, currentOutputPath, fileName)
+		relPath, err := filepath.Rel(absPath, targetPath)
 		if err != nil {
 			return err
 		}
-
-		contentOffset := uint64(currentEntry.Offset)
-		if currentEntry.Flags&FST_CONTENT_FACTOR_FLAG == 0 {
-			contentOffset *= uint64(table.Factor)
-		}
-		if currentEntry.Type&FST_SHARED_CONTENT_FLAG != 0 {
-			continue
+		relPath = filepath.ToSlash(relPath)
+		if !filter.Match(relPath) || currentEntry.Type&FST_SHARED_CONTENT_FLAG != 0 {
+			return nil
 		}
 
 		if int(currentEntry.ContentID) >= len(tmd.Contents) {
 			return fmt.Errorf("invalid content index %d", currentEntry.ContentID)
 		}
-		matchingContent := tmd.Contents[currentEntry.ContentID]
-		srcFile, err := os.Open(filepath.Join(path, matchingContent.CIDStr+".app"))
-		if err != nil {
-			return err
+		content := tmd.Contents[currentEntry.ContentID]
+		job := fstFileJob{
+			entry:      index,
+			name:       name,
+			relPath:    relPath,
+			targetPath: targetPath,
+			content:    content,
+			contentID:  currentEntry.ContentID,
+			offset:     fstFileOffset(table, currentEntry),
+			length:     uint64(currentEntry.Length),
+		}
+		if content.Type&FST_HASHED_CONTENT_TYPE != 0 {
+			job.h3 = h3Tables[currentEntry.ContentID]
+			if job.h3 == nil {
+				job.h3 = &h3Table{path: filepath.Join(path, content.CIDStr+".h3"), content: content}
+				h3Tables[currentEntry.ContentID] = job.h3
+			}
 		}
+		if index, ok := jobIndex[targetPath]; ok {
+			jobs[index] = job
+			return nil
+		}
+		jobIndex[targetPath] = len(jobs)
+		jobs = append(jobs, job)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
 
-		if matchingContent.Type&FST_HASHED_CONTENT_TYPE != 0 {
-			err = extractFileHash(srcFile, 0, contentOffset, uint64(currentEntry.Length), targetPath, currentEntry.ContentID, cipherHashTree)
-		} else {
-			err = extractFile(srcFile, 0, contentOffset, uint64(currentEntry.Length), targetPath, currentEntry.ContentID, cipherHashTree)
+// extractFSTFiles extracts the files with up to workers files in parallel,
+// skipping those journal lists as extracted. Progress is the share of files
+// finished, so it only ever grows.
+func extractFSTFiles(ctx context.Context, path string, jobs []fstFileJob, cipherHashTree cipher.Block, progressReporter ProgressReporter, workers int, journal *decryptJournal) error {
+	var progressMutex sync.Mutex
+	finished := 0
+	fileFinished := func() {
+		progressMutex.Lock()
+		defer progressMutex.Unlock()
+		finished++
+		if progressReporter != nil {
+			progressReporter.UpdateDecryptionProgress(float64(finished) / float64(len(jobs)))
 		}
-		closeErr := srcFile.Close()
-		if err != nil {
-			return fmt.Errorf("failed to extract file %s (ID: %d, offset: %d, size: %d): %w", fileName, matchingContent.ID, contentOffset, currentEntry.Length, err)
+	}
+
+	g, groupCtx := errgroup.WithContext(ctx)
+	g.SetLimit(workers)
+	for _, job := range jobs {
+		if groupCtx.Err() != nil {
+			break
 		}
-		if closeErr != nil {
-			return closeErr
+		job := job
+		g.Go(func() error {
+			if !waitUntilResumed(progressReporter) {
+				return ErrCancelled
+			}
+			if err := extractFSTFile(groupCtx, path, job, cipherHashTree, journal); err != nil {
+				return err
+			}
+			fileFinished()
+			return nil
+		})
+	}
+	return g.Wait()
+}
+
+// extractFSTFile extracts the file of job and records it in journal, unless
+// journal shows it was already extracted.
+func extractFSTFile(ctx context.Context, path string, job fstFileJob, cipherHashTree cipher.Block, journal *decryptJournal) error {
+	if journal.extracted(job) {
+		return nil
+	}
+	var h3Data []byte
+	if job.h3 != nil {
+		var err error
+		if h3Data, err = job.h3.load(); err != nil {
+			return err
 		}
 	}
-	return nil
+	if err := os.MkdirAll(filepath.Dir(job.targetPath), 0o755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+	srcFile, err := os.Open(filepath.Join(path, job.content.CIDStr+".app"))
+	if err != nil {
+		return err
+	}
+
+	digest := sha1.New()
+	if job.h3 != nil {
+		err = extractFileHash(ctx, srcFile, 0, job.offset, job.length, job.targetPath, job.content, h3Data, cipherHashTree, digest)
+	} else {
+		err = extractFile(ctx, srcFile, 0, job.offset, job.length, job.targetPath, job.contentID, cipherHashTree, digest)
+	}
+	closeErr := srcFile.Close()
+	if err != nil {
+		return fmt.Errorf("failed to extract file %s (ID: %d, offset: %d, size: %d): %w", job.name, job.content.ID, job.offset, job.length, err)
+	}
+	if closeErr != nil {
+		return closeErr
+	}
+	return journal.record(job, digest.Sum(nil))
 }
 
-func extractRawWiiUContents(path string, tmd *TMD, cipherHashTree cipher.Block, progressReporter ProgressReporter, deleteEncryptedContents bool) error {
+func extractRawWiiUContents(ctx context.Context, path string, tmd *TMD, cipherHashTree cipher.Block, progressReporter ProgressReporter, deleteEncryptedContents bool) error {
 	for i, content := range tmd.Contents {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 		if progressReporter != nil && len(tmd.Contents) > 0 {
 			progressReporter.UpdateDecryptionProgress(float64(i) / float64(len(tmd.Contents)))
 		}
-
-		srcFile, err := os.Open(filepath.Join(path, content.CIDStr+".app"))
-		if err != nil {
+		if err := extractRawWiiUContent(ctx, path, content, cipherHashTree, deleteEncryptedContents); err != nil {
 			return err
 		}
+	}
+	return nil
+}
 
-		targetPath := decryptedWiiContentPath(path, content.CIDStr, deleteEncryptedContents)
-		contentIndex := binary.BigEndian.Uint16(content.Index)
-		if content.Type&FST_HASHED_CONTENT_TYPE != 0 {
-			err = extractFileHash(srcFile, 0, 0, content.Size, targetPath, contentIndex, cipherHashTree)
-		} else {
-			err = extractFile(srcFile, 0, 0, content.Size, targetPath, contentIndex, cipherHashTree)
-		}
-		closeErr := srcFile.Close()
-		if err != nil {
-			return fmt.Errorf("failed to extract raw content %s: %w", content.CIDStr, err)
-		}
-		if closeErr != nil {
-			return closeErr
+func extractRawWiiUContent(ctx context.Context, path string, content Content, cipherHashTree cipher.Block, deleteEncryptedContents bool) error {
+	var h3Data []byte
+	if content.Type&FST_HASHED_CONTENT_TYPE != 0 {
+		var err error
+		if h3Data, err = readH3File(filepath.Join(path, content.CIDStr+".h3"), content); err != nil {
+			return err
 		}
 	}
-	return nil
+	srcFile, err := os.Open(filepath.Join(path, content.CIDStr+".app"))
+	if err != nil {
+		return err
+	}
+
+	targetPath := decryptedWiiContentPath(path, content.CIDStr, deleteEncryptedContents)
+	contentIndex := binary.BigEndian.Uint16(content.Index)
+	if content.Type&FST_HASHED_CONTENT_TYPE != 0 {
+		err = extractFileHash(ctx, srcFile, 0, 0, content.Size, targetPath, content, h3Data, cipherHashTree, nil)
+	} else {
+		err = extractFile(ctx, srcFile, 0, 0, content.Size, targetPath, contentIndex, cipherHashTree, nil)
+	}
+	closeErr := srcFile.Close()
+	if err != nil {
+		return fmt.Errorf("failed to extract raw content %s: %w", content.CIDStr, err)
+	}
+	return closeErr
 }
 
 func safeJoinUnderBase(basePath string, current string, name string) (string, error) {
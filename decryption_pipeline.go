@@ -0,0 +1,279 @@
+package wiiudownloader
+
+import (
+	"context"
+	"crypto/cipher"
+	"fmt"
+	"log"
+	"net/http"
+	"runtime"
+	"sync"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// decryptPipeline holds the extraction steps of a title grouped by the
+// content they read, so that each content can be extracted as soon as it is
+// downloaded.
+type decryptPipeline struct {
+	progressReporter ProgressReporter
+	// journal is set when the steps extract the files of the FST.
+	journal *decryptJournal
+	// steps lists the extraction steps that read each content, by index in
+	// the TMD.
+	steps map[int][]func(ctx context.Context) error
+	// order lists the content indices in the order the steps need them,
+	// followed by the contents no step reads.
+	order []int
+
+	mu            sync.Mutex
+	total         int
+	finished      int
+	downloadsDone bool
+}
+
+// newDecryptPipeline plans the extraction of the files of the title in path
+// selected by filter. For Wii U titles content 0 must already be downloaded,
+// as the FST decides which files each content holds. With resumable, files
+// extracted by an earlier run are skipped and the pipeline keeps a journal,
+// which the caller has to finish.
+func newDecryptPipeline(path string, tmd *TMD, progressReporter ProgressReporter, deleteEncryptedContents bool, filter PathFilter, resumable bool) (*decryptPipeline, error) {
+	// Contents are downloaded with upper case names.
+	titleTMD := *tmd
+	titleTMD.Contents = append([]Content(nil), tmd.Contents...)
+	for i := range titleTMD.Contents {
+		titleTMD.Contents[i].CIDStr = fmt.Sprintf("%08X", titleTMD.Contents[i].ID)
+	}
+	cipherHashTree, err := titleKeyCipher(path, &titleTMD)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &decryptPipeline{
+		progressReporter: progressReporter,
+		steps:            make(map[int][]func(ctx context.Context) error),
+	}
+	if titleTMD.Version == TMD_VERSION_WIIU {
+		jobs, ok, err := loadFSTFileJobs(path, &titleTMD, cipherHashTree, filter)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			if resumable {
+				if p.journal, err = openDecryptJournal(path, &titleTMD); err != nil {
+					return nil, err
+				}
+			}
+			for _, job := range jobs {
+				job := job
+				p.add(int(job.contentID), func(ctx context.Context) error {
+					return extractFSTFile(ctx, path, job, cipherHashTree, p.journal)
+				})
+			}
+		} else {
+			p.addContents(titleTMD.Contents, func(ctx context.Context, index int, content Content) error {
+				return extractRawWiiUContent(ctx, path, content, cipherHashTree, deleteEncryptedContents)
+			})
+		}
+	} else {
+		p.addContents(titleTMD.Contents, func(ctx context.Context, index int, content Content) error {
+			return extractWiiContent(path, index, content, cipherHashTree, deleteEncryptedContents)
+		})
+	}
+
+	for i := range titleTMD.Contents {
+		if _, ok := p.steps[i]; !ok {
+			p.order = append(p.order, i)
+		}
+	}
+	return p, nil
+}
+
+// contentIndices returns the contents to download in the order the steps
+// need them. Unless matchingOnly is set, the contents no step reads follow.
+func (p *decryptPipeline) contentIndices(matchingOnly bool) []int {
+	var indices []int
+	for _, index := range p.order {
+		if _, ok := p.steps[index]; ok || !matchingOnly {
+			indices = append(indices, index)
+		}
+	}
+	return indices
+}
+
+func (p *decryptPipeline) add(index int, step func(ctx context.Context) error) {
+	if _, ok := p.steps[index]; !ok {
+		p.order = append(p.order, index)
+	}
+	p.steps[index] = append(p.steps[index], step)
+	p.total++
+}
+
+func (p *decryptPipeline) addContents(contents []Content, extract func(ctx context.Context, index int, content Content) error) {
+	for i, content := range contents {
+		i, content := i, content
+		p.add(i, func(ctx context.Context) error {
+			return extract(ctx, i, content)
+		})
+	}
+}
+
+// Decryption progress is only reported once every content is downloaded, so
+// that it does not take over the download progress.
+func (p *decryptPipeline) stepFinished() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.finished++
+	p.reportLocked()
+}
+
+func (p *decryptPipeline) finishDownloads() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.downloadsDone = true
+	p.reportLocked()
+}
+
+func (p *decryptPipeline) reportLocked() {
+	if p.progressReporter != nil && p.downloadsDone && p.total > 0 {
+		p.progressReporter.UpdateDecryptionProgress(float64(p.finished) / float64(p.total))
+	}
+}
+
+// downloadAndDecryptContents downloads the contents of a title with up to
+// concurrency contents in parallel and extracts the files of each content as
+// soon as it and its .h3 file are complete. Content 0 is downloaded first and
+// the others in the order their files appear in the FST. With matchingOnly,
+// contents that hold none of the files selected by the filter are skipped.
+// cipherHashTree is passed on to downloadContentFiles.
+func downloadAndDecryptContents(ctx context.Context, progressReporter ProgressReporter, client *http.Client, titleIDStr, outputDir string, tmd *TMD, cipherHashTree cipher.Block, concurrency int, contentOpts downloadOptions, decryptOpts DecryptContentsOptions, matchingOnly bool) error {
+	workers := decryptOpts.Workers
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stopMonitor := monitorCancellation(ctx, cancel, progressReporter)
+	defer stopMonitor()
+
+	var errMutex sync.Mutex
+	var firstErr error
+	fail := func(err error) {
+		errMutex.Lock()
+		defer errMutex.Unlock()
+		if firstErr == nil {
+			firstErr = err
+			cancel()
+		}
+	}
+
+	download := func(content Content) error {
+		if !waitUntilResumed(progressReporter) {
+			return ErrCancelled
+		}
+		if err := downloadContentFiles(ctx, progressReporter, client, titleIDStr, outputDir, content, cipherHashTree, contentOpts); err != nil {
+			return err
+		}
+		if isCancelled(progressReporter) {
+			return ErrCancelled
+		}
+		return nil
+	}
+
+	if err := download(tmd.Contents[0]); err != nil {
+		return err
+	}
+	deleteEncryptedContents := decryptOpts.DeleteEncryptedContents && decryptOpts.Filter.IsZero()
+	pipeline, err := newDecryptPipeline(outputDir, tmd, progressReporter, deleteEncryptedContents, decryptOpts.Filter, true)
+	if err != nil {
+		return fmt.Errorf("decryption error: %w", wrapDiskFull(err))
+	}
+	indices := pipeline.contentIndices(matchingOnly)
+	if matchingOnly && progressReporter != nil {
+		progressReporter.SetDownloadSize(contentsDownloadSize(tmd, indices))
+	}
+
+	var extractGroup errgroup.Group
+	extractGroup.SetLimit(workers)
+	ready := make(chan int, len(tmd.Contents))
+	dispatched := make(chan struct{})
+	go func() {
+		defer close(dispatched)
+		for index := range ready {
+			for _, step := range pipeline.steps[index] {
+				step := step
+				extractGroup.Go(func() error {
+					if !waitUntilResumed(progressReporter) {
+						fail(ErrCancelled)
+						return nil
+					}
+					if ctx.Err() != nil {
+						return nil
+					}
+					if err := step(ctx); err != nil {
+						fail(fmt.Errorf("decryption error: %w", wrapDiskFull(err)))
+						return nil
+					}
+					pipeline.stepFinished()
+					return nil
+				})
+			}
+		}
+	}()
+	ready <- 0
+
+	var downloadGroup errgroup.Group
+	downloadGroup.SetLimit(concurrency)
+	for _, index := range indices {
+		if index == 0 {
+			continue
+		}
+		index := index
+		downloadGroup.Go(func() error {
+			if ctx.Err() != nil {
+				return nil
+			}
+			if err := download(tmd.Contents[index]); err != nil {
+				fail(err)
+				return nil
+			}
+			ready <- index
+			return nil
+		})
+	}
+	downloadGroup.Wait()
+	pipeline.finishDownloads()
+	close(ready)
+	<-dispatched
+	extractGroup.Wait()
+
+	if firstErr == nil && ctx.Err() != nil {
+		firstErr = ErrCancelled
+	}
+	if err := pipeline.journal.finish(firstErr == nil); err != nil && firstErr == nil {
+		firstErr = fmt.Errorf("decryption error: %w", wrapDiskFull(err))
+	}
+	if firstErr != nil {
+		return firstErr
+	}
+	if progressReporter != nil {
+		progressReporter.UpdateDecryptionProgress(1.0)
+	}
+	if deleteEncryptedContents {
+		if err := doDeleteEncryptedContents(outputDir); err != nil {
+			log.Printf("failed to remove encrypted contents in %q: %v", outputDir, err)
+		}
+	}
+	return nil
+}
+
+// contentsDownloadSize returns the number of bytes downloaded for the given
+// contents of tmd, including their .h3 files.
+func contentsDownloadSize(tmd *TMD, indices []int) int64 {
+	var size int64
+	for _, index := range indices {
+		size += expectedContentDownloadSize(tmd.Contents[index]) + expectedH3DownloadSize(tmd.Contents[index])
+	}
+	return size
+}
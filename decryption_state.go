@@ -0,0 +1,208 @@
+package wiiudownloader
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	decryptStateFile    = "decrypt.resume.json"
+	decryptJournalMagic = "WUDDECRYPT1"
+)
+
+var decryptJournalHeader = []byte(decryptJournalMagic + "\n")
+
+// decryptStateTitle identifies the title a decryption journal belongs to, so
+// that a journal left behind by another version of the title is discarded.
+type decryptStateTitle struct {
+	TitleID      uint64 `json:"title_id"`
+	TitleVersion uint16 `json:"title_version"`
+	FSTHash      string `json:"fst_hash"`
+}
+
+// decryptedFile records a file of the FST that was extracted completely.
+type decryptedFile struct {
+	Entry uint32 `json:"entry"`
+	Path  string `json:"path"`
+	Size  uint64 `json:"size"`
+	SHA1  string `json:"sha1"`
+}
+
+// decryptJournal lists the files an interrupted decryption already extracted,
+// so that running it again only extracts the others. It lives in the title
+// folder as a header line followed by one JSON line per extracted file, and is
+// removed once the title is decrypted. A file is only skipped when it still
+// has the recorded size and hash, so lines written just before a crash that
+// did not reach the disk cannot hide a truncated file.
+type decryptJournal struct {
+	path string
+
+	mu   sync.Mutex
+	file *os.File
+	done map[uint32]decryptedFile
+}
+
+// openDecryptJournal loads the journal of the title folder in path, or starts
+// a new one when there is none or it belongs to another title.
+func openDecryptJournal(path string, tmd *TMD) (*decryptJournal, error) {
+	title := decryptStateTitle{
+		TitleID:      tmd.TitleID,
+		TitleVersion: tmd.TitleVersion,
+		FSTHash:      hex.EncodeToString(tmd.Contents[0].Hash),
+	}
+	journal := &decryptJournal{
+		path: filepath.Join(path, decryptStateFile),
+		done: make(map[uint32]decryptedFile),
+	}
+
+	data, err := os.ReadFile(journal.path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if end, ok := loadDecryptJournal(data, title, journal.done); ok {
+		journal.file, err = os.OpenFile(journal.path, os.O_APPEND|os.O_WRONLY, downloadFilePerm)
+		if err != nil {
+			return nil, err
+		}
+		// Drop a line torn by a crash, so that the records appended after it
+		// can be read by the next run.
+		if end < int64(len(data)) {
+			if err := journal.file.Truncate(end); err != nil {
+				journal.file.Close()
+				return nil, err
+			}
+		}
+		return journal, nil
+	}
+
+	titleLine, err := json.Marshal(title)
+	if err != nil {
+		return nil, err
+	}
+	journal.file, err = os.OpenFile(journal.path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, downloadFilePerm)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := journal.file.Write(append(append(decryptJournalHeader, titleLine...), '\n')); err != nil {
+		journal.file.Close()
+		return nil, err
+	}
+	return journal, nil
+}
+
+// loadDecryptJournal fills done from data and reports whether data is a
+// journal of title, and the offset just past the last line that parses.
+// Reading stops at the first line that does not parse or is not terminated,
+// such as one torn by a crash.
+func loadDecryptJournal(data []byte, title decryptStateTitle, done map[uint32]decryptedFile) (int64, bool) {
+	if !bytes.HasPrefix(data, decryptJournalHeader) {
+		return 0, false
+	}
+	end := len(decryptJournalHeader)
+	line, ok := nextJournalLine(data, end)
+	var journalTitle decryptStateTitle
+	if !ok || json.Unmarshal(line, &journalTitle) != nil || journalTitle != title {
+		return 0, false
+	}
+	end += len(line) + 1
+	for {
+		line, ok := nextJournalLine(data, end)
+		if !ok {
+			break
+		}
+		var file decryptedFile
+		if err := json.Unmarshal(line, &file); err != nil {
+			break
+		}
+		done[file.Entry] = file
+		end += len(line) + 1
+	}
+	return int64(end), true
+}
+
+// nextJournalLine returns the line of data starting at offset, without its
+// newline. ok is false when the line is not terminated by one.
+func nextJournalLine(data []byte, offset int) (line []byte, ok bool) {
+	i := bytes.IndexByte(data[offset:], '\n')
+	if i < 0 {
+		return nil, false
+	}
+	return data[offset : offset+i], true
+}
+
+// extracted reports whether job was extracted by an earlier run and its file
+// is still intact.
+func (j *decryptJournal) extracted(job fstFileJob) bool {
+	if j == nil {
+		return false
+	}
+	j.mu.Lock()
+	file, ok := j.done[job.entry]
+	j.mu.Unlock()
+	if !ok || file.Path != job.relPath || file.Size != job.length {
+		return false
+	}
+
+	info, err := os.Stat(job.targetPath)
+	if err != nil || !info.Mode().IsRegular() || uint64(info.Size()) != job.length {
+		return false
+	}
+	sum, err := hashFileSHA1(job.targetPath)
+	return err == nil && sum == file.SHA1
+}
+
+// record adds job, whose file has the SHA-1 hash sum, to the journal.
+func (j *decryptJournal) record(job fstFileJob, sum []byte) error {
+	if j == nil {
+		return nil
+	}
+	file := decryptedFile{Entry: job.entry, Path: job.relPath, Size: job.length, SHA1: hex.EncodeToString(sum)}
+	line, err := json.Marshal(file)
+	if err != nil {
+		return err
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if _, err := j.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to update %s: %w", decryptStateFile, err)
+	}
+	j.done[file.Entry] = file
+	return nil
+}
+
+// finish closes the journal and removes it when the decryption succeeded.
+func (j *decryptJournal) finish(succeeded bool) error {
+	if j == nil {
+		return nil
+	}
+	err := j.file.Close()
+	if succeeded {
+		if removeErr := os.Remove(j.path); removeErr != nil && !os.IsNotExist(removeErr) {
+			return removeErr
+		}
+	}
+	return err
+}
+
+func hashFileSHA1(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha1.New()
+	if _, err := io.Copy(hash, bufio.NewReaderSize(file, BLOCK_SIZE_HASHED)); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,61 @@
+package wiiudownloader
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDecryptJournalTornLine(t *testing.T) {
+	dir := t.TempDir()
+	tmd := &TMD{TitleID: testTitleID, TitleVersion: 32, Contents: []Content{{Hash: make([]byte, 32)}}}
+	record := func(journal *decryptJournal, entry uint32) {
+		t.Helper()
+		job := fstFileJob{entry: entry, relPath: "content/file.bin", length: uint64(entry)}
+		if err := journal.record(job, make([]byte, 20)); err != nil {
+			t.Fatalf("record: %v", err)
+		}
+	}
+
+	journal, err := openDecryptJournal(dir, tmd)
+	if err != nil {
+		t.Fatalf("openDecryptJournal: %v", err)
+	}
+	record(journal, 1)
+	if err := journal.finish(false); err != nil {
+		t.Fatal(err)
+	}
+	// A crash while the next record was written leaves part of its line.
+	file, err := os.OpenFile(filepath.Join(dir, decryptStateFile), os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.WriteString(`{"entry":2,"pa`); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	journal, err = openDecryptJournal(dir, tmd)
+	if err != nil {
+		t.Fatalf("openDecryptJournal after the crash: %v", err)
+	}
+	if len(journal.done) != 1 {
+		t.Fatalf("journal after the crash lists %d files, want 1", len(journal.done))
+	}
+	record(journal, 2)
+	record(journal, 3)
+	if err := journal.finish(false); err != nil {
+		t.Fatal(err)
+	}
+
+	journal, err = openDecryptJournal(dir, tmd)
+	if err != nil {
+		t.Fatalf("openDecryptJournal after the resumed run: %v", err)
+	}
+	defer journal.finish(false)
+	for _, entry := range []uint32{1, 2, 3} {
+		if _, ok := journal.done[entry]; !ok {
+			t.Errorf("journal after the resumed run lost entry %d", entry)
+		}
+	}
+}
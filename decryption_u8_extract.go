@@ -1,10 +1,11 @@
 package wiiudownloader
 
 import (
-	"bytes"
+	"context"
 	"crypto/cipher"
 	"encoding/binary"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 
@@ -18,42 +19,93 @@ const (
 	U8_MAGIC_OFFSET_SIZE = 4
 )
 
-func extractWiiContents(path string, tmd *TMD, cipherHashTree cipher.Block, progressReporter ProgressReporter, deleteEncryptedContents bool) error {
+func extractWiiContents(ctx context.Context, path string, tmd *TMD, cipherHashTree cipher.Block, progressReporter ProgressReporter, deleteEncryptedContents bool) error {
 	for i, content := range tmd.Contents {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 		if progressReporter != nil && len(tmd.Contents) > 0 {
 			progressReporter.UpdateDecryptionProgress(float64(i) / float64(len(tmd.Contents)))
 		}
-
-		srcFile, err := os.Open(filepath.Join(path, content.CIDStr+".app"))
-		if err != nil {
+		if err := extractWiiContent(path, i, content, cipherHashTree, deleteEncryptedContents); err != nil {
 			return err
 		}
+	}
+	return nil
+}
 
-		decryptedBuffer := bytes.Buffer{}
-		if err := decryptContentToBuffer(srcFile, &decryptedBuffer, cipherHashTree, content); err != nil {
-			srcFile.Close()
-			return err
-		}
-		if err := srcFile.Close(); err != nil {
-			return err
-		}
+// extractWiiContent extracts every U8 archive found in a content, or writes
+// the decrypted content next to the encrypted one when it holds none.
+func extractWiiContent(path string, index int, content Content, cipherHashTree cipher.Block, deleteEncryptedContents bool) error {
+	reader, err := openContentReader(path, content, cipherHashTree)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	if err := reader.Verify(); err != nil {
+		return err
+	}
 
-		decData := decryptedBuffer.Bytes()
-		foundU8 := false
-		extractCount := 0
+	foundU8, err := extractU8Archives(reader, path, index, content)
+	if err != nil || foundU8 {
+		return err
+	}
 
-		for pos := 0; pos < len(decData)-U8_HEADER_PROBE_SIZE; pos += U8_ALIGNMENT_STEP {
-			if binary.BigEndian.Uint32(decData[pos:pos+U8_MAGIC_OFFSET_SIZE]) != u8fmt.Magic {
+	// The decrypted content may replace the encrypted file it is read from,
+	// so it is written to a temporary file first.
+	outputPath := decryptedWiiContentPath(path, content.CIDStr, deleteEncryptedContents)
+	tmp, err := os.CreateTemp(path, content.CIDStr+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := tmp.Chmod(0o644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := io.Copy(tmp, io.NewSectionReader(reader, 0, reader.Size())); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := reader.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), outputPath)
+}
+
+// extractU8Archives probes the aligned positions of a decrypted content for
+// U8 archives and extracts each one it can parse.
+func extractU8Archives(reader *contentReader, path string, index int, content Content) (bool, error) {
+	foundU8 := false
+	extractCount := 0
+	size := reader.Size()
+	chunk := make([]byte, contentReaderChunkSize)
+
+	for chunkStart := int64(0); chunkStart < size-U8_HEADER_PROBE_SIZE; chunkStart += int64(len(chunk)) {
+		n, err := reader.ReadAt(chunk, chunkStart)
+		if err != nil && err != io.EOF {
+			return foundU8, err
+		}
+		for within := 0; within+U8_MAGIC_OFFSET_SIZE <= n; within += U8_ALIGNMENT_STEP {
+			pos := chunkStart + int64(within)
+			if pos >= size-U8_HEADER_PROBE_SIZE {
+				break
+			}
+			if binary.BigEndian.Uint32(chunk[within:within+U8_MAGIC_OFFSET_SIZE]) != u8fmt.Magic {
 				continue
 			}
-			if _, err := u8fmt.Parse(decData[pos:]); err != nil {
+			archive := io.NewSectionReader(reader, pos, size-pos)
+			if _, err := u8fmt.Parse(archive, archive.Size()); err != nil {
 				continue
 			}
 
 			foundU8 = true
 			var outPath string
 			switch {
-			case extractCount == 0 && i == 0:
+			case extractCount == 0 && index == 0:
 				outPath = path
 			case extractCount == 0:
 				outPath = filepath.Join(path, content.CIDStr)
@@ -61,19 +113,12 @@ func extractWiiContents(path string, tmd *TMD, cipherHashTree cipher.Block, prog
 				outPath = filepath.Join(path, content.CIDStr, fmt.Sprintf("u8_%X", pos))
 			}
 
-			if err := u8fmt.Extract(decData[pos:], outPath); err == nil {
+			if err := u8fmt.Extract(archive, archive.Size(), outPath); err == nil {
 				extractCount++
 			}
 		}
-
-		if !foundU8 {
-			outputPath := decryptedWiiContentPath(path, content.CIDStr, deleteEncryptedContents)
-			if err := os.WriteFile(outputPath, decData, 0o644); err != nil {
-				return err
-			}
-		}
 	}
-	return nil
+	return foundU8, nil
 }
 
 func decryptedWiiContentPath(path string, cid string, deleteEncryptedContents bool) string {
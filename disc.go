@@ -0,0 +1,498 @@
+package wiiudownloader
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	fstfmt "github.com/Xpl0itU/WiiUDownloader/internal/formats/fst"
+	ticketfmt "github.com/Xpl0itU/WiiUDownloader/internal/formats/ticket"
+	"github.com/Xpl0itU/WiiUDownloader/internal/formats/wud"
+)
+
+// Layout of the encrypted area of a Wii U disc, which starts with the
+// partition table.
+const (
+	discPartitionTableOffset   = 0x18000
+	discPartitionTableMagic    = 0xCCA6E67B
+	discPartitionCountOffset   = 0x1C
+	discPartitionEntriesOffset = 0x800
+	discPartitionEntrySize     = 0x80
+	discPartitionNameSize      = 0x19
+	discPartitionSectorOffset  = 0x20
+	discPartitionHeaderMagic   = 0xCC93A4F5
+	discHeaderSizeOffset       = 0x04
+	discMaxHeaderSize          = 0x100000
+	discH3CountOffset          = 0x10
+	discH3TableOffset          = 0x40
+	// Files of the SI partition are encrypted in chunks of this size, each
+	// with its own IV.
+	discFileChunkSize = 0x10000
+	discKeySize       = 16
+	discSIPartition   = "SI"
+)
+
+// DiscImageOptions configures ImportDiscImage and ExtractDiscImage.
+type DiscImageOptions struct {
+	// DiscKey is the key of the disc, which is dumped together with the
+	// image, usually as game.key.
+	DiscKey []byte
+	// ProgressReporter may be nil.
+	ProgressReporter ProgressReporter
+}
+
+// DiscTitle describes a title imported from a game partition of a disc image.
+type DiscTitle struct {
+	Partition    string
+	Folder       string
+	TitleID      uint64
+	TitleVersion uint16
+}
+
+type discPartition struct {
+	name   string
+	offset int64
+}
+
+type discImage struct {
+	file       *os.File
+	image      *wud.Image
+	key        cipher.Block
+	partitions []discPartition
+}
+
+// discGameTitle is a game partition with the TMD, ticket and certificates the
+// SI partition holds for it.
+type discGameTitle struct {
+	partition discPartition
+	tmdData   []byte
+	ticket    []byte
+	cert      []byte
+}
+
+// ParseDiscKey parses a disc key given as 32 hexadecimal digits.
+func ParseDiscKey(s string) ([]byte, error) {
+	key, err := hex.DecodeString(strings.TrimSpace(s))
+	if err != nil || len(key) != discKeySize {
+		return nil, fmt.Errorf("disc key must be %d hexadecimal digits", discKeySize*2)
+	}
+	return key, nil
+}
+
+// ImportDiscImage copies the game partitions of the .wud or .wux image at
+// imagePath into encrypted title folders in dest, one per partition named
+// after its title ID, holding the same files as a download from the CDN:
+// title.tmd, title.tik, title.cert and the .app and .h3 files of every
+// content. The partitions are decrypted with the title keys of their tickets
+// like downloaded titles, so the folders can be verified, decrypted or packed
+// with the rest of the library. A folder must not hold a title yet.
+// ErrCancelled is returned when ctx or the progress reporter is cancelled.
+func ImportDiscImage(ctx context.Context, imagePath, dest string, opts DiscImageOptions) (titles []DiscTitle, err error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	progressReporter := opts.ProgressReporter
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stopMonitor := monitorCancellation(ctx, cancel, progressReporter)
+	defer stopMonitor()
+	defer func() {
+		if err != nil && (isCancelled(progressReporter) || ctx.Err() != nil) {
+			err = ErrCancelled
+		}
+		if err != nil && err != ErrCancelled {
+			err = fmt.Errorf("disc import error: %w", wrapDiskFull(err))
+		}
+	}()
+
+	disc, err := openDiscImage(imagePath, opts.DiscKey)
+	if err != nil {
+		return nil, err
+	}
+	defer disc.file.Close()
+	games, err := disc.gameTitles()
+	if err != nil {
+		return nil, err
+	}
+
+	progress := &byteProgress{reporter: progressReporter}
+	tmds := make([]*TMD, len(games))
+	for i, game := range games {
+		if tmds[i], err = ParseTMD(game.tmdData); err != nil {
+			return nil, fmt.Errorf("%s: %w", game.partition.name, err)
+		}
+		if tmds[i].Version != TMD_VERSION_WIIU || len(tmds[i].Contents) == 0 {
+			return nil, fmt.Errorf("%s: not a Wii U title", game.partition.name)
+		}
+		progress.total += uint64(tmds[i].CalculateTotalSize())
+	}
+	for i, game := range games {
+		folder := filepath.Join(dest, fmt.Sprintf("%016x", tmds[i].TitleID))
+		if err := disc.importGame(ctx, game, tmds[i], folder, progress); err != nil {
+			return nil, fmt.Errorf("%s: %w", game.partition.name, err)
+		}
+		titles = append(titles, DiscTitle{
+			Partition:    game.partition.name,
+			Folder:       folder,
+			TitleID:      tmds[i].TitleID,
+			TitleVersion: tmds[i].TitleVersion,
+		})
+	}
+	if progressReporter != nil {
+		progressReporter.UpdateDecryptionProgress(1.0)
+	}
+	return titles, nil
+}
+
+// ExtractDiscImage imports the game partitions of a disc image like
+// ImportDiscImage and decrypts every imported folder, removing the encrypted
+// files, so that dest holds the same code, content and meta folders per title
+// as DecryptContents leaves in a downloaded title folder.
+func ExtractDiscImage(ctx context.Context, imagePath, dest string, opts DiscImageOptions) ([]DiscTitle, error) {
+	titles, err := ImportDiscImage(ctx, imagePath, dest, opts)
+	if err != nil {
+		return nil, err
+	}
+	for _, title := range titles {
+		if err := DecryptContentsWithOptions(ctx, title.Folder, DecryptContentsOptions{
+			ProgressReporter:        opts.ProgressReporter,
+			DeleteEncryptedContents: true,
+		}); err != nil {
+			return nil, err
+		}
+	}
+	return titles, nil
+}
+
+// openDiscImage opens a disc image and reads its partition table, which only
+// decrypts with the right disc key.
+func openDiscImage(path string, discKey []byte) (*discImage, error) {
+	if len(discKey) != discKeySize {
+		return nil, fmt.Errorf("disc key must be %d bytes", discKeySize)
+	}
+	key, err := aes.NewCipher(discKey)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	image, err := wud.Open(file, info.Size())
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	disc := &discImage{file: file, image: image, key: key}
+	if err := disc.readPartitionTable(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return disc, nil
+}
+
+func (d *discImage) readPartitionTable() error {
+	table, err := d.readDecrypted(discPartitionTableOffset, wud.SectorSize)
+	if err != nil {
+		return err
+	}
+	if binary.BigEndian.Uint32(table) != discPartitionTableMagic {
+		return fmt.Errorf("%w: the partition table does not decrypt", ErrInvalidDiscKey)
+	}
+	count := int(binary.BigEndian.Uint32(table[discPartitionCountOffset:]))
+	if count > (len(table)-discPartitionEntriesOffset)/discPartitionEntrySize {
+		return fmt.Errorf("invalid partition count %d", count)
+	}
+	for i := 0; i < count; i++ {
+		entry := table[discPartitionEntriesOffset+i*discPartitionEntrySize:]
+		name, _, _ := strings.Cut(string(entry[:discPartitionNameSize]), "\x00")
+		sector := int64(binary.BigEndian.Uint32(entry[discPartitionSectorOffset:]))
+		if (sector+1)*wud.SectorSize > d.image.Size() {
+			return fmt.Errorf("partition %s is out of bounds", name)
+		}
+		d.partitions = append(d.partitions, discPartition{name: name, offset: sector * wud.SectorSize})
+	}
+	return nil
+}
+
+func (d *discImage) partition(name string) (discPartition, bool) {
+	i := slices.IndexFunc(d.partitions, func(p discPartition) bool { return strings.EqualFold(p.name, name) })
+	if i < 0 {
+		return discPartition{}, false
+	}
+	return d.partitions[i], true
+}
+
+// readDecrypted reads size bytes at offset encrypted with the disc key and a
+// zero IV, as the partition table and the FST of the SI partition are.
+func (d *discImage) readDecrypted(offset int64, size int) ([]byte, error) {
+	data := make([]byte, size)
+	if _, err := d.image.ReadAt(data, offset); err != nil {
+		return nil, err
+	}
+	cipher.NewCBCDecrypter(d.key, make([]byte, aes.BlockSize)).CryptBlocks(data, data)
+	return data, nil
+}
+
+// readSIFile reads a file of the SI partition from the content starting at
+// contentOffset. Contents there are encrypted with the disc key in chunks
+// whose IV holds the chunk number.
+func (d *discImage) readSIFile(contentOffset int64, fileOffset uint64, size uint32) ([]byte, error) {
+	out := make([]byte, 0, size)
+	chunk := make([]byte, discFileChunkSize)
+	iv := make([]byte, aes.BlockSize)
+	for uint64(len(out)) < uint64(size) {
+		offset := fileOffset + uint64(len(out))
+		number, within := offset/discFileChunkSize, offset%discFileChunkSize
+		if _, err := d.image.ReadAt(chunk, contentOffset+int64(number)*discFileChunkSize); err != nil {
+			return nil, err
+		}
+		binary.BigEndian.PutUint64(iv[8:], number)
+		cipher.NewCBCDecrypter(d.key, iv).CryptBlocks(chunk, chunk)
+		out = append(out, chunk[within:min(uint64(discFileChunkSize), within+uint64(size)-uint64(len(out)))]...)
+	}
+	return out, nil
+}
+
+// gameTitles reads the SI partition, which holds a folder with the TMD,
+// ticket and certificates of every game partition.
+func (d *discImage) gameTitles() ([]discGameTitle, error) {
+	si, ok := d.partition(discSIPartition)
+	if !ok {
+		return nil, errors.New("disc has no SI partition")
+	}
+	_, dataOffset, err := d.readPartitionHeader(si)
+	if err != nil {
+		return nil, fmt.Errorf("SI partition: %w", err)
+	}
+	fstData, err := d.readDecrypted(dataOffset, wud.SectorSize)
+	if err != nil {
+		return nil, err
+	}
+	if string(fstData[:len(FST_MAGIC)]) != FST_MAGIC {
+		return nil, errors.New("SI partition has no FST")
+	}
+	table, err := fstfmt.Parse(fstData)
+	if err != nil {
+		return nil, fmt.Errorf("SI partition: %w", err)
+	}
+	if err := checkDiscFST(table); err != nil {
+		return nil, fmt.Errorf("SI partition: %w", err)
+	}
+
+	files := make(map[string]map[string][]byte)
+	var folders []string
+	err = walkFST(table, func(index uint32, dirs []string, name string, entry fstfmt.Entry) error {
+		if entry.Type&FST_DIRECTORY_TYPE_FLAG != 0 || len(dirs) != 1 {
+			return nil
+		}
+		switch name {
+		case "title.tmd", "title.tik", "title.cert":
+		default:
+			return nil
+		}
+		if int(entry.ContentID) >= len(table.Clusters) {
+			return fmt.Errorf("invalid content index %d", entry.ContentID)
+		}
+		contentOffset := dataOffset + int64(table.Clusters[entry.ContentID].Offset)*wud.SectorSize
+		data, err := d.readSIFile(contentOffset, fstFileOffset(table, entry), entry.Length)
+		if err != nil {
+			return err
+		}
+		if files[dirs[0]] == nil {
+			files[dirs[0]] = make(map[string][]byte)
+			folders = append(folders, dirs[0])
+		}
+		files[dirs[0]][name] = data
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("SI partition: %w", err)
+	}
+
+	var games []discGameTitle
+	for _, folder := range folders {
+		game := discGameTitle{
+			tmdData: files[folder]["title.tmd"],
+			ticket:  files[folder]["title.tik"],
+			cert:    files[folder]["title.cert"],
+		}
+		if game.tmdData == nil || game.ticket == nil {
+			return nil, fmt.Errorf("SI partition: %s lacks a TMD or ticket", folder)
+		}
+		ticket, err := ticketfmt.Parse(game.ticket)
+		if err != nil {
+			return nil, fmt.Errorf("SI partition: %s: %w", folder, err)
+		}
+		// Game partitions are named after the title ID in their ticket.
+		name := fmt.Sprintf("GM%016X", ticket.TitleID)
+		if game.partition, ok = d.partition(name); !ok {
+			return nil, fmt.Errorf("disc has no partition %s", name)
+		}
+		games = append(games, game)
+	}
+	if len(games) == 0 {
+		return nil, errors.New("disc has no game partitions")
+	}
+	return games, nil
+}
+
+// readPartitionHeader reads the header that starts a partition and returns it
+// with the offset of the data after it. The data starts with content 0, which
+// holds the FST, and the clusters of the FST give the sector of every content
+// counted from there.
+func (d *discImage) readPartitionHeader(partition discPartition) ([]byte, int64, error) {
+	header := make([]byte, discH3TableOffset)
+	if _, err := d.image.ReadAt(header, partition.offset); err != nil {
+		return nil, 0, err
+	}
+	if binary.BigEndian.Uint32(header) != discPartitionHeaderMagic {
+		return nil, 0, errors.New("invalid partition header")
+	}
+	size := int64(binary.BigEndian.Uint32(header[discHeaderSizeOffset:]))
+	if size < discH3TableOffset || size > discMaxHeaderSize || partition.offset+size > d.image.Size() {
+		return nil, 0, fmt.Errorf("invalid partition header size %#x", size)
+	}
+	header = make([]byte, size)
+	if _, err := d.image.ReadAt(header, partition.offset); err != nil {
+		return nil, 0, err
+	}
+	return header, partition.offset + size, nil
+}
+
+// checkDiscFST checks that the first cluster of an FST read from the start of
+// the partition data points there.
+func checkDiscFST(table *fstfmt.Table) error {
+	if len(table.Clusters) == 0 || table.Clusters[0].Offset != 0 {
+		return errors.New("the FST is not where its first cluster points")
+	}
+	return nil
+}
+
+// importGame writes the files of a game partition into folder. The partition
+// header holds the H3 hashes and is followed by content 0 with the FST, whose
+// clusters give the sector of every content.
+func (d *discImage) importGame(ctx context.Context, game discGameTitle, tmd *TMD, folder string, progress *byteProgress) error {
+	if _, err := os.Stat(filepath.Join(folder, "title.tmd")); err == nil {
+		return fmt.Errorf("%s already holds a title", folder)
+	}
+	header, dataOffset, err := d.readPartitionHeader(game.partition)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(folder, 0o755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(folder, "title.tik"), game.ticket, downloadFilePerm); err != nil {
+		return err
+	}
+	if game.cert != nil {
+		if err := os.WriteFile(filepath.Join(folder, "title.cert"), game.cert, downloadFilePerm); err != nil {
+			return err
+		}
+	}
+
+	// The H3 hashes of the contents with a hash tree follow each other in
+	// the order of the contents.
+	h3Offset := discH3TableOffset + 4*int(binary.BigEndian.Uint32(header[discH3CountOffset:]))
+	for i := range tmd.Contents {
+		content := &tmd.Contents[i]
+		content.CIDStr = fmt.Sprintf("%08X", content.ID)
+		size := int(expectedH3DownloadSize(*content))
+		if size == 0 {
+			continue
+		}
+		if h3Offset < 0 || h3Offset+size > len(header) {
+			return errors.New("partition header is too short for the H3 hashes")
+		}
+		if err := os.WriteFile(filepath.Join(folder, content.CIDStr+".h3"), header[h3Offset:h3Offset+size], downloadFilePerm); err != nil {
+			return err
+		}
+		h3Offset += size
+		progress.add(uint64(size))
+	}
+
+	if err := d.importContent(ctx, folder, tmd.Contents[0], dataOffset, progress); err != nil {
+		return err
+	}
+	cipherHashTree, err := titleKeyCipher(folder, tmd)
+	if err != nil {
+		return err
+	}
+	table, err := readFST(folder, tmd, cipherHashTree)
+	if err != nil {
+		return err
+	}
+	if table == nil {
+		return ErrNoFST
+	}
+	if len(table.Clusters) < len(tmd.Contents) {
+		return fmt.Errorf("FST has %d clusters for %d contents", len(table.Clusters), len(tmd.Contents))
+	}
+	if err := checkDiscFST(table); err != nil {
+		return err
+	}
+	for i, content := range tmd.Contents[1:] {
+		offset := dataOffset + int64(table.Clusters[i+1].Offset)*wud.SectorSize
+		if err := d.importContent(ctx, folder, content, offset, progress); err != nil {
+			return err
+		}
+	}
+	// The TMD is written last so an interrupted import is not taken for a
+	// complete title.
+	return os.WriteFile(filepath.Join(folder, "title.tmd"), game.tmdData, downloadFilePerm)
+}
+
+// importContent copies the encrypted content at offset of the disc into its
+// .app file.
+func (d *discImage) importContent(ctx context.Context, folder string, content Content, offset int64, progress *byteProgress) error {
+	size := expectedContentDownloadSize(content)
+	if offset+size > d.image.Size() {
+		return fmt.Errorf("content %s is out of bounds", content.CIDStr)
+	}
+	file, err := os.OpenFile(filepath.Join(folder, content.CIDStr+".app"), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, downloadFilePerm)
+	if err != nil {
+		return err
+	}
+	src := io.NewSectionReader(d.image, offset, size)
+	buf := make([]byte, READ_SIZE)
+	for {
+		if err := ctx.Err(); err != nil {
+			file.Close()
+			return err
+		}
+		n, readErr := io.ReadFull(src, buf)
+		if n > 0 {
+			if _, err := file.Write(buf[:n]); err != nil {
+				file.Close()
+				return err
+			}
+			if progress.add(uint64(n)) {
+				file.Close()
+				return ErrCancelled
+			}
+		}
+		if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+			break
+		}
+		if readErr != nil {
+			file.Close()
+			return readErr
+		}
+	}
+	return file.Close()
+}
@@ -0,0 +1,262 @@
+package wiiudownloader
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	fstfmt "github.com/Xpl0itU/WiiUDownloader/internal/formats/fst"
+	"github.com/Xpl0itU/WiiUDownloader/internal/formats/wud"
+)
+
+// testDiscHeaderSize is the size of the header of the game partition of
+// writeTestDisc, larger than the usual single sector.
+const testDiscHeaderSize = 2 * wud.SectorSize
+
+// writeTestDisc returns a disc image holding the encrypted title folder title
+// in a game partition, encrypted with discKey. The clusters of the SI
+// partition are shifted by siShift sectors, which only leaves the FST where
+// its first cluster points when siShift is 0.
+func writeTestDisc(t *testing.T, title string, discKey []byte, siShift uint32) []byte {
+	t.Helper()
+	key, err := aes.NewCipher(discKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	readFile := func(name string) []byte {
+		data, err := os.ReadFile(filepath.Join(title, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+	encrypt := func(data []byte, iv []byte) {
+		cipher.NewCBCEncrypter(key, iv).CryptBlocks(data, data)
+	}
+	partitionHeader := func(size int) []byte {
+		header := make([]byte, size)
+		binary.BigEndian.PutUint32(header, discPartitionHeaderMagic)
+		binary.BigEndian.PutUint32(header[discHeaderSizeOffset:], uint32(size))
+		return header
+	}
+
+	// The SI partition holds the TMD and ticket in content 1, encrypted in
+	// chunks with the disc key.
+	var siContent []byte
+	folder := &fstfmt.Node{Name: "01", Dir: true}
+	for _, name := range []string{"title.tmd", "title.tik"} {
+		data := readFile(name)
+		folder.Children = append(folder.Children, &fstfmt.Node{Name: name, Content: 1, Offset: uint64(len(siContent)), Size: uint32(len(data))})
+		siContent = append(siContent, data...)
+		siContent = append(siContent, make([]byte, alignUp(uint64(len(siContent)), 0x20)-uint64(len(siContent)))...)
+	}
+	siContent = append(siContent, make([]byte, alignUp(uint64(len(siContent)), discFileChunkSize)-uint64(len(siContent)))...)
+	for i := 0; i < len(siContent)/discFileChunkSize; i++ {
+		iv := make([]byte, aes.BlockSize)
+		binary.BigEndian.PutUint64(iv[8:], uint64(i))
+		encrypt(siContent[i*discFileChunkSize:(i+1)*discFileChunkSize], iv)
+	}
+	clusters := []fstfmt.Cluster{
+		{Offset: siShift, Size: 1},
+		{Offset: siShift + 1, Size: uint32(len(siContent) / wud.SectorSize)},
+	}
+	siFST, err := fstfmt.Build(0x20, clusters, &fstfmt.Node{Dir: true, Children: []*fstfmt.Node{folder}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	siFST = append(siFST, make([]byte, wud.SectorSize-len(siFST))...)
+	encrypt(siFST, make([]byte, aes.BlockSize))
+	si := partitionHeader(wud.SectorSize)
+	si = append(si, siFST...)
+	si = append(si, make([]byte, int(siShift)*wud.SectorSize)...)
+	si = append(si, siContent...)
+
+	// The game partition holds the H3 hashes in its header and the contents
+	// where the clusters of its FST point.
+	tmd, err := ParseTMD(readFile("title.tmd"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range tmd.Contents {
+		tmd.Contents[i].CIDStr = fmt.Sprintf("%08X", tmd.Contents[i].ID)
+	}
+	cipherHashTree, err := titleKeyCipher(title, tmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	table, err := readFST(title, tmd, cipherHashTree)
+	if err != nil || table == nil {
+		t.Fatalf("readFST: %v", err)
+	}
+	game := partitionHeader(testDiscHeaderSize)
+	binary.BigEndian.PutUint32(game[discH3CountOffset:], uint32(len(tmd.Contents)))
+	h3Offset := discH3TableOffset + 4*len(tmd.Contents)
+	for i, content := range tmd.Contents {
+		if content.Type&CONTENT_TYPE_HASHED != 0 {
+			h3Offset += copy(game[h3Offset:], readFile(content.CIDStr+".h3"))
+		}
+		app := readFile(content.CIDStr + ".app")
+		offset := testDiscHeaderSize + int(table.Clusters[i].Offset)*wud.SectorSize
+		if end := offset + len(app); end > len(game) {
+			game = append(game, make([]byte, end-len(game))...)
+		}
+		copy(game[offset:], app)
+	}
+
+	// The partition table follows the unencrypted disc header.
+	partitionTable := make([]byte, wud.SectorSize)
+	binary.BigEndian.PutUint32(partitionTable, discPartitionTableMagic)
+	binary.BigEndian.PutUint32(partitionTable[discPartitionCountOffset:], 2)
+	image := make([]byte, discPartitionTableOffset+wud.SectorSize)
+	for i, partition := range []struct {
+		name string
+		data []byte
+	}{{discSIPartition, si}, {fmt.Sprintf("GM%016X", uint64(testTitleID)), game}} {
+		entry := partitionTable[discPartitionEntriesOffset+i*discPartitionEntrySize:]
+		copy(entry, partition.name)
+		binary.BigEndian.PutUint32(entry[discPartitionSectorOffset:], uint32(len(image)/wud.SectorSize))
+		image = append(image, partition.data...)
+		image = append(image, make([]byte, alignUp(uint64(len(image)), wud.SectorSize)-uint64(len(image)))...)
+	}
+	encrypt(partitionTable, make([]byte, aes.BlockSize))
+	copy(image[discPartitionTableOffset:], partitionTable)
+	return image
+}
+
+// compressTestWUX returns image as a WUX image storing identical sectors
+// once.
+func compressTestWUX(image []byte) []byte {
+	const sectorSize = wud.SectorSize
+	count := (len(image) + sectorSize - 1) / sectorSize
+	header := make([]byte, 0x20+4*count)
+	binary.LittleEndian.PutUint32(header[0x00:], wud.Magic0)
+	binary.LittleEndian.PutUint32(header[0x04:], wud.Magic1)
+	binary.LittleEndian.PutUint32(header[0x08:], sectorSize)
+	binary.LittleEndian.PutUint64(header[0x10:], uint64(len(image)))
+	var sectors []byte
+	stored := make(map[string]uint32)
+	for i := 0; i < count; i++ {
+		sector := make([]byte, sectorSize)
+		copy(sector, image[i*sectorSize:])
+		index, ok := stored[string(sector)]
+		if !ok {
+			index = uint32(len(stored))
+			stored[string(sector)] = index
+			sectors = append(sectors, sector...)
+		}
+		binary.LittleEndian.PutUint32(header[0x20+4*i:], index)
+	}
+	header = append(header, make([]byte, alignUp(uint64(len(header)), sectorSize)-uint64(len(header)))...)
+	return append(header, sectors...)
+}
+
+func TestImportDiscImage(t *testing.T) {
+	files := testTitleFiles()
+	dir := t.TempDir()
+	src, original, title := filepath.Join(dir, "src"), filepath.Join(dir, "original"), filepath.Join(dir, "title")
+	writeTestFiles(t, src, files)
+	writeTestWiiUOriginal(t, original)
+	if err := RepackTitle(context.Background(), src, title, RepackTitleOptions{Original: original}); err != nil {
+		t.Fatalf("RepackTitle: %v", err)
+	}
+	discKey := bytes.Repeat([]byte{0x5A}, discKeySize)
+	image := writeTestDisc(t, title, discKey, 0)
+
+	writeImage := func(t *testing.T, name string, data []byte) string {
+		t.Helper()
+		imagePath := filepath.Join(t.TempDir(), name)
+		if err := os.WriteFile(imagePath, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return imagePath
+	}
+	checkImport := func(t *testing.T, imagePath string) {
+		t.Helper()
+		dest := t.TempDir()
+		titles, err := ImportDiscImage(context.Background(), imagePath, dest, DiscImageOptions{DiscKey: discKey})
+		if err != nil {
+			t.Fatalf("ImportDiscImage: %v", err)
+		}
+		if len(titles) != 1 || titles[0].TitleID != testTitleID || titles[0].TitleVersion != 32 {
+			t.Fatalf("ImportDiscImage titles = %+v", titles)
+		}
+		entries, err := os.ReadDir(title)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, entry := range entries {
+			want, err := os.ReadFile(filepath.Join(title, entry.Name()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := os.ReadFile(filepath.Join(titles[0].Folder, entry.Name()))
+			if err != nil {
+				t.Fatalf("imported title: %v", err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("imported title: %s differs from the repacked one", entry.Name())
+			}
+		}
+		report, err := VerifyTitle(titles[0].Folder, nil)
+		if err != nil {
+			t.Fatalf("VerifyTitle: %v", err)
+		}
+		if !report.OK() {
+			t.Fatalf("VerifyTitle failed contents: %v", report.Failed())
+		}
+	}
+
+	t.Run("WUD", func(t *testing.T) {
+		checkImport(t, writeImage(t, "game.wud", image))
+	})
+
+	t.Run("WUX", func(t *testing.T) {
+		wux := compressTestWUX(image)
+		if len(wux) >= len(image) {
+			t.Fatalf("WUX image is %d bytes, not smaller than the %d of the disc", len(wux), len(image))
+		}
+		checkImport(t, writeImage(t, "game.wux", wux))
+	})
+
+	t.Run("Extract", func(t *testing.T) {
+		dest := t.TempDir()
+		titles, err := ExtractDiscImage(context.Background(), writeImage(t, "game.wud", image), dest, DiscImageOptions{DiscKey: discKey})
+		if err != nil {
+			t.Fatalf("ExtractDiscImage: %v", err)
+		}
+		for name, data := range files {
+			got, err := os.ReadFile(filepath.Join(titles[0].Folder, filepath.FromSlash(name)))
+			if err != nil {
+				t.Fatalf("extracted title: %v", err)
+			}
+			if !bytes.Equal(got, data) {
+				t.Errorf("extracted title: %s differs from the repacked file", name)
+			}
+		}
+	})
+
+	t.Run("WrongKey", func(t *testing.T) {
+		_, err := ImportDiscImage(context.Background(), writeImage(t, "game.wud", image), t.TempDir(), DiscImageOptions{DiscKey: make([]byte, discKeySize)})
+		if !errors.Is(err, ErrInvalidDiscKey) {
+			t.Fatalf("ImportDiscImage error = %v, want ErrInvalidDiscKey", err)
+		}
+	})
+
+	t.Run("MisplacedFST", func(t *testing.T) {
+		// The clusters of the SI partition count from a sector before the
+		// partition data, where the FST is not.
+		moved := writeTestDisc(t, title, discKey, 1)
+		_, err := ImportDiscImage(context.Background(), writeImage(t, "game.wud", moved), t.TempDir(), DiscImageOptions{DiscKey: discKey})
+		if err == nil || !strings.Contains(err.Error(), "first cluster") {
+			t.Fatalf("ImportDiscImage error = %v, want a misplaced FST", err)
+		}
+	})
+}
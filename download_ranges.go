@@ -0,0 +1,404 @@
+package wiiudownloader
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	ctxio "github.com/jbenet/go-context/io"
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	// Files smaller than this are always fetched over a single connection.
+	minRangedDownloadSize     = 64 << 20
+	defaultConnectionsPerFile = 4
+)
+
+var errRangesUnsupported = errors.New("server does not support range requests")
+
+// downloadRange is a byte range [Start, End) of a file fetched by one
+// connection. Its segments are contiguous from Start.
+type downloadRange struct {
+	Start    int64             `json:"start"`
+	End      int64             `json:"end"`
+	Segments []downloadSegment `json:"segments"`
+}
+
+func (r downloadRange) next() int64 {
+	if len(r.Segments) == 0 {
+		return r.Start
+	}
+	last := r.Segments[len(r.Segments)-1]
+	return last.Offset + last.Size
+}
+
+func (r downloadRange) completed() int64 {
+	return r.next() - r.Start
+}
+
+// splitDownloadRanges divides size bytes into at most connections ranges whose
+// boundaries fall on segment boundaries.
+func splitDownloadRanges(size, segmentSize int64, connections int) []downloadRange {
+	segments := (size + segmentSize - 1) / segmentSize
+	if int64(connections) > segments {
+		connections = int(segments)
+	}
+	if connections < 1 {
+		connections = 1
+	}
+	perRange := (segments + int64(connections) - 1) / int64(connections)
+
+	ranges := make([]downloadRange, 0, connections)
+	for start := int64(0); start < size; start += perRange * segmentSize {
+		end := start + perRange*segmentSize
+		if end > size {
+			end = size
+		}
+		ranges = append(ranges, downloadRange{Start: start, End: end, Segments: make([]downloadSegment, 0)})
+	}
+	return ranges
+}
+
+func useRangedDownload(opts downloadOptions) bool {
+	return opts.Connections > 1 && opts.AllowResume && opts.ExpectedSize >= minRangedDownloadSize
+}
+
+// prepareRangedDownloadState loads or creates the journal of a ranged download
+// and drops every segment that no longer matches the partial file. It returns
+// errRangesUnsupported when a single-stream download is already in progress,
+// so that one is resumed instead.
+func prepareRangedDownloadState(dstPath, resumeKey string, expectedSize int64, connections int) (*downloadState, error) {
+	statePath := statePathFor(dstPath)
+	partPath := partPathFor(dstPath)
+
+	state, dirty, err := loadDownloadState(statePath)
+	if err != nil && !os.IsNotExist(err) && !errors.Is(err, errInvalidDownloadState) {
+		return nil, err
+	}
+	reusable := err == nil && state.URL == resumeKey && state.ExpectedSize == expectedSize
+	if reusable && len(state.Ranges) == 0 && state.VerifiedOffset > 0 {
+		return nil, errRangesUnsupported
+	}
+	if reusable && len(state.Ranges) > 0 {
+		changed, err := verifyPartialRanges(partPath, &state)
+		switch {
+		case err == nil:
+			if changed || dirty {
+				if err := rewriteDownloadState(statePath, state); err != nil {
+					return nil, err
+				}
+			}
+			return &state, nil
+		case !os.IsNotExist(err):
+			return nil, err
+		}
+	}
+
+	if err := cleanupPartialDownload(dstPath); err != nil {
+		return nil, err
+	}
+	newState := resetDownloadState(resumeKey, expectedSize, 0)
+	newState.Ranges = splitDownloadRanges(expectedSize, newState.SegmentSize, connections)
+	if err := rewriteDownloadState(statePath, newState); err != nil {
+		return nil, err
+	}
+	return &newState, nil
+}
+
+func verifyPartialRanges(partPath string, state *downloadState) (bool, error) {
+	file, err := os.Open(partPath)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	buf := make([]byte, state.SegmentSize)
+	changed := false
+	for i := range state.Ranges {
+		rng := &state.Ranges[i]
+		offset := rng.Start
+		valid := rng.Segments[:0]
+		for _, segment := range rng.Segments {
+			if segment.Offset != offset || segment.Size <= 0 || segment.Size > state.SegmentSize || segment.Offset+segment.Size > rng.End {
+				changed = true
+				break
+			}
+			if _, err := file.ReadAt(buf[:segment.Size], segment.Offset); err != nil {
+				changed = true
+				break
+			}
+			if hashSegmentHex(buf[:segment.Size]) != segment.SHA256 {
+				changed = true
+				break
+			}
+			valid = append(valid, segment)
+			offset += segment.Size
+		}
+		rng.Segments = valid
+	}
+	return changed, nil
+}
+
+// rangeJournal serialises updates of a ranged download state coming from
+// several connections.
+type rangeJournal struct {
+	mutex     sync.Mutex
+	state     *downloadState
+	statePath string
+}
+
+func (j *rangeJournal) addSegment(index int, segment downloadSegment) error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	j.state.Ranges[index].Segments = append(j.state.Ranges[index].Segments, segment)
+	return saveDownloadState(j.statePath, *j.state)
+}
+
+func (j *rangeJournal) rangeAt(index int) downloadRange {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	return j.state.Ranges[index]
+}
+
+func (j *rangeJournal) completed() int64 {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	total := int64(0)
+	for _, rng := range j.state.Ranges {
+		total += rng.completed()
+	}
+	return total
+}
+
+// checkValidators makes sure every connection is reading the same version of
+// the file.
+func (j *rangeJournal) checkValidators(resp *http.Response) error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	if etag := resp.Header.Get("ETag"); etag != "" {
+		if j.state.ETag != "" && j.state.ETag != etag {
+			return fmt.Errorf("download source changed while resuming")
+		}
+		j.state.ETag = etag
+	}
+	if lastModified := resp.Header.Get("Last-Modified"); lastModified != "" {
+		if j.state.LastModified != "" && j.state.LastModified != lastModified {
+			return fmt.Errorf("download source changed while resuming")
+		}
+		j.state.LastModified = lastModified
+	}
+	return nil
+}
+
+// rangeWriter writes one range into the partial file and records a journal
+// segment every time a segment boundary is reached.
+type rangeWriter struct {
+	file         *os.File
+	journal      *rangeJournal
+	index        int
+	end          int64
+	segmentSize  int64
+	offset       int64
+	segmentStart int64
+	hash         hash.Hash
+}
+
+func (w *rangeWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		segmentEnd := w.segmentStart + w.segmentSize
+		if segmentEnd > w.end {
+			segmentEnd = w.end
+		}
+		if w.offset >= segmentEnd {
+			return written, fmt.Errorf("range %d received more data than requested", w.index)
+		}
+		chunk := p
+		if remaining := segmentEnd - w.offset; int64(len(chunk)) > remaining {
+			chunk = chunk[:remaining]
+		}
+		n, err := w.file.WriteAt(chunk, w.offset)
+		w.hash.Write(chunk[:n])
+		w.offset += int64(n)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+
+		if w.offset == segmentEnd {
+			segment := downloadSegment{
+				Offset: w.segmentStart,
+				Size:   segmentEnd - w.segmentStart,
+				SHA256: hex.EncodeToString(w.hash.Sum(nil)),
+			}
+			if err := w.journal.addSegment(w.index, segment); err != nil {
+				return written, err
+			}
+			w.hash.Reset()
+			w.segmentStart = segmentEnd
+		}
+	}
+	return written, nil
+}
+
+// downloadFileRanged fetches a file over several connections, each writing its
+// own byte range of the partial file. It returns errRangesUnsupported when the
+// file has to be fetched with a single stream instead.
+func downloadFileRanged(ctx context.Context, progressReporter ProgressReporter, client *http.Client, downloadURL, dstPath, resumeKey string, opts downloadOptions) error {
+	basePath := filepath.Base(dstPath)
+	statePath := statePathFor(dstPath)
+	partPath := partPathFor(dstPath)
+
+	state, err := prepareRangedDownloadState(dstPath, resumeKey, opts.ExpectedSize, opts.Connections)
+	if err != nil {
+		return err
+	}
+	if state.Source != downloadURL {
+		state.Source = downloadURL
+		state.LastModified = ""
+		state.ETag = ""
+	}
+	journal := &rangeJournal{state: state, statePath: statePath}
+
+	file, err := os.OpenFile(partPath, os.O_CREATE|os.O_RDWR, downloadFilePerm)
+	if err != nil {
+		return err
+	}
+	if progressReporter != nil {
+		progressReporter.SetTotalDownloadedForFile(basePath, journal.completed())
+	}
+
+	g, rangeCtx := errgroup.WithContext(ctx)
+	for i := range state.Ranges {
+		i := i
+		g.Go(func() error {
+			return fetchRange(rangeCtx, progressReporter, client, downloadURL, file, journal, i, basePath, opts)
+		})
+	}
+	err = g.Wait()
+	if syncErr := file.Sync(); err == nil {
+		err = syncErr
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		if errors.Is(err, errRangesUnsupported) {
+			if cleanupErr := cleanupPartialDownload(dstPath); cleanupErr != nil {
+				return cleanupErr
+			}
+		}
+		if isCancelled(progressReporter) {
+			return ErrCancelled
+		}
+		return err
+	}
+
+	if err := finalFileSizeMatches(partPath, opts.ExpectedSize); err != nil {
+		return err
+	}
+	if opts.Validate != nil {
+		if err := opts.Validate(partPath); err != nil {
+			if cleanupErr := cleanupPartialDownload(dstPath); cleanupErr != nil {
+				return cleanupErr
+			}
+			return err
+		}
+	}
+	if err := os.Rename(partPath, dstPath); err != nil {
+		return err
+	}
+	if err := os.Remove(statePath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	opts.Stats.addFile(dstPath)
+	if progressReporter != nil {
+		progressReporter.MarkFileAsDone(basePath)
+	}
+	return nil
+}
+
+func fetchRange(ctx context.Context, progressReporter ProgressReporter, client *http.Client, downloadURL string, file *os.File, journal *rangeJournal, index int, basePath string, opts downloadOptions) error {
+	rng := journal.rangeAt(index)
+	offset := rng.next()
+	if offset >= rng.End {
+		return nil
+	}
+	if !waitUntilResumed(progressReporter) {
+		return ErrCancelled
+	}
+
+	attemptCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stopMonitor := monitorCancellation(attemptCtx, cancel, progressReporter)
+	defer stopMonitor()
+
+	req, err := http.NewRequestWithContext(attemptCtx, http.MethodGet, downloadURL, nil)
+	if err != nil {
+		return err
+	}
+	if opts.UserAgent != "" {
+		req.Header.Set("User-Agent", opts.UserAgent)
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, rng.End-1))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+	case http.StatusOK:
+		return errRangesUnsupported
+	default:
+		return fmt.Errorf("range download error, %w", &statusError{StatusCode: resp.StatusCode})
+	}
+	if start, ok := responseRangeStart(resp); !ok || start != offset {
+		return fmt.Errorf("download resume failed: unexpected content-range")
+	}
+	if err := journal.checkValidators(resp); err != nil {
+		return err
+	}
+
+	writer := &rangeWriter{
+		file:         file,
+		journal:      journal,
+		index:        index,
+		end:          rng.End,
+		segmentSize:  journal.state.SegmentSize,
+		offset:       offset,
+		segmentStart: offset,
+		hash:         sha256.New(),
+	}
+	timer := time.AfterFunc(downloadTimeout, cancel)
+	defer timer.Stop()
+	writerProgress := newWriterProgress(writer, progressReporter, basePath)
+	watchdog := &watchdogReader{Reader: io.LimitReader(resp.Body, rng.End-offset), timer: timer, ctx: attemptCtx}
+
+	written, err := io.Copy(ctxio.NewWriter(attemptCtx, writerProgress), watchdog)
+	writerProgress.Close()
+	opts.Stats.addTransferred(written)
+	if err != nil {
+		if isCancelled(progressReporter) {
+			return ErrCancelled
+		}
+		return err
+	}
+	if writer.offset != rng.End {
+		return fmt.Errorf("range %d ended at %d, want %d: %w", index, writer.offset, rng.End, io.ErrUnexpectedEOF)
+	}
+	return nil
+}
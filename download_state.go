@@ -10,6 +10,7 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
 const (
@@ -37,6 +38,14 @@ type downloadOptions struct {
 	SegmentSize  int64
 	UserAgent    string
 	Validate     func(path string) error
+	// ResumeKey identifies the file in the resume journal instead of the URL,
+	// so a partial download can be continued from a different mirror.
+	ResumeKey string
+	// Stats, when set, collects transfer counters for DownloadTitleResult.
+	Stats *downloadStats
+	// Connections is the number of parallel range requests used for files of
+	// at least minRangedDownloadSize bytes.
+	Connections int
 }
 
 type downloadSegment struct {
@@ -47,6 +56,7 @@ type downloadSegment struct {
 
 type downloadState struct {
 	URL            string            `json:"url"`
+	Source         string            `json:"source,omitempty"`
 	ExpectedSize   int64             `json:"expected_size"`
 	SegmentSize    int64             `json:"segment_size"`
 	VerifiedOffset int64             `json:"verified_offset"`
@@ -54,6 +64,9 @@ type downloadState struct {
 	ETag           string            `json:"etag,omitempty"`
 	Segments       []downloadSegment `json:"segments"`
 	PartialSegment *downloadSegment  `json:"partial_segment,omitempty"`
+	// Ranges is set instead of Segments when the file is fetched over several
+	// connections at once.
+	Ranges []downloadRange `json:"ranges,omitempty"`
 }
 
 type resumeStateWriter struct {
@@ -283,7 +296,7 @@ func prepareDownloadState(dstPath, downloadURL string, expectedSize int64, allow
 		return nil, 0, err
 	}
 
-	if state.URL != "" && state.URL != downloadURL {
+	if (state.URL != "" && state.URL != downloadURL) || len(state.Ranges) > 0 {
 		if err := cleanupPartialDownload(dstPath); err != nil {
 			return nil, 0, err
 		}
@@ -535,7 +548,7 @@ func finalFileSizeMatches(path string, expectedSize int64) error {
 		return err
 	}
 	if info.Size() != expectedSize {
-		return fmt.Errorf("download size mismatch: got %d, want %d", info.Size(), expectedSize)
+		return &SizeMismatchError{File: filepath.Base(strings.TrimSuffix(path, downloadPartExtension)), Expected: expectedSize, Actual: info.Size()}
 	}
 	return nil
 }
@@ -2,6 +2,8 @@ package wiiudownloader
 
 import (
 	"context"
+	"crypto/cipher"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -9,6 +11,8 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	ctxio "github.com/jbenet/go-context/io"
@@ -21,14 +25,12 @@ const (
 	maxConcurrentDownloads = 4
 )
 
-var (
-	errCancel       = fmt.Errorf("cancelled download")
-	downloadTimeout = 30 * time.Second
-)
+var downloadTimeout = 30 * time.Second
 
 type watchdogReader struct {
 	io.Reader
 	timer *time.Timer
+	ctx   context.Context
 }
 
 func (r *watchdogReader) Read(p []byte) (int, error) {
@@ -38,8 +40,15 @@ func (r *watchdogReader) Read(p []byte) (int, error) {
 		default:
 		}
 	}
+	// The watchdog stays stopped while waiting for bandwidth.
+	p = p[:globalBandwidthLimiter.readSize(len(p))]
+	if err := globalBandwidthLimiter.wait(r.ctx, len(p)); err != nil {
+		return 0, err
+	}
 	r.timer.Reset(downloadTimeout)
-	return r.Reader.Read(p)
+	n, err := r.Reader.Read(p)
+	globalBandwidthLimiter.refund(len(p) - n)
+	return n, err
 }
 
 type ProgressReporter interface {
@@ -147,9 +156,14 @@ func downloadFileWithOptions(ctx context.Context, progressReporter ProgressRepor
 		ctx = context.Background()
 	}
 	basePath := filepath.Base(dstPath)
+	resumeKey := downloadURL
+	if opts.ResumeKey != "" {
+		resumeKey = opts.ResumeKey
+	}
 
-	if _, err := os.Stat(dstPath); err == nil {
+	if info, err := os.Stat(dstPath); err == nil {
 		if err := validateExistingDownload(dstPath, opts); err == nil {
+			opts.Stats.addResumed(info.Size())
 			if progressReporter != nil {
 				progressReporter.SetTotalDownloadedForFile(basePath, opts.ExpectedSize)
 				progressReporter.MarkFileAsDone(basePath)
@@ -163,15 +177,43 @@ func downloadFileWithOptions(ctx context.Context, progressReporter ProgressRepor
 		return err
 	}
 
+	if useRangedDownload(opts) {
+		for attempt := 1; attempt <= maxRetries; attempt++ {
+			if !waitUntilResumed(progressReporter) {
+				return ErrCancelled
+			}
+			err := downloadFileRanged(ctx, progressReporter, client, downloadURL, dstPath, resumeKey, opts)
+			if err == nil {
+				return nil
+			}
+			if errors.Is(err, errRangesUnsupported) {
+				break
+			}
+			if isCancelled(progressReporter) || errors.Is(err, ErrCancelled) {
+				return ErrCancelled
+			}
+			if !shouldRetry(progressReporter, opts.DoRetries, attempt) {
+				return err
+			}
+			time.Sleep(retryDelay)
+		}
+	}
+
 	for attempt := 1; attempt <= maxRetries; attempt++ {
 		if !waitUntilResumed(progressReporter) {
-			return errCancel
+			return ErrCancelled
 		}
 
-		state, existingOffset, err := prepareDownloadState(dstPath, downloadURL, opts.ExpectedSize, opts.AllowResume, opts.SegmentSize)
+		state, existingOffset, err := prepareDownloadState(dstPath, resumeKey, opts.ExpectedSize, opts.AllowResume, opts.SegmentSize)
 		if err != nil {
 			return err
 		}
+		if state != nil && state.Source != downloadURL {
+			// Validators are specific to the server that produced them.
+			state.Source = downloadURL
+			state.LastModified = ""
+			state.ETag = ""
+		}
 
 		attemptCtx, cancel := context.WithCancel(ctx)
 		stopMonitor := monitorCancellation(attemptCtx, cancel, progressReporter)
@@ -194,7 +236,7 @@ func downloadFileWithOptions(ctx context.Context, progressReporter ProgressRepor
 			stopMonitor()
 			cancel()
 			if isCancelled(progressReporter) {
-				return errCancel
+				return ErrCancelled
 			}
 			if shouldRetry(progressReporter, opts.DoRetries, attempt) {
 				time.Sleep(retryDelay)
@@ -228,7 +270,7 @@ func downloadFileWithOptions(ctx context.Context, progressReporter ProgressRepor
 				cancel()
 				return err
 			}
-			state, existingOffset, err = prepareDownloadState(dstPath, downloadURL, opts.ExpectedSize, opts.AllowResume, opts.SegmentSize)
+			state, existingOffset, err = prepareDownloadState(dstPath, resumeKey, opts.ExpectedSize, opts.AllowResume, opts.SegmentSize)
 			if err != nil {
 				resp.Body.Close()
 				stopMonitor()
@@ -245,7 +287,7 @@ func downloadFileWithOptions(ctx context.Context, progressReporter ProgressRepor
 				time.Sleep(retryDelay)
 				continue
 			}
-			return fmt.Errorf("download error after %d attempts, status code: %d", attempt, resp.StatusCode)
+			return fmt.Errorf("download error after %d attempts, %w", attempt, &statusError{StatusCode: resp.StatusCode})
 		}
 
 		expectedSize := responseExpectedSize(resp, existingOffset, opts.ExpectedSize)
@@ -261,7 +303,7 @@ func downloadFileWithOptions(ctx context.Context, progressReporter ProgressRepor
 					time.Sleep(retryDelay)
 					continue
 				}
-				return fmt.Errorf("download size mismatch: expected %d, got %d", state.ExpectedSize, expectedSize)
+				return &SizeMismatchError{File: basePath, Expected: state.ExpectedSize, Actual: expectedSize}
 			}
 			if expectedSize > 0 {
 				state.ExpectedSize = expectedSize
@@ -354,9 +396,10 @@ func downloadFileWithOptions(ctx context.Context, progressReporter ProgressRepor
 
 		writerProgress := newWriterProgress(underlyingWriter, progressReporter, basePath)
 		writerProgressWithContext := ctxio.NewWriter(attemptCtx, writerProgress)
-		watchdog := &watchdogReader{Reader: resp.Body, timer: timer}
+		watchdog := &watchdogReader{Reader: resp.Body, timer: timer, ctx: attemptCtx}
 
-		_, err = io.Copy(writerProgressWithContext, watchdog)
+		written, err := io.Copy(writerProgressWithContext, watchdog)
+		opts.Stats.addTransferred(written)
 		timer.Stop()
 		stopMonitor()
 		resp.Body.Close()
@@ -373,7 +416,7 @@ func downloadFileWithOptions(ctx context.Context, progressReporter ProgressRepor
 			file.Close()
 			cancel()
 			if isCancelled(progressReporter) {
-				return errCancel
+				return ErrCancelled
 			}
 			if shouldRetry(progressReporter, opts.DoRetries, attempt) {
 				time.Sleep(retryDelay)
@@ -427,6 +470,7 @@ func downloadFileWithOptions(ctx context.Context, progressReporter ProgressRepor
 			cancel()
 			return err
 		}
+		opts.Stats.addFile(dstPath)
 		if progressReporter != nil {
 			progressReporter.MarkFileAsDone(basePath)
 		}
@@ -437,91 +481,303 @@ func downloadFileWithOptions(ctx context.Context, progressReporter ProgressRepor
 	return nil
 }
 
-func downloadFileWithSemaphoreOptions(ctx context.Context, progressReporter ProgressReporter, client *http.Client, downloadURL, dstPath string, opts downloadOptions) error {
-	return downloadFileWithOptions(ctx, progressReporter, client, downloadURL, dstPath, opts)
+// DownloadTitleOptions configures DownloadTitleWithOptions.
+type DownloadTitleOptions struct {
+	OutputDirectory         string
+	Decrypt                 bool
+	DeleteEncryptedContents bool
+	// Client defaults to http.DefaultClient.
+	Client *http.Client
+	// ProgressReporter may be nil.
+	ProgressReporter ProgressReporter
+	// Concurrency is the number of contents downloaded in parallel. Zero uses the default of 4.
+	Concurrency int
+	// ConnectionsPerFile is the number of range requests a large content is
+	// split into. Zero uses the default of 4; 1 disables splitting.
+	ConnectionsPerFile int
+	// UserAgent defaults to "WiiUDownloader".
+	UserAgent string
+	// TitleVersion selects the version to download. Nil downloads the latest
+	// version; ListTitleVersions returns the versions that can be chosen.
+	TitleVersion *uint16
+	// DecryptionWorkers is the number of files extracted in parallel when
+	// Decrypt is set. Zero uses one worker per CPU.
+	DecryptionWorkers int
+	// DecryptWhileDownloading extracts the files of each content as soon as
+	// it is downloaded instead of decrypting the whole title afterwards, so
+	// that the title is ready shortly after the last content arrives. It has
+	// no effect unless Decrypt is set.
+	DecryptWhileDownloading bool
+	// Filter selects the files of a Wii U title to extract when Decrypt is
+	// set; the zero value extracts all of them.
+	Filter PathFilter
+	// MatchingContentsOnly downloads only the contents that hold files
+	// selected by Filter, plus content 0 with the FST. Wii titles and titles
+	// without an FST are always downloaded whole.
+	MatchingContentsOnly bool
 }
 
-func downloadFile(progressReporter ProgressReporter, client *http.Client, downloadURL, dstPath string, doRetries bool) error {
-	return downloadFileWithOptions(context.Background(), progressReporter, client, downloadURL, dstPath, downloadOptions{
-		DoRetries:   doRetries,
-		AllowResume: true,
-		UserAgent:   "WiiUDownloader",
+// DownloadTitleResult describes what DownloadTitleWithOptions did.
+type DownloadTitleResult struct {
+	TMD          *TMD
+	TitleVersion uint16
+	// FilesWritten lists the files downloaded or generated by this call. Files
+	// that were already complete on disk are not included.
+	FilesWritten []string
+	// BytesTransferred counts the bytes received over the network, including
+	// failed attempts.
+	BytesTransferred int64
+	// BytesResumed counts the bytes reused from partial or complete files left
+	// by an earlier download.
+	BytesResumed int64
+	// TicketGenerated is set when the CDN had no ticket and one was created
+	// from a key generated with keygen.
+	TicketGenerated bool
+}
+
+// downloadStats accumulates the transfer counters of a DownloadTitleResult.
+type downloadStats struct {
+	bytesTransferred atomic.Int64
+	bytesResumed     atomic.Int64
+	filesMutex       sync.Mutex
+	filesWritten     []string
+}
+
+func (s *downloadStats) addTransferred(n int64) {
+	if s != nil {
+		s.bytesTransferred.Add(n)
+	}
+}
+
+func (s *downloadStats) addResumed(n int64) {
+	if s != nil && n > 0 {
+		s.bytesResumed.Add(n)
+	}
+}
+
+func (s *downloadStats) addFile(path string) {
+	if s == nil {
+		return
+	}
+	s.filesMutex.Lock()
+	defer s.filesMutex.Unlock()
+	s.filesWritten = append(s.filesWritten, path)
+}
+
+// mergeFile folds the counters of a single file download into s. Bytes of the
+// final file that were not transferred by this download were resumed.
+func (s *downloadStats) mergeFile(file *downloadStats, dstPath string, succeeded bool) {
+	if s == nil {
+		return
+	}
+	transferred := file.bytesTransferred.Load()
+	s.addTransferred(transferred)
+	s.addResumed(file.bytesResumed.Load())
+	if !succeeded || len(file.filesWritten) == 0 {
+		return
+	}
+	if info, err := os.Stat(dstPath); err == nil {
+		s.addResumed(info.Size() - transferred)
+	}
+	s.addFile(dstPath)
+}
+
+func (s *downloadStats) fillResult(result *DownloadTitleResult) {
+	result.BytesTransferred = s.bytesTransferred.Load()
+	result.BytesResumed = s.bytesResumed.Load()
+	s.filesMutex.Lock()
+	defer s.filesMutex.Unlock()
+	result.FilesWritten = append([]string(nil), s.filesWritten...)
+}
+
+// downloadContentFiles downloads the .app file of a content and, for hashed
+// contents, its .h3 file into outputDir. Files already complete on disk are
+// kept. The .h3 file is downloaded first so that, when cipherHashTree holds the
+// title key, the .app file can be checked against its hashes and fetched from
+// the next mirror when it does not match. opts supplies the user agent, stats
+// and connection count.
+func downloadContentFiles(ctx context.Context, progressReporter ProgressReporter, client *http.Client, titleIDStr, outputDir string, content Content, cipherHashTree cipher.Block, opts downloadOptions) error {
+	appPath := filepath.Join(outputDir, fmt.Sprintf("%08X.app", content.ID))
+	h3Path := filepath.Join(outputDir, fmt.Sprintf("%08X.h3", content.ID))
+	if content.Type&CONTENT_TYPE_HASHED == CONTENT_TYPE_HASHED {
+		if err := downloadFromMirrors(ctx, progressReporter, client, titleIDStr, fmt.Sprintf("%08X.h3", content.ID), h3Path, downloadOptions{
+			ExpectedSize: expectedH3DownloadSize(content),
+			DoRetries:    true,
+			AllowResume:  true,
+			UserAgent:    opts.UserAgent,
+			Stats:        opts.Stats,
+			Validate: func(path string) error {
+				return verifyH3File(path, content)
+			},
+		}); err != nil {
+			return err
+		}
+	}
+
+	var validate func(path string) error
+	if cipherHashTree != nil {
+		validate = func(path string) error {
+			return validateContentFile(path, h3Path, content, cipherHashTree)
+		}
+	}
+	return downloadFromMirrors(ctx, progressReporter, client, titleIDStr, fmt.Sprintf("%08X", content.ID), appPath, downloadOptions{
+		ExpectedSize: expectedContentDownloadSize(content),
+		DoRetries:    true,
+		AllowResume:  true,
+		UserAgent:    opts.UserAgent,
+		Stats:        opts.Stats,
+		Connections:  opts.Connections,
+		Validate:     validate,
 	})
 }
 
+// DownloadTitle downloads a title into outputDirectory. Cancellation is not
+// reported as an error; use DownloadTitleWithOptions to tell it apart.
 func DownloadTitle(titleID, outputDirectory string, doDecryption bool, progressReporter ProgressReporter, deleteEncryptedContents bool, client *http.Client) error {
 	tid, err := strconv.ParseUint(titleID, 16, 64)
 	if err != nil {
 		return err
 	}
-	tEntry := GetTitleEntryFromTid(tid)
+	_, err = DownloadTitleWithOptions(context.Background(), tid, DownloadTitleOptions{
+		OutputDirectory:         outputDirectory,
+		Decrypt:                 doDecryption,
+		DeleteEncryptedContents: deleteEncryptedContents,
+		Client:                  client,
+		ProgressReporter:        progressReporter,
+	})
+	if errors.Is(err, ErrCancelled) {
+		return nil
+	}
+	return err
+}
+
+// DownloadTitleWithOptions downloads a title from the configured CDN mirrors,
+// by default its latest version. It returns ErrCancelled when ctx is cancelled or the
+// progress reporter is cancelled. The result is filled in as far as the
+// download got, even when an error is returned.
+func DownloadTitleWithOptions(ctx context.Context, titleID uint64, opts DownloadTitleOptions) (*DownloadTitleResult, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	client := opts.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	userAgent := opts.UserAgent
+	if userAgent == "" {
+		userAgent = DEFAULT_USER_AGENT
+	}
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = maxConcurrentDownloads
+	}
+	connections := opts.ConnectionsPerFile
+	if connections <= 0 {
+		connections = defaultConnectionsPerFile
+	}
+	progressReporter := opts.ProgressReporter
+
+	result := &DownloadTitleResult{}
+	stats := &downloadStats{}
+	defer stats.fillResult(result)
+	if err := opts.Filter.Validate(); err != nil {
+		return result, err
+	}
+
+	cancelledErr := func(err error) error {
+		if err == ErrCancelled || errors.Is(err, context.Canceled) || isCancelled(progressReporter) || ctx.Err() != nil {
+			return ErrCancelled
+		}
+		return err
+	}
+
+	titleIDStr := fmt.Sprintf("%016x", titleID)
+	tEntry := GetTitleEntryFromTid(titleID)
 
 	if progressReporter != nil {
 		progressReporter.ResetTotals()
 		name := tEntry.Name
 		if name == "" {
-			name = titleID
+			name = titleIDStr
 		}
 		progressReporter.SetGameTitle(name)
 	}
-	if !waitUntilResumed(progressReporter) {
-		return nil
+	if !waitUntilResumed(progressReporter) || ctx.Err() != nil {
+		return result, ErrCancelled
 	}
 
-	outputDir := strings.TrimRight(outputDirectory, "/\\")
-	baseURL := fmt.Sprintf("http://ccs.cdn.c.shop.nintendowifi.net/ccs/download/%s", titleID)
+	outputDir := strings.TrimRight(opts.OutputDirectory, "/\\")
 
 	if err := os.MkdirAll(outputDir, downloadStateDirPerm); err != nil {
-		return err
+		return result, err
 	}
 
 	tmdPath := filepath.Join(outputDir, "title.tmd")
-	if err := downloadFileWithOptions(context.Background(), progressReporter, client, fmt.Sprintf("%s/%s", baseURL, "tmd"), tmdPath, downloadOptions{
+	if err := downloadFromMirrors(ctx, progressReporter, client, titleIDStr, tmdFileName(opts.TitleVersion), tmdPath, downloadOptions{
 		DoRetries:   true,
 		AllowResume: true,
-		UserAgent:   "WiiUDownloader",
+		UserAgent:   userAgent,
+		Stats:       stats,
 		Validate: func(path string) error {
-			return validateTMDFile(path, tid)
+			return validateTMDFile(path, titleID, opts.TitleVersion)
 		},
 	}); err != nil {
-		if isCancelled(progressReporter) || err == errCancel {
-			return nil
-		}
-		return err
+		return result, wrapDiskFull(titleNotFoundError(titleID, cancelledErr(err)))
 	}
 
 	tmdData, err := os.ReadFile(tmdPath)
 	if err != nil {
-		return err
+		return result, err
 	}
 
 	tmd, err := ParseTMD(tmdData)
 	if err != nil {
-		return err
+		return result, err
+	}
+	result.TMD = tmd
+	result.TitleVersion = tmd.TitleVersion
+
+	// The CDN only serves the ticket of the latest version, which carries the
+	// same title key as the older ones.
+	ticketVersion := tmd.TitleVersion
+	if opts.TitleVersion != nil {
+		ticketVersion = 0
 	}
-
 	tikPath := filepath.Join(outputDir, "title.tik")
-	if err := downloadFileWithOptions(context.Background(), progressReporter, client, fmt.Sprintf("%s/%s", baseURL, "cetk"), tikPath, downloadOptions{
+	if err := downloadFromMirrors(ctx, progressReporter, client, titleIDStr, "cetk", tikPath, downloadOptions{
 		DoRetries:   false,
 		AllowResume: true,
-		UserAgent:   "WiiUDownloader",
+		UserAgent:   userAgent,
+		Stats:       stats,
 		Validate: func(path string) error {
-			return validateTicketFile(path, tmd.TitleID, tmd.TitleVersion)
+			return validateTicketFile(path, tmd.TitleID, ticketVersion)
 		},
 	}); err != nil {
-		if isCancelled(progressReporter) || err == errCancel {
-			return nil
+		if cancelledErr(err) == ErrCancelled {
+			return result, ErrCancelled
 		}
 		titleKeyType := uint8(TITLE_KEY_mypass)
-		if tEntry.TitleID == tid {
+		if tEntry.TitleID == titleID {
 			titleKeyType = tEntry.Key
 		}
-		titleKey, err := GenerateKeyWithType(titleID, titleKeyType)
+		titleKey, err := GenerateKeyWithType(titleIDStr, titleKeyType)
 		if err != nil {
-			return err
+			return result, fmt.Errorf("%w: %w", ErrTicketUnavailable, err)
 		}
 		if err := GenerateTicket(tikPath, tmd.TitleID, titleKey, tmd.TitleVersion); err != nil {
-			return err
+			return result, wrapDiskFull(err)
+		}
+		result.TicketGenerated = true
+		stats.addFile(tikPath)
+	}
+
+	// Contents are checked with the title key of a downloaded ticket. A
+	// generated one may hold the wrong key, which would fail every content on
+	// every mirror, so only their sizes are checked then.
+	var cipherHashTree cipher.Block
+	if !result.TicketGenerated {
+		if cipherHashTree, err = titleKeyCipher(outputDir, tmd); err != nil {
+			return result, err
 		}
 	}
 
@@ -531,75 +787,91 @@ func DownloadTitle(titleID, outputDirectory string, doDecryption bool, progressR
 		progressReporter.SetDownloadSize(int64(titleSize))
 	}
 
-	if err := GenerateCert(tmd, filepath.Join(outputDir, "title.cert"), progressReporter, client); err != nil {
-		if isCancelled(progressReporter) || err == errCancel {
-			return nil
-		}
-		return err
+	certPath := filepath.Join(outputDir, "title.cert")
+	if err := generateCert(ctx, tmd, certPath, progressReporter, client, userAgent); err != nil {
+		return result, wrapDiskFull(cancelledErr(err))
 	}
+	stats.addFile(certPath)
 
-	g, ctx := errgroup.WithContext(context.Background())
-	g.SetLimit(maxConcurrentDownloads)
 	if progressReporter != nil {
 		progressReporter.SetStartTime(time.Now())
 	}
 
-	for i := 0; i < int(tmd.ContentCount); i++ {
+	decryptOpts := DecryptContentsOptions{
+		ProgressReporter:        progressReporter,
+		DeleteEncryptedContents: opts.DeleteEncryptedContents,
+		Workers:                 opts.DecryptionWorkers,
+		Filter:                  opts.Filter,
+	}
+	matchingOnly := opts.MatchingContentsOnly && !opts.Filter.IsZero()
+	if opts.Decrypt && opts.DecryptWhileDownloading {
+		if err := downloadAndDecryptContents(ctx, progressReporter, client, titleIDStr, outputDir, tmd, cipherHashTree, concurrency, downloadOptions{
+			UserAgent:   userAgent,
+			Stats:       stats,
+			Connections: connections,
+		}, decryptOpts, matchingOnly); err != nil {
+			return result, wrapDiskFull(cancelledErr(err))
+		}
+		return result, nil
+	}
+
+	indices := make([]int, len(tmd.Contents))
+	for i := range indices {
+		indices[i] = i
+	}
+	if matchingOnly {
+		if err := downloadContentFiles(ctx, progressReporter, client, titleIDStr, outputDir, tmd.Contents[0], cipherHashTree, downloadOptions{
+			UserAgent:   userAgent,
+			Stats:       stats,
+			Connections: connections,
+		}); err != nil {
+			return result, wrapDiskFull(cancelledErr(err))
+		}
+		plan, err := newDecryptPipeline(outputDir, tmd, nil, false, opts.Filter, false)
+		if err != nil {
+			return result, err
+		}
+		indices = plan.contentIndices(true)
+		if progressReporter != nil {
+			progressReporter.SetDownloadSize(contentsDownloadSize(tmd, indices))
+		}
+	}
+
+	g, groupCtx := errgroup.WithContext(ctx)
+	g.SetLimit(concurrency)
+
+	for _, i := range indices {
 		i := i
 		g.Go(func() error {
 			if !waitUntilResumed(progressReporter) {
-				return errCancel
+				return ErrCancelled
 			}
-			content := tmd.Contents[i]
-			filePath := filepath.Join(outputDir, fmt.Sprintf("%08X.app", content.ID))
-			if err := downloadFileWithSemaphoreOptions(ctx, progressReporter, client, fmt.Sprintf("%s/%08X", baseURL, content.ID), filePath, downloadOptions{
-				ExpectedSize: expectedContentDownloadSize(content),
-				DoRetries:    true,
-				AllowResume:  true,
-				UserAgent:    "WiiUDownloader",
+			if err := downloadContentFiles(groupCtx, progressReporter, client, titleIDStr, outputDir, tmd.Contents[i], cipherHashTree, downloadOptions{
+				UserAgent:   userAgent,
+				Stats:       stats,
+				Connections: connections,
 			}); err != nil {
-				if isCancelled(progressReporter) {
-					return errCancel
-				}
-				return err
-			}
-
-			if content.Type&CONTENT_TYPE_HASHED == CONTENT_TYPE_HASHED {
-				filePath = filepath.Join(outputDir, fmt.Sprintf("%08X.h3", content.ID))
-				if err := downloadFileWithSemaphoreOptions(ctx, progressReporter, client, fmt.Sprintf("%s/%08X.h3", baseURL, content.ID), filePath, downloadOptions{
-					ExpectedSize: expectedH3DownloadSize(content),
-					DoRetries:    true,
-					AllowResume:  true,
-					UserAgent:    "WiiUDownloader",
-					Validate: func(path string) error {
-						return verifyH3File(path, content)
-					},
-				}); err != nil {
-					if isCancelled(progressReporter) {
-						return errCancel
-					}
-					return err
-				}
+				return cancelledErr(err)
 			}
 			if isCancelled(progressReporter) {
-				return errCancel
+				return ErrCancelled
 			}
 			return nil
 		})
 	}
 
 	if err := g.Wait(); err != nil {
-		if err == errCancel {
-			return nil
-		}
-		return err
+		return result, wrapDiskFull(cancelledErr(err))
 	}
 
-	if doDecryption && !isCancelled(progressReporter) {
-		if err := DecryptContents(outputDir, progressReporter, deleteEncryptedContents); err != nil {
-			return err
+	if opts.Decrypt {
+		if isCancelled(progressReporter) || ctx.Err() != nil {
+			return result, ErrCancelled
+		}
+		if err := DecryptContentsWithOptions(ctx, outputDir, decryptOpts); err != nil {
+			return result, err
 		}
 	}
 
-	return nil
+	return result, nil
 }
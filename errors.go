@@ -0,0 +1,96 @@
+package wiiudownloader
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+var (
+	// ErrCancelled is returned when a download is cancelled through its
+	// context or progress reporter.
+	ErrCancelled = errors.New("cancelled download")
+	// ErrTitleNotFound is returned when the CDN has no TMD for a title.
+	ErrTitleNotFound = errors.New("title not found on CDN")
+	// ErrTicketUnavailable is returned when a title has no usable ticket and
+	// none could be generated.
+	ErrTicketUnavailable = errors.New("ticket unavailable")
+	// ErrInvalidDecryptionKey is returned when the title key from the ticket
+	// does not decrypt the title.
+	ErrInvalidDecryptionKey = errors.New("invalid decryption key")
+	// ErrInvalidDiscKey is returned when the disc key does not decrypt a
+	// disc image.
+	ErrInvalidDiscKey = errors.New("invalid disc key")
+	// ErrDiskFull is returned when a download or decryption runs out of disk space.
+	ErrDiskFull = errors.New("disk full")
+	// ErrNoFilesMatched is returned when a PathFilter selects none of the
+	// files of a title.
+	ErrNoFilesMatched = errors.New("no files match the path filter")
+	// ErrNoFST is returned by OpenTitleFS for titles without a Wii U file
+	// system table, such as Wii titles.
+	ErrNoFST = errors.New("title has no FST")
+)
+
+// HashMismatchError reports data that does not match its expected hash.
+type HashMismatchError struct {
+	// File is the name of the file that failed verification, e.g. "00000002.app".
+	File      string
+	ContentID uint32
+	// Hash names the hash that did not match, e.g. "H3" or "content".
+	Hash string
+}
+
+func (e *HashMismatchError) Error() string {
+	return fmt.Sprintf("%s (content %08X): %s hash mismatch", e.File, e.ContentID, e.Hash)
+}
+
+// HashTreeError reports a block of a hashed content that breaks the H0-H3
+// hash tree. Level names the hash entry that did not match: "H0" covers the
+// block data, "H1" its H0 table, "H2" its H1 table and "H3" its H2 table.
+type HashTreeError struct {
+	File      string
+	ContentID uint32
+	// Block is the index of the 0x10000 byte block within the content.
+	Block int64
+	Level string
+}
+
+func (e *HashTreeError) Error() string {
+	return fmt.Sprintf("%s (content %08X): %s hash mismatch in block %d", e.File, e.ContentID, e.Level, e.Block)
+}
+
+// Unwrap lets errors.As match a HashTreeError as a HashMismatchError.
+func (e *HashTreeError) Unwrap() error {
+	return &HashMismatchError{File: e.File, ContentID: e.ContentID, Hash: e.Level}
+}
+
+// SizeMismatchError reports a file whose size differs from the expected one.
+type SizeMismatchError struct {
+	File     string
+	Expected int64
+	Actual   int64
+}
+
+func (e *SizeMismatchError) Error() string {
+	return fmt.Sprintf("%s: size mismatch: got %d, want %d", e.File, e.Actual, e.Expected)
+}
+
+func isHTTPStatus(err error, statusCode int) bool {
+	var statusErr *statusError
+	return errors.As(err, &statusErr) && statusErr.StatusCode == statusCode
+}
+
+// wrapDiskFull marks out-of-space errors with ErrDiskFull.
+func wrapDiskFull(err error) error {
+	if err == nil || errors.Is(err, ErrDiskFull) || !isDiskFull(err) {
+		return err
+	}
+	return fmt.Errorf("%w: %w", ErrDiskFull, err)
+}
+
+func titleNotFoundError(titleID uint64, err error) error {
+	if !isHTTPStatus(err, http.StatusNotFound) {
+		return err
+	}
+	return fmt.Errorf("%w: %016x: %w", ErrTitleNotFound, titleID, err)
+}
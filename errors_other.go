@@ -0,0 +1,12 @@
+//go:build !windows
+
+package wiiudownloader
+
+import (
+	"errors"
+	"syscall"
+)
+
+func isDiskFull(err error) bool {
+	return errors.Is(err, syscall.ENOSPC)
+}
@@ -0,0 +1,14 @@
+package wiiudownloader
+
+import (
+	"errors"
+	"syscall"
+)
+
+func isDiskFull(err error) bool {
+	const (
+		ERROR_HANDLE_DISK_FULL = syscall.Errno(39)
+		ERROR_DISK_FULL        = syscall.Errno(112)
+	)
+	return errors.Is(err, ERROR_DISK_FULL) || errors.Is(err, ERROR_HANDLE_DISK_FULL)
+}
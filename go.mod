@@ -6,7 +6,8 @@ require golang.org/x/crypto v0.53.0
 
 require (
 	github.com/jbenet/go-context v0.0.0-20150711004518-d14ea06fba99
+	golang.org/x/net v0.56.0
 	golang.org/x/sync v0.21.0
 )
 
-require golang.org/x/net v0.56.0 // indirect
+require github.com/klauspost/compress v1.18.0
@@ -0,0 +1,172 @@
+package cert
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Xpl0itU/WiiUDownloader/internal/safebin"
+)
+
+const (
+	SignatureRSA4096SHA1   = 0x10000
+	SignatureRSA2048SHA1   = 0x10001
+	SignatureECDSASHA1     = 0x10002
+	SignatureRSA4096SHA256 = 0x10003
+	SignatureRSA2048SHA256 = 0x10004
+	SignatureECDSASHA256   = 0x10005
+)
+
+const (
+	KeyRSA4096 = 0
+	KeyRSA2048 = 1
+	KeyECC     = 2
+)
+
+const (
+	issuerSize = 0x40
+	nameSize   = 0x40
+)
+
+// Certificate is one certificate of a chain like the ones appended to TMDs
+// and tickets or stored in title.cert.
+type Certificate struct {
+	SignatureType uint32
+	Signature     []byte
+	Issuer        string
+	KeyType       uint32
+	Name          string
+	KeyID         uint32
+	PublicKey     []byte
+	// Exponent is only set for RSA keys.
+	Exponent uint32
+	// Raw is the whole certificate.
+	Raw []byte
+}
+
+// FullName returns the name other certificates and signed data use as their
+// issuer, e.g. "Root-CA00000003" for a CA certificate.
+func (c *Certificate) FullName() string {
+	return c.Issuer + "-" + c.Name
+}
+
+// SignatureSize returns the size of a signature of the given type and of the
+// padding that follows it, up to the issuer.
+func SignatureSize(signatureType uint32) (size, padding int, ok bool) {
+	switch signatureType {
+	case SignatureRSA4096SHA1, SignatureRSA4096SHA256:
+		return 0x200, 0x3C, true
+	case SignatureRSA2048SHA1, SignatureRSA2048SHA256:
+		return 0x100, 0x3C, true
+	case SignatureECDSASHA1, SignatureECDSASHA256:
+		return 0x3C, 0x40, true
+	}
+	return 0, 0, false
+}
+
+func keySize(keyType uint32) (size, padding int, ok bool) {
+	switch keyType {
+	case KeyRSA4096:
+		return 0x200, 0x34, true
+	case KeyRSA2048:
+		return 0x100, 0x34, true
+	case KeyECC:
+		return 0x3C, 0x3C, true
+	}
+	return 0, 0, false
+}
+
+// ParseChain parses the certificates stored back to back in data. Trailing
+// zeros, which some chains are padded with, are ignored.
+func ParseChain(data []byte) ([]Certificate, error) {
+	var chain []Certificate
+	offset := 0
+	for offset < len(data) {
+		if allZero(data[offset:]) {
+			break
+		}
+		certificate, size, err := parseCertificate(data[offset:])
+		if err != nil {
+			return nil, fmt.Errorf("certificate at offset %#x: %w", offset, err)
+		}
+		chain = append(chain, certificate)
+		offset += size
+	}
+	return chain, nil
+}
+
+func parseCertificate(data []byte) (Certificate, int, error) {
+	c := safebin.NewCursor(data)
+	var certificate Certificate
+	signatureType, err := c.ReadU32BE()
+	if err != nil {
+		return certificate, 0, err
+	}
+	signatureSize, signaturePadding, ok := SignatureSize(signatureType)
+	if !ok {
+		return certificate, 0, fmt.Errorf("unknown signature type %#x", signatureType)
+	}
+	signature, err := c.ReadBytes(signatureSize)
+	if err != nil {
+		return certificate, 0, err
+	}
+	if _, err := c.ReadBytes(signaturePadding); err != nil {
+		return certificate, 0, err
+	}
+	issuer, err := c.ReadBytes(issuerSize)
+	if err != nil {
+		return certificate, 0, err
+	}
+	keyType, err := c.ReadU32BE()
+	if err != nil {
+		return certificate, 0, err
+	}
+	name, err := c.ReadBytes(nameSize)
+	if err != nil {
+		return certificate, 0, err
+	}
+	keyID, err := c.ReadU32BE()
+	if err != nil {
+		return certificate, 0, err
+	}
+	publicKeySize, keyPadding, ok := keySize(keyType)
+	if !ok {
+		return certificate, 0, fmt.Errorf("unknown key type %d", keyType)
+	}
+	publicKey, err := c.ReadBytes(publicKeySize)
+	if err != nil {
+		return certificate, 0, err
+	}
+	if keyType != KeyECC {
+		if certificate.Exponent, err = c.ReadU32BE(); err != nil {
+			return certificate, 0, err
+		}
+	}
+	if _, err := c.ReadBytes(keyPadding); err != nil {
+		return certificate, 0, err
+	}
+
+	size := c.Pos()
+	certificate.SignatureType = signatureType
+	certificate.Signature = append([]byte(nil), signature...)
+	certificate.Issuer = cString(issuer)
+	certificate.KeyType = keyType
+	certificate.Name = cString(name)
+	certificate.KeyID = keyID
+	certificate.PublicKey = append([]byte(nil), publicKey...)
+	certificate.Raw = append([]byte(nil), data[:size]...)
+	return certificate, size, nil
+}
+
+func cString(data []byte) string {
+	name, _, _ := strings.Cut(string(data), "\x00")
+	return name
+}
+
+func allZero(data []byte) bool {
+	for _, b := range data {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,52 @@
+package cert
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// SignedData returns the part of the certificate its signature covers, from
+// the issuer to the end.
+func (c *Certificate) SignedData() []byte {
+	size, padding, _ := SignatureSize(c.SignatureType)
+	return c.Raw[4+size+padding:]
+}
+
+// VerifySignature checks that signature, of the given type, was made over data
+// with the key of c.
+func (c *Certificate) VerifySignature(signatureType uint32, signature, data []byte) error {
+	if c.KeyType != KeyRSA4096 && c.KeyType != KeyRSA2048 {
+		return fmt.Errorf("certificate %s does not hold an RSA key", c.FullName())
+	}
+	if err := VerifyRSA(c.PublicKey, c.Exponent, signatureType, signature, data); err != nil {
+		return fmt.Errorf("signature does not match certificate %s: %w", c.FullName(), err)
+	}
+	return nil
+}
+
+// VerifyRSA checks that signature, of the given type, was made over data with
+// the RSA key of the given modulus and public exponent.
+func VerifyRSA(modulus []byte, exponent uint32, signatureType uint32, signature, data []byte) error {
+	var hash crypto.Hash
+	var digest []byte
+	switch signatureType {
+	case SignatureRSA4096SHA1, SignatureRSA2048SHA1:
+		sum := sha1.Sum(data)
+		hash, digest = crypto.SHA1, sum[:]
+	case SignatureRSA4096SHA256, SignatureRSA2048SHA256:
+		sum := sha256.Sum256(data)
+		hash, digest = crypto.SHA256, sum[:]
+	default:
+		return fmt.Errorf("unsupported signature type %#x", signatureType)
+	}
+	if size, _, _ := SignatureSize(signatureType); len(modulus) != size || len(signature) != size {
+		return errors.New("key size does not match the signature type")
+	}
+	key := &rsa.PublicKey{N: new(big.Int).SetBytes(modulus), E: int(exponent)}
+	return rsa.VerifyPKCS1v15(key, hash, digest, signature)
+}
@@ -23,8 +23,11 @@ type Table struct {
 	Factor      uint32
 	EntryCount  uint32
 	NamesOffset uint32
-	Entries     []Entry
-	data        []byte
+	// Clusters has one entry per content. Their offsets are only used on
+	// discs, where they locate the contents in the partition.
+	Clusters []Cluster
+	Entries  []Entry
+	data     []byte
 }
 
 func Parse(data []byte) (*Table, error) {
@@ -48,6 +51,14 @@ func Parse(data []byte) (*Table, error) {
 		return nil, fmt.Errorf("invalid FST root offset")
 	}
 
+	clusters := make([]Cluster, 0, entryCount)
+	for i := 0; i < int(entryCount); i++ {
+		cluster, err := parseCluster(c, 0x20+i*clusterSize)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read cluster %d: %w", i, err)
+		}
+		clusters = append(clusters, cluster)
+	}
 	if err := c.Seek(rootOffset + 8); err != nil {
 		return nil, err
 	}
@@ -109,11 +120,36 @@ func Parse(data []byte) (*Table, error) {
 		Factor:      factor,
 		EntryCount:  entryCount,
 		NamesOffset: namesOffset,
+		Clusters:    clusters,
 		Entries:     entries,
 		data:        data,
 	}, nil
 }
 
+func parseCluster(c *safebin.Cursor, offset int) (Cluster, error) {
+	var cluster Cluster
+	if err := c.Seek(offset); err != nil {
+		return cluster, err
+	}
+	var err error
+	if cluster.Offset, err = c.ReadU32BE(); err != nil {
+		return cluster, err
+	}
+	if cluster.Size, err = c.ReadU32BE(); err != nil {
+		return cluster, err
+	}
+	if cluster.OwnerTitleID, err = c.ReadU64BE(); err != nil {
+		return cluster, err
+	}
+	if cluster.GroupID, err = c.ReadU32BE(); err != nil {
+		return cluster, err
+	}
+	if cluster.HashMode, err = c.ReadU8(); err != nil {
+		return cluster, err
+	}
+	return cluster, nil
+}
+
 func (t *Table) NameAt(nameOffset uint32) (string, error) {
 	start := int(t.NamesOffset + nameOffset)
 	if start < 0 || start >= len(t.data) {
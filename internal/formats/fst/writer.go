@@ -0,0 +1,129 @@
+package fst
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+const (
+	headerSize  = 0x20
+	clusterSize = 0x20
+	entrySize   = 0x10
+	maxNameSize = 1 << 24
+)
+
+// Hash modes of a Cluster.
+const (
+	HashModeRaw    = 1
+	HashModeHashed = 2
+)
+
+// Cluster is the secondary header describing one content of the title.
+// Offset and Size are in units of 0x8000 bytes.
+type Cluster struct {
+	Offset       uint32
+	Size         uint32
+	OwnerTitleID uint64
+	GroupID      uint32
+	HashMode     byte
+}
+
+// Node is a file or directory written by Build. The data of a file is Size
+// bytes at Offset of the data of content Content, where Offset must be a
+// multiple of the factor.
+type Node struct {
+	Name     string
+	Dir      bool
+	Children []*Node
+	Content  uint16
+	Offset   uint64
+	Size     uint32
+}
+
+// Build returns an FST with the given offset factor, one cluster per content
+// and the tree below root, whose name is ignored. Children are written in the
+// order given.
+func Build(factor uint32, clusters []Cluster, root *Node) ([]byte, error) {
+	if factor == 0 {
+		return nil, fmt.Errorf("invalid FST factor")
+	}
+	var entries []byte
+	var names bytes.Buffer
+	count := uint32(0)
+
+	// addEntry appends an entry and returns its index.
+	addEntry := func(typ byte, name string, offset, length uint32, contentID uint16) (uint32, error) {
+		nameOffset := uint32(names.Len())
+		names.WriteString(name)
+		names.WriteByte(0)
+		if names.Len() > maxNameSize {
+			return 0, fmt.Errorf("FST name table exceeds %d bytes", maxNameSize)
+		}
+		if count >= maxEntries {
+			return 0, fmt.Errorf("FST exceeds %d entries", maxEntries)
+		}
+		entry := make([]byte, entrySize)
+		entry[0] = typ
+		entry[1] = byte(nameOffset >> 16)
+		entry[2] = byte(nameOffset >> 8)
+		entry[3] = byte(nameOffset)
+		binary.BigEndian.PutUint32(entry[4:], offset)
+		binary.BigEndian.PutUint32(entry[8:], length)
+		binary.BigEndian.PutUint16(entry[14:], contentID)
+		entries = append(entries, entry...)
+		count++
+		return count - 1, nil
+	}
+
+	var add func(dir *Node, index uint32) error
+	add = func(dir *Node, index uint32) error {
+		for _, node := range dir.Children {
+			if !node.Dir {
+				if node.Offset%uint64(factor) != 0 || node.Offset/uint64(factor) > 0xFFFFFFFF {
+					return fmt.Errorf("invalid offset %#x of %q", node.Offset, node.Name)
+				}
+				if int(node.Content) >= len(clusters) {
+					return fmt.Errorf("invalid content %d of %q", node.Content, node.Name)
+				}
+				if _, err := addEntry(0, node.Name, uint32(node.Offset/uint64(factor)), node.Size, node.Content); err != nil {
+					return err
+				}
+				continue
+			}
+			child, err := addEntry(1, node.Name, index, 0, 0)
+			if err != nil {
+				return err
+			}
+			if err := add(node, child); err != nil {
+				return err
+			}
+			// A directory ends before the entry that follows its last child.
+			binary.BigEndian.PutUint32(entries[child*entrySize+8:], count)
+		}
+		return nil
+	}
+	if _, err := addEntry(1, "", 0, 0, 0); err != nil {
+		return nil, err
+	}
+	if err := add(root, 0); err != nil {
+		return nil, err
+	}
+	binary.BigEndian.PutUint32(entries[8:], count)
+
+	out := make([]byte, headerSize, headerSize+len(clusters)*clusterSize+len(entries)+names.Len())
+	copy(out, "FST\x00")
+	binary.BigEndian.PutUint32(out[4:], factor)
+	binary.BigEndian.PutUint32(out[8:], uint32(len(clusters)))
+	for _, cluster := range clusters {
+		data := make([]byte, clusterSize)
+		binary.BigEndian.PutUint32(data[0:], cluster.Offset)
+		binary.BigEndian.PutUint32(data[4:], cluster.Size)
+		binary.BigEndian.PutUint64(data[8:], cluster.OwnerTitleID)
+		binary.BigEndian.PutUint32(data[16:], cluster.GroupID)
+		data[20] = cluster.HashMode
+		out = append(out, data...)
+	}
+	out = append(out, entries...)
+	return append(out, names.Bytes()...), nil
+}
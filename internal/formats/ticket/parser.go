@@ -0,0 +1,199 @@
+package ticket
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/Xpl0itU/WiiUDownloader/internal/formats/cert"
+)
+
+// Limit is a usage limit of a ticket, such as a play time or launch count.
+// A zero Type means the slot is unused.
+type Limit struct {
+	Type  uint32
+	Value uint32
+}
+
+// Ticket is a Wii or Wii U ticket, which grants the rights to a title and
+// carries its title key.
+type Ticket struct {
+	SignatureType uint32
+	Signature     []byte
+	Issuer        string
+	// ECDHData is used to personalise tickets for one console.
+	ECDHData         [ecdhSize]byte
+	Version          byte
+	CACRLVersion     byte
+	SignerCRLVersion byte
+	// TitleKey is the title key encrypted with the common key.
+	TitleKey            [TitleKeySize]byte
+	Reserved2           byte
+	TicketID            uint64
+	ConsoleID           uint32
+	TitleID             uint64
+	SystemAccessMask    uint16
+	TitleVersion        uint16
+	PermittedTitlesMask uint32
+	PermitMask          uint32
+	TitleExportAllowed  byte
+	CommonKeyIndex      byte
+	Reserved            [reservedSize]byte
+	// ContentAccessPermissions has one bit per content index.
+	ContentAccessPermissions [contentAccessSize]byte
+	Padding                  [paddingSize]byte
+	Limits                   [limitCount]Limit
+	// V1 holds the header and sections that version 1 tickets of the Wii U
+	// append, kept as they are. Only version 1 tickets have it.
+	V1 []byte
+	// Certificates holds anything after the ticket, usually the certificates
+	// the CDN appends.
+	Certificates []byte
+
+	// signed holds the bytes the signature covers as Parse found them.
+	signed []byte
+}
+
+// Parse parses the ticket at the start of data.
+func Parse(data []byte) (*Ticket, error) {
+	if len(data) < signatureTypeSize {
+		return nil, errors.New("ticket is too short")
+	}
+	t := &Ticket{SignatureType: binary.BigEndian.Uint32(data)}
+	signatureSize, padding, ok := cert.SignatureSize(t.SignatureType)
+	if !ok {
+		return nil, fmt.Errorf("unknown ticket signature type %#x", t.SignatureType)
+	}
+	start := signatureTypeSize + signatureSize + padding
+	if len(data) < start+bodySize {
+		return nil, fmt.Errorf("ticket is too short: %d bytes", len(data))
+	}
+	t.Signature = append([]byte(nil), data[signatureTypeSize:signatureTypeSize+signatureSize]...)
+
+	body := data[start : start+bodySize]
+	t.Issuer, _, _ = strings.Cut(string(body[issuerOffset:issuerOffset+issuerSize]), "\x00")
+	copy(t.ECDHData[:], body[ecdhOffset:])
+	t.Version = body[versionOffset]
+	t.CACRLVersion = body[caCRLVersionOffset]
+	t.SignerCRLVersion = body[signerCRLVersionOffset]
+	copy(t.TitleKey[:], body[titleKeyOffset:])
+	t.Reserved2 = body[reserved2Offset]
+	t.TicketID = binary.BigEndian.Uint64(body[ticketIDOffset:])
+	t.ConsoleID = binary.BigEndian.Uint32(body[consoleIDOffset:])
+	t.TitleID = binary.BigEndian.Uint64(body[titleIDOffset:])
+	t.SystemAccessMask = binary.BigEndian.Uint16(body[systemAccessMaskOffset:])
+	t.TitleVersion = binary.BigEndian.Uint16(body[titleVersionOffset:])
+	t.PermittedTitlesMask = binary.BigEndian.Uint32(body[permittedTitlesOffset:])
+	t.PermitMask = binary.BigEndian.Uint32(body[permitMaskOffset:])
+	t.TitleExportAllowed = body[titleExportOffset]
+	t.CommonKeyIndex = body[commonKeyIndexOffset]
+	copy(t.Reserved[:], body[reservedOffset:])
+	copy(t.ContentAccessPermissions[:], body[contentAccessOffset:])
+	copy(t.Padding[:], body[paddingOffset:])
+	for i := range t.Limits {
+		limit := body[limitsOffset+i*limitSize:]
+		t.Limits[i] = Limit{Type: binary.BigEndian.Uint32(limit), Value: binary.BigEndian.Uint32(limit[4:])}
+	}
+
+	rest := data[start+bodySize:]
+	switch t.Version {
+	case VersionWii:
+	case VersionWiiU:
+		// Some tools write version 1 tickets without the v1 header, which
+		// are otherwise usable, so anything that is not a valid header is
+		// left in Certificates.
+		if len(rest) >= v1HeaderMinimumSize {
+			size := binary.BigEndian.Uint32(rest[v1TotalSizeOffset:])
+			if size >= v1HeaderMinimumSize && uint64(size) <= uint64(len(rest)) {
+				t.V1 = append([]byte(nil), rest[:size]...)
+				rest = rest[size:]
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unknown ticket version: %d", t.Version)
+	}
+	if len(rest) > 0 {
+		t.Certificates = append([]byte(nil), rest...)
+	}
+	t.signed = append([]byte(nil), data[start:len(data)-len(rest)]...)
+	return t, nil
+}
+
+// Marshal returns the ticket as stored in a title.tik, followed by V1 and the
+// certificates.
+func (t *Ticket) Marshal() ([]byte, error) {
+	signatureSize, padding, ok := cert.SignatureSize(t.SignatureType)
+	if !ok {
+		return nil, fmt.Errorf("unknown ticket signature type %#x", t.SignatureType)
+	}
+	if len(t.Signature) > signatureSize {
+		return nil, fmt.Errorf("ticket signature is %d bytes, expected %d", len(t.Signature), signatureSize)
+	}
+	if len(t.Issuer) >= issuerSize {
+		return nil, fmt.Errorf("ticket issuer %q is too long", t.Issuer)
+	}
+	start := signatureTypeSize + signatureSize + padding
+	data := make([]byte, start+bodySize, start+bodySize+len(t.V1)+len(t.Certificates))
+	binary.BigEndian.PutUint32(data, t.SignatureType)
+	copy(data[signatureTypeSize:], t.Signature)
+
+	body := data[start:]
+	copy(body[issuerOffset:], t.Issuer)
+	copy(body[ecdhOffset:], t.ECDHData[:])
+	body[versionOffset] = t.Version
+	body[caCRLVersionOffset] = t.CACRLVersion
+	body[signerCRLVersionOffset] = t.SignerCRLVersion
+	copy(body[titleKeyOffset:], t.TitleKey[:])
+	body[reserved2Offset] = t.Reserved2
+	binary.BigEndian.PutUint64(body[ticketIDOffset:], t.TicketID)
+	binary.BigEndian.PutUint32(body[consoleIDOffset:], t.ConsoleID)
+	binary.BigEndian.PutUint64(body[titleIDOffset:], t.TitleID)
+	binary.BigEndian.PutUint16(body[systemAccessMaskOffset:], t.SystemAccessMask)
+	binary.BigEndian.PutUint16(body[titleVersionOffset:], t.TitleVersion)
+	binary.BigEndian.PutUint32(body[permittedTitlesOffset:], t.PermittedTitlesMask)
+	binary.BigEndian.PutUint32(body[permitMaskOffset:], t.PermitMask)
+	body[titleExportOffset] = t.TitleExportAllowed
+	body[commonKeyIndexOffset] = t.CommonKeyIndex
+	copy(body[reservedOffset:], t.Reserved[:])
+	copy(body[contentAccessOffset:], t.ContentAccessPermissions[:])
+	copy(body[paddingOffset:], t.Padding[:])
+	for i, limit := range t.Limits {
+		binary.BigEndian.PutUint32(body[limitsOffset+i*limitSize:], limit.Type)
+		binary.BigEndian.PutUint32(body[limitsOffset+i*limitSize+4:], limit.Value)
+	}
+
+	data = append(data, t.V1...)
+	return append(data, t.Certificates...), nil
+}
+
+// Clone returns a deep copy of the ticket to build another ticket from. The
+// copy does not keep the bytes Parse found, so its SignedData follows its
+// fields.
+func (t *Ticket) Clone() *Ticket {
+	clone := *t
+	clone.Signature = bytes.Clone(t.Signature)
+	clone.V1 = bytes.Clone(t.V1)
+	clone.Certificates = bytes.Clone(t.Certificates)
+	clone.signed = nil
+	return &clone
+}
+
+// SignedData returns the part of the ticket its signature covers, from the
+// issuer to the end of V1. For a parsed ticket these are the bytes Parse was
+// given, so fields the model does not keep cannot change them and later
+// changes to the fields are not reflected.
+func (t *Ticket) SignedData() ([]byte, error) {
+	if t.signed != nil {
+		return t.signed, nil
+	}
+	unsigned := *t
+	unsigned.Certificates = nil
+	data, err := unsigned.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	signatureSize, padding, _ := cert.SignatureSize(t.SignatureType)
+	return data[signatureTypeSize+signatureSize+padding:], nil
+}
@@ -0,0 +1,100 @@
+package ticket
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// newTestTicket returns a ticket with every field filled with non-zero bytes,
+// followed by certificates. Only the bytes the model does not keep, the
+// signature padding and what follows the issuer name, are left zero.
+func newTestTicket(version byte) []byte {
+	const signatureSize, padding = 0x100, 0x3C
+	start := signatureTypeSize + signatureSize + padding
+	size := start + bodySize + 0x40
+	if version == VersionWiiU {
+		size += 0x30
+	}
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(i*7 + 3)
+	}
+	binary.BigEndian.PutUint32(data, 0x10004)
+	clear(data[signatureTypeSize+signatureSize : start])
+	body := data[start:]
+	issuer := body[issuerOffset : issuerOffset+issuerSize]
+	clear(issuer)
+	copy(issuer, "Root-CA00000003-XS0000000c")
+	body[versionOffset] = version
+	if version == VersionWiiU {
+		binary.BigEndian.PutUint32(body[bodySize+v1TotalSizeOffset:], 0x30)
+	}
+	return data
+}
+
+func TestMarshalRoundTrip(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		version byte
+	}{
+		{"Wii", VersionWii},
+		{"WiiU", VersionWiiU},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			data := newTestTicket(tc.version)
+			ticket, err := Parse(data)
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+			if (ticket.V1 != nil) != (tc.version == VersionWiiU) || len(ticket.Certificates) != 0x40 {
+				t.Fatalf("Parse kept %d bytes of V1 and %d of certificates", len(ticket.V1), len(ticket.Certificates))
+			}
+			out, err := ticket.Marshal()
+			if err != nil {
+				t.Fatalf("Marshal: %v", err)
+			}
+			if !bytes.Equal(out, data) {
+				t.Fatalf("Marshal(Parse(x)) differs from x: %d bytes, want %d", len(out), len(data))
+			}
+		})
+	}
+}
+
+func TestClone(t *testing.T) {
+	ticket, err := Parse(newTestTicket(VersionWiiU))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	signed, err := ticket.SignedData()
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed = bytes.Clone(signed)
+
+	clone := ticket.Clone()
+	clone.TitleID++
+	clone.Signature[0]++
+	clone.V1[0]++
+	clone.Certificates[0]++
+	if ticket.Signature[0] == clone.Signature[0] || ticket.V1[0] == clone.V1[0] || ticket.Certificates[0] == clone.Certificates[0] {
+		t.Fatal("the clone shares its byte slices with the ticket")
+	}
+	if got, err := ticket.SignedData(); err != nil || !bytes.Equal(got, signed) {
+		t.Fatal("changing the clone changed the signed data of the ticket")
+	}
+
+	// The signed data of the clone follows its fields.
+	cloneSigned, err := clone.SignedData()
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := clone.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := len(out) - len(clone.Certificates) - len(cloneSigned)
+	if !bytes.Equal(cloneSigned, out[start:len(out)-len(clone.Certificates)]) || binary.BigEndian.Uint64(cloneSigned[titleIDOffset:]) != clone.TitleID {
+		t.Fatal("the signed data of the clone does not follow its fields")
+	}
+}
@@ -0,0 +1,43 @@
+package ticket
+
+const (
+	VersionWii  = 0x00
+	VersionWiiU = 0x01
+	// TitleKeySize is the size of the encrypted title key.
+	TitleKeySize = 0x10
+)
+
+// Offsets of the fields that follow the signature, relative to the issuer.
+const (
+	issuerOffset           = 0x00
+	issuerSize             = 0x40
+	ecdhOffset             = 0x40
+	ecdhSize               = 0x3C
+	versionOffset          = 0x7C
+	caCRLVersionOffset     = 0x7D
+	signerCRLVersionOffset = 0x7E
+	titleKeyOffset         = 0x7F
+	reserved2Offset        = 0x8F
+	ticketIDOffset         = 0x90
+	consoleIDOffset        = 0x98
+	titleIDOffset          = 0x9C
+	systemAccessMaskOffset = 0xA4
+	titleVersionOffset     = 0xA6
+	permittedTitlesOffset  = 0xA8
+	permitMaskOffset       = 0xAC
+	titleExportOffset      = 0xB0
+	commonKeyIndexOffset   = 0xB1
+	reservedOffset         = 0xB2
+	reservedSize           = 0x30
+	contentAccessOffset    = 0xE2
+	contentAccessSize      = 0x40
+	paddingOffset          = 0x122
+	paddingSize            = 0x02
+	limitsOffset           = 0x124
+	limitCount             = 8
+	limitSize              = 8
+	bodySize               = 0x164
+	v1TotalSizeOffset      = 0x04
+	v1HeaderMinimumSize    = 0x14
+	signatureTypeSize      = 4
+)
@@ -1,7 +1,11 @@
 package tmd
 
 import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"strings"
 
 	"github.com/Xpl0itU/WiiUDownloader/internal/safebin"
 )
@@ -14,14 +18,50 @@ type Content struct {
 	Hash  []byte
 }
 
+// ContentInfo is a content info record of a Wii U TMD. It covers
+// CommandCount content records starting at IndexOffset, and Hash is the
+// SHA-256 of those records.
+type ContentInfo struct {
+	IndexOffset  uint16
+	CommandCount uint16
+	Hash         [sha256.Size]byte
+}
+
 type Metadata struct {
-	TitleID      uint64
-	Version      byte
-	TitleVersion uint16
-	ContentCount uint16
-	Contents     []Content
-	Certificate1 []byte
-	Certificate2 []byte
+	SignatureType    uint32
+	Signature        []byte
+	Issuer           string
+	Version          byte
+	CACRLVersion     byte
+	SignerCRLVersion byte
+	// VWii is set in the TMDs of vWii titles.
+	VWii byte
+	// SystemVersion is the title ID of the IOS or OS the title runs on.
+	SystemVersion uint64
+	TitleID       uint64
+	TitleType     uint32
+	GroupID       uint16
+	Reserved0     [reserved0Size]byte
+	Region        uint16
+	Ratings       [ratingsSize]byte
+	Reserved1     [reserved1Size]byte
+	IPCMask       [ipcMaskSize]byte
+	Reserved2     [reserved2Size]byte
+	AccessRights  uint32
+	TitleVersion  uint16
+	ContentCount  uint16
+	BootIndex     uint16
+	Reserved3     [reserved3Size]byte
+	// ContentInfoHash and ContentInfo are only set for Wii U TMDs.
+	// ContentInfoHash is the SHA-256 of every content info record.
+	ContentInfoHash [sha256.Size]byte
+	ContentInfo     [ContentInfoCount]ContentInfo
+	Contents        []Content
+	Certificate1    []byte
+	Certificate2    []byte
+
+	// signed holds the bytes the signature covers as Parse found them.
+	signed []byte
 }
 
 func Parse(data []byte) (*Metadata, error) {
@@ -47,16 +87,21 @@ func Parse(data []byte) (*Metadata, error) {
 		if err := parseCertificates(c, m, wiiContentStart, wiiContentStride, int(m.ContentCount), wiiHashSize, true); err != nil {
 			return nil, err
 		}
+		m.signed = append([]byte(nil), data[issuerOffset:wiiContentStart+int(m.ContentCount)*wiiContentStride]...)
 	case VersionWiiU:
 		if err := parseHeader(c, m); err != nil {
 			return nil, err
 		}
+		if err := parseContentInfo(c, m); err != nil {
+			return nil, err
+		}
 		if err := parseContents(c, m, wiiuContentStart, wiiuContentStride, wiiuHashSize); err != nil {
 			return nil, err
 		}
 		if err := parseCertificates(c, m, wiiuContentStart, wiiuContentStride, int(m.ContentCount), wiiuHashSize, false); err != nil {
 			return nil, err
 		}
+		m.signed = append([]byte(nil), data[issuerOffset:wiiuContentInfoOffset]...)
 	default:
 		return nil, fmt.Errorf("unknown TMD version: %d", version)
 	}
@@ -64,28 +109,50 @@ func Parse(data []byte) (*Metadata, error) {
 }
 
 func parseHeader(c *safebin.Cursor, m *Metadata) error {
-	if err := c.Seek(titleIDOffset); err != nil {
-		return err
-	}
-	titleID, err := c.ReadU64BE()
+	header, err := c.Slice(0, headerSize)
 	if err != nil {
 		return err
 	}
-	m.TitleID = titleID
+	m.SignatureType = binary.BigEndian.Uint32(header[signatureTypeOffset:])
+	m.Signature = append([]byte(nil), header[signatureOffset:signatureOffset+signatureSize]...)
+	m.Issuer, _, _ = strings.Cut(string(header[issuerOffset:issuerOffset+issuerSize]), "\x00")
+	m.CACRLVersion = header[caCRLVersionOffset]
+	m.SignerCRLVersion = header[signerCRLVersionOffset]
+	m.VWii = header[vWiiOffset]
+	m.SystemVersion = binary.BigEndian.Uint64(header[systemVersionOffset:])
+	m.TitleID = binary.BigEndian.Uint64(header[titleIDOffset:])
+	m.TitleType = binary.BigEndian.Uint32(header[titleTypeOffset:])
+	m.GroupID = binary.BigEndian.Uint16(header[groupIDOffset:])
+	copy(m.Reserved0[:], header[reserved0Offset:])
+	m.Region = binary.BigEndian.Uint16(header[regionOffset:])
+	copy(m.Ratings[:], header[ratingsOffset:])
+	copy(m.Reserved1[:], header[reserved1Offset:])
+	copy(m.IPCMask[:], header[ipcMaskOffset:])
+	copy(m.Reserved2[:], header[reserved2Offset:])
+	m.AccessRights = binary.BigEndian.Uint32(header[accessRightsOffset:])
+	m.TitleVersion = binary.BigEndian.Uint16(header[titleVersionOffset:])
+	m.ContentCount = binary.BigEndian.Uint16(header[contentCountOffset:])
+	m.BootIndex = binary.BigEndian.Uint16(header[bootIndexOffset:])
+	copy(m.Reserved3[:], header[reserved3Offset:])
+	return nil
+}
 
-	if err := c.Seek(titleVersionOffset); err != nil {
-		return err
-	}
-	titleVersion, err := c.ReadU16BE()
+func parseContentInfo(c *safebin.Cursor, m *Metadata) error {
+	hash, err := c.Slice(wiiuContentInfoHashOffset, sha256.Size)
 	if err != nil {
 		return err
 	}
-	contentCount, err := c.ReadU16BE()
+	copy(m.ContentInfoHash[:], hash)
+	infos, err := c.Slice(wiiuContentInfoOffset, ContentInfoCount*wiiuContentInfoSize)
 	if err != nil {
 		return err
 	}
-	m.TitleVersion = titleVersion
-	m.ContentCount = contentCount
+	for i := range m.ContentInfo {
+		info := infos[i*wiiuContentInfoSize:]
+		m.ContentInfo[i].IndexOffset = binary.BigEndian.Uint16(info[0:])
+		m.ContentInfo[i].CommandCount = binary.BigEndian.Uint16(info[2:])
+		copy(m.ContentInfo[i].Hash[:], info[4:])
+	}
 	return nil
 }
 
@@ -145,15 +212,15 @@ func parseCertificates(c *safebin.Cursor, m *Metadata, start, stride, count, has
 		}
 		return nil
 	}
-	if required || c.Remaining() >= 0x400 {
-		b, err := c.ReadBytes(0x400)
+	if required || c.Remaining() >= certificate1Size {
+		b, err := c.ReadBytes(certificate1Size)
 		if err != nil {
 			return err
 		}
 		m.Certificate1 = append([]byte(nil), b...)
 	}
-	if required || c.Remaining() >= 0x300 {
-		b, err := c.ReadBytes(0x300)
+	if required || c.Remaining() >= certificate2Size {
+		b, err := c.ReadBytes(certificate2Size)
 		if err != nil {
 			return err
 		}
@@ -161,3 +228,168 @@ func parseCertificates(c *safebin.Cursor, m *Metadata, start, stride, count, has
 	}
 	return nil
 }
+
+// Marshal returns the TMD as stored in a title.tmd, followed by the
+// certificates. The content count is taken from Contents rather than
+// ContentCount.
+func (m *Metadata) Marshal() ([]byte, error) {
+	var start, stride, hashSize int
+	switch m.Version {
+	case VersionWii:
+		start, stride, hashSize = wiiContentStart, wiiContentStride, wiiHashSize
+	case VersionWiiU:
+		start, stride, hashSize = wiiuContentStart, wiiuContentStride, wiiuHashSize
+	default:
+		return nil, fmt.Errorf("unknown TMD version: %d", m.Version)
+	}
+	if len(m.Signature) > signatureSize {
+		return nil, fmt.Errorf("TMD signature is %d bytes, expected %d", len(m.Signature), signatureSize)
+	}
+	if len(m.Issuer) >= issuerSize {
+		return nil, fmt.Errorf("TMD issuer %q is too long", m.Issuer)
+	}
+	if len(m.Contents) > 0xFFFF {
+		return nil, fmt.Errorf("TMD has too many contents: %d", len(m.Contents))
+	}
+	records, err := m.marshalContents(stride, hashSize)
+	if err != nil {
+		return nil, err
+	}
+
+	data := make([]byte, start, start+len(records)+len(m.Certificate1)+len(m.Certificate2))
+	binary.BigEndian.PutUint32(data[signatureTypeOffset:], m.SignatureType)
+	copy(data[signatureOffset:], m.Signature)
+	copy(data[issuerOffset:], m.Issuer)
+	data[versionOffset] = m.Version
+	data[caCRLVersionOffset] = m.CACRLVersion
+	data[signerCRLVersionOffset] = m.SignerCRLVersion
+	data[vWiiOffset] = m.VWii
+	binary.BigEndian.PutUint64(data[systemVersionOffset:], m.SystemVersion)
+	binary.BigEndian.PutUint64(data[titleIDOffset:], m.TitleID)
+	binary.BigEndian.PutUint32(data[titleTypeOffset:], m.TitleType)
+	binary.BigEndian.PutUint16(data[groupIDOffset:], m.GroupID)
+	copy(data[reserved0Offset:], m.Reserved0[:])
+	binary.BigEndian.PutUint16(data[regionOffset:], m.Region)
+	copy(data[ratingsOffset:], m.Ratings[:])
+	copy(data[reserved1Offset:], m.Reserved1[:])
+	copy(data[ipcMaskOffset:], m.IPCMask[:])
+	copy(data[reserved2Offset:], m.Reserved2[:])
+	binary.BigEndian.PutUint32(data[accessRightsOffset:], m.AccessRights)
+	binary.BigEndian.PutUint16(data[titleVersionOffset:], m.TitleVersion)
+	binary.BigEndian.PutUint16(data[contentCountOffset:], uint16(len(m.Contents)))
+	binary.BigEndian.PutUint16(data[bootIndexOffset:], m.BootIndex)
+	copy(data[reserved3Offset:], m.Reserved3[:])
+	if m.Version == VersionWiiU {
+		copy(data[wiiuContentInfoHashOffset:], m.ContentInfoHash[:])
+		copy(data[wiiuContentInfoOffset:], m.marshalContentInfo())
+	}
+
+	data = append(data, records...)
+	data = append(data, m.Certificate1...)
+	return append(data, m.Certificate2...), nil
+}
+
+// UpdateContentInfo replaces the content info records of a Wii U TMD with a
+// single one covering every content and updates the hashes to match, as
+// needed once Contents has changed.
+func (m *Metadata) UpdateContentInfo() error {
+	if m.Version != VersionWiiU {
+		return errors.New("only Wii U TMDs have content info records")
+	}
+	records, err := m.marshalContents(wiiuContentStride, wiiuHashSize)
+	if err != nil {
+		return err
+	}
+	m.ContentInfo = [ContentInfoCount]ContentInfo{{
+		CommandCount: uint16(len(m.Contents)),
+		Hash:         sha256.Sum256(records),
+	}}
+	m.ContentInfoHash = sha256.Sum256(m.marshalContentInfo())
+	return nil
+}
+
+func (m *Metadata) marshalContents(stride, hashSize int) ([]byte, error) {
+	records := make([]byte, len(m.Contents)*stride)
+	for i, content := range m.Contents {
+		if len(content.Hash) > hashSize {
+			return nil, fmt.Errorf("hash of content %08X is %d bytes, expected %d", content.ID, len(content.Hash), hashSize)
+		}
+		record := records[i*stride:]
+		binary.BigEndian.PutUint32(record[0:], content.ID)
+		copy(record[4:6], content.Index[:])
+		binary.BigEndian.PutUint16(record[6:], content.Type)
+		binary.BigEndian.PutUint64(record[8:], content.Size)
+		copy(record[16:], content.Hash)
+	}
+	return records, nil
+}
+
+func (m *Metadata) marshalContentInfo() []byte {
+	infos := make([]byte, ContentInfoCount*wiiuContentInfoSize)
+	for i, info := range m.ContentInfo {
+		record := infos[i*wiiuContentInfoSize:]
+		binary.BigEndian.PutUint16(record[0:], info.IndexOffset)
+		binary.BigEndian.PutUint16(record[2:], info.CommandCount)
+		copy(record[4:], info.Hash[:])
+	}
+	return infos
+}
+
+// SignedData returns the part of the TMD its signature covers: the header from
+// the issuer on and, for Wii TMDs, the content records. The content records of
+// Wii U TMDs are covered through ContentInfoHash instead, see
+// VerifyContentInfo. For a parsed TMD these are the bytes Parse was given, so
+// later changes to the fields are not reflected.
+func (m *Metadata) SignedData() ([]byte, error) {
+	if m.signed != nil {
+		return m.signed, nil
+	}
+	unsigned := *m
+	unsigned.Certificate1, unsigned.Certificate2 = nil, nil
+	data, err := unsigned.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	if m.Version == VersionWiiU {
+		return data[issuerOffset:wiiuContentInfoOffset], nil
+	}
+	return data[issuerOffset:], nil
+}
+
+// VerifyContentInfo checks the hashes that tie the content records of a Wii U
+// TMD to its signed header, and that every content record is covered by them.
+// Wii TMDs have nothing to check as their signature covers the content records.
+func (m *Metadata) VerifyContentInfo() error {
+	if m.Version != VersionWiiU {
+		return nil
+	}
+	if sha256.Sum256(m.marshalContentInfo()) != m.ContentInfoHash {
+		return errors.New("content info hash mismatch")
+	}
+	records, err := m.marshalContents(wiiuContentStride, wiiuHashSize)
+	if err != nil {
+		return err
+	}
+	covered := make([]bool, len(m.Contents))
+	for i, info := range m.ContentInfo {
+		if info.CommandCount == 0 {
+			continue
+		}
+		start, end := int(info.IndexOffset), int(info.IndexOffset)+int(info.CommandCount)
+		if end > len(m.Contents) {
+			return fmt.Errorf("content info record %d covers missing content records", i)
+		}
+		if sha256.Sum256(records[start*wiiuContentStride:end*wiiuContentStride]) != info.Hash {
+			return fmt.Errorf("content info record %d hash mismatch", i)
+		}
+		for j := start; j < end; j++ {
+			covered[j] = true
+		}
+	}
+	for i, ok := range covered {
+		if !ok {
+			return fmt.Errorf("content %08X is not covered by a content info record", m.Contents[i].ID)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package tmd
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// newTestTMD returns a TMD with every field filled with non-zero bytes,
+// followed by certificates. Only the bytes the model does not keep, the
+// signature padding and what follows the issuer name, are left zero.
+func newTestTMD(version byte, contentCount int) []byte {
+	start, stride := wiiContentStart, wiiContentStride
+	if version == VersionWiiU {
+		start, stride = wiiuContentStart, wiiuContentStride
+	}
+	size := start + contentCount*stride + certificate1Size + certificate2Size
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(i*7 + 3)
+	}
+	binary.BigEndian.PutUint32(data[signatureTypeOffset:], 0x10004)
+	clear(data[signatureOffset+signatureSize : issuerOffset])
+	issuer := data[issuerOffset : issuerOffset+issuerSize]
+	clear(issuer)
+	copy(issuer, "Root-CA00000003-CP0000000b")
+	data[versionOffset] = version
+	binary.BigEndian.PutUint16(data[contentCountOffset:], uint16(contentCount))
+	return data
+}
+
+func TestMarshalRoundTrip(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		version byte
+	}{
+		{"Wii", VersionWii},
+		{"WiiU", VersionWiiU},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			data := newTestTMD(tc.version, 3)
+			m, err := Parse(data)
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+			if len(m.Contents) != 3 || m.Certificate1 == nil || m.Certificate2 == nil {
+				t.Fatalf("Parse kept %d contents and certificates %v/%v", len(m.Contents), m.Certificate1 != nil, m.Certificate2 != nil)
+			}
+			out, err := m.Marshal()
+			if err != nil {
+				t.Fatalf("Marshal: %v", err)
+			}
+			if !bytes.Equal(out, data) {
+				t.Fatalf("Marshal(Parse(x)) differs from x: %d bytes, want %d", len(out), len(data))
+			}
+		})
+	}
+}
+
+func TestUpdateContentInfo(t *testing.T) {
+	m, err := Parse(newTestTMD(VersionWiiU, 3))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	m.Contents[1].Size++
+	m.Contents = append(m.Contents, Content{ID: 3, Index: [2]byte{0, 3}, Type: 0x2001, Size: 0x8000, Hash: make([]byte, wiiuHashSize)})
+	if err := m.VerifyContentInfo(); err == nil {
+		t.Fatal("VerifyContentInfo passed for changed content records")
+	}
+	if err := m.UpdateContentInfo(); err != nil {
+		t.Fatalf("UpdateContentInfo: %v", err)
+	}
+	if err := m.VerifyContentInfo(); err != nil {
+		t.Fatalf("VerifyContentInfo after UpdateContentInfo: %v", err)
+	}
+
+	out, err := m.Marshal()
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	parsed, err := Parse(out)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if len(parsed.Contents) != 4 || parsed.Contents[1].Size != m.Contents[1].Size {
+		t.Fatalf("content records were not written: %+v", parsed.Contents)
+	}
+	if err := parsed.VerifyContentInfo(); err != nil {
+		t.Fatalf("VerifyContentInfo after Marshal: %v", err)
+	}
+}
@@ -5,11 +5,41 @@ const (
 	VersionWiiU = 0x01
 )
 
+// ContentInfoCount is the number of content info records in a Wii U TMD.
+const ContentInfoCount = 64
+
 const (
-	versionOffset      = 0x180
-	titleIDOffset      = 0x18C
-	titleVersionOffset = 0x1DC
-	contentCountOffset = 0x1DE
+	signatureTypeOffset    = 0x000
+	signatureOffset        = 0x004
+	signatureSize          = 0x100
+	issuerOffset           = 0x140
+	issuerSize             = 0x40
+	versionOffset          = 0x180
+	caCRLVersionOffset     = 0x181
+	signerCRLVersionOffset = 0x182
+	vWiiOffset             = 0x183
+	systemVersionOffset    = 0x184
+	titleIDOffset          = 0x18C
+	titleTypeOffset        = 0x194
+	groupIDOffset          = 0x198
+	reserved0Offset        = 0x19A
+	reserved0Size          = 0x02
+	regionOffset           = 0x19C
+	ratingsOffset          = 0x19E
+	ratingsSize            = 0x10
+	reserved1Offset        = 0x1AE
+	reserved1Size          = 0x0C
+	ipcMaskOffset          = 0x1BA
+	ipcMaskSize            = 0x0C
+	reserved2Offset        = 0x1C6
+	reserved2Size          = 0x12
+	accessRightsOffset     = 0x1D8
+	titleVersionOffset     = 0x1DC
+	contentCountOffset     = 0x1DE
+	bootIndexOffset        = 0x1E0
+	reserved3Offset        = 0x1E2
+	reserved3Size          = 0x02
+	headerSize             = 0x1E4
 )
 
 const (
@@ -19,7 +49,15 @@ const (
 )
 
 const (
-	wiiuContentStart  = 0xB04
-	wiiuContentStride = 0x30
-	wiiuHashSize      = 0x20
+	wiiuContentInfoHashOffset = 0x1E4
+	wiiuContentInfoOffset     = 0x204
+	wiiuContentInfoSize       = 0x24
+	wiiuContentStart          = 0xB04
+	wiiuContentStride         = 0x30
+	wiiuHashSize              = 0x20
+)
+
+const (
+	certificate1Size = 0x400
+	certificate2Size = 0x300
 )
@@ -4,6 +4,7 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
@@ -14,6 +15,8 @@ const (
 	MaxNodes     = 100000
 	MaxFileSize  = 100 * 1024 * 1024
 	MaxStackSize = 4096
+	// The string table is kept in memory, so it is capped like the node table.
+	MaxStringTableSize = 16 * 1024 * 1024
 )
 
 type Node struct {
@@ -24,63 +27,82 @@ type Node struct {
 }
 
 type Archive struct {
-	Data             []byte
 	RootNodeOffset   uint32
 	HeaderSize       uint32
 	DataOffset       uint32
 	Nodes            []Node
 	StringTable      []byte
 	StringTableStart uint32
+
+	r    io.ReaderAt
+	size int64
 }
 
-func Parse(data []byte) (*Archive, error) {
-	if len(data) < 16 {
+// Parse reads the header, node table and string table of the U8 archive
+// that starts at offset 0 of r and is at most size bytes long. File data is
+// left in r and only read by Extract.
+func Parse(r io.ReaderAt, size int64) (*Archive, error) {
+	header := make([]byte, 16)
+	if size < 16 {
 		return nil, errors.New("invalid U8 header: too short")
 	}
-	magic := binary.BigEndian.Uint32(data[0:4])
+	if _, err := r.ReadAt(header, 0); err != nil {
+		return nil, fmt.Errorf("failed to read U8 header: %w", err)
+	}
+	magic := binary.BigEndian.Uint32(header[0:4])
 	if magic != Magic {
 		return nil, errors.New("invalid U8 magic")
 	}
-	rootNodeOffset := binary.BigEndian.Uint32(data[4:8])
-	headerSize := binary.BigEndian.Uint32(data[8:12])
-	dataOffset := binary.BigEndian.Uint32(data[12:16])
-	if rootNodeOffset < 16 || int(rootNodeOffset)+12 > len(data) {
+	rootNodeOffset := binary.BigEndian.Uint32(header[4:8])
+	headerSize := binary.BigEndian.Uint32(header[8:12])
+	dataOffset := binary.BigEndian.Uint32(header[12:16])
+	if rootNodeOffset < 16 || int64(rootNodeOffset)+12 > size {
 		return nil, fmt.Errorf("invalid U8 root node offset: %d", rootNodeOffset)
 	}
-	if dataOffset < rootNodeOffset || int(dataOffset) > len(data) {
+	if dataOffset < rootNodeOffset || int64(dataOffset) > size {
 		return nil, fmt.Errorf("invalid U8 data offset: %d", dataOffset)
 	}
 
-	root := parseNode(data[rootNodeOffset : rootNodeOffset+12])
+	rootData := make([]byte, 12)
+	if _, err := r.ReadAt(rootData, int64(rootNodeOffset)); err != nil {
+		return nil, fmt.Errorf("failed to read U8 root node: %w", err)
+	}
+	root := parseNode(rootData)
 	totalNodes := root.Size
 	if totalNodes == 0 || totalNodes > MaxNodes {
 		return nil, fmt.Errorf("invalid U8 node count: %d", totalNodes)
 	}
 
 	nodeTableSize := totalNodes * 12
-	if nodeTableSize > uint32(len(data)) || rootNodeOffset+nodeTableSize > dataOffset || int(rootNodeOffset+nodeTableSize) > len(data) {
+	if rootNodeOffset+nodeTableSize > dataOffset || int64(rootNodeOffset+nodeTableSize) > size {
 		return nil, fmt.Errorf("invalid U8 node table bounds")
 	}
-	nodes := make([]Node, totalNodes)
-	for i := uint32(0); i < totalNodes; i++ {
-		start := rootNodeOffset + i*12
-		nodes[i] = parseNode(data[start : start+12])
-	}
-
 	stringTableStart := rootNodeOffset + nodeTableSize
 	stringTableSize := dataOffset - stringTableStart
-	if int(stringTableStart) > len(data) || int(stringTableStart+stringTableSize) > len(data) {
+	if stringTableSize > MaxStringTableSize {
 		return nil, fmt.Errorf("invalid U8 string table bounds")
 	}
 
+	// The string table directly follows the node table.
+	tables := make([]byte, nodeTableSize+stringTableSize)
+	if _, err := r.ReadAt(tables, int64(rootNodeOffset)); err != nil {
+		return nil, fmt.Errorf("failed to read U8 node table: %w", err)
+	}
+	nodes := make([]Node, totalNodes)
+	for i := uint32(0); i < totalNodes; i++ {
+		start := i * 12
+		nodes[i] = parseNode(tables[start : start+12])
+	}
+
 	return &Archive{
-		Data:             data,
 		RootNodeOffset:   rootNodeOffset,
 		HeaderSize:       headerSize,
 		DataOffset:       dataOffset,
 		Nodes:            nodes,
-		StringTable:      append([]byte(nil), data[stringTableStart:stringTableStart+stringTableSize]...),
+		StringTable:      tables[nodeTableSize:],
 		StringTableStart: stringTableStart,
+		r:                r,
+		size:             size,
 	}, nil
 }
 
@@ -108,8 +130,10 @@ func (a *Archive) Name(node Node) (string, error) {
 	return string(a.StringTable[start:end]), nil
 }
 
-func Extract(data []byte, outputPath string) error {
-	archive, err := Parse(data)
+// Extract writes the files of the U8 archive at offset 0 of r below
+// outputPath, copying each file straight from r.
+func Extract(r io.ReaderAt, size int64, outputPath string) error {
+	archive, err := Parse(r, size)
 	if err != nil {
 		return err
 	}
@@ -152,14 +176,14 @@ func Extract(data []byte, outputPath string) error {
 				continue
 			}
 			end := node.DataOffset + node.Size
-			if end < node.DataOffset || int(end) > len(archive.Data) {
+			if end < node.DataOffset || int64(end) > archive.size {
 				return fmt.Errorf("U8 file node out of bounds")
 			}
 			targetPath, err := safeJoin(outputPath, currentDir, cleanName)
 			if err != nil {
 				return err
 			}
-			if err := os.WriteFile(targetPath, archive.Data[node.DataOffset:end], 0o644); err != nil {
+			if err := archive.writeFile(targetPath, node); err != nil {
 				return err
 			}
 		}
@@ -173,6 +197,18 @@ func Extract(data []byte, outputPath string) error {
 	return nil
 }
 
+func (a *Archive) writeFile(targetPath string, node Node) error {
+	dst, err := os.Create(targetPath)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, io.NewSectionReader(a.r, int64(node.DataOffset), int64(node.Size))); err != nil {
+		dst.Close()
+		return err
+	}
+	return dst.Close()
+}
+
 func sanitizeName(name string) (string, error) {
 	if name == "" || name == "." {
 		return "", fmt.Errorf("invalid empty U8 name")
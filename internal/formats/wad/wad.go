@@ -0,0 +1,115 @@
+package wad
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+const (
+	HeaderSize = 0x20
+	// TypeInstallable is the type of WADs holding a title to install, "Is".
+	TypeInstallable = 0x4973
+	// TypeBoot2 is the type of WADs holding boot2, "ib".
+	TypeBoot2 = 0x6962
+	// Alignment is the alignment of every section of a WAD.
+	Alignment = 0x40
+)
+
+// Header is the header of a WAD, which gives the size of every section. The
+// sections follow the header in this order, each starting at a multiple of
+// Alignment.
+type Header struct {
+	HeaderSize    uint32
+	Type          uint16
+	Version       uint16
+	CertChainSize uint32
+	CRLSize       uint32
+	TicketSize    uint32
+	TMDSize       uint32
+	DataSize      uint32
+	FooterSize    uint32
+}
+
+// Parse parses the header at the start of data.
+func Parse(data []byte) (*Header, error) {
+	if len(data) < HeaderSize {
+		return nil, errors.New("invalid WAD header: too short")
+	}
+	h := &Header{
+		HeaderSize:    binary.BigEndian.Uint32(data[0x00:]),
+		Type:          binary.BigEndian.Uint16(data[0x04:]),
+		Version:       binary.BigEndian.Uint16(data[0x06:]),
+		CertChainSize: binary.BigEndian.Uint32(data[0x08:]),
+		CRLSize:       binary.BigEndian.Uint32(data[0x0C:]),
+		TicketSize:    binary.BigEndian.Uint32(data[0x10:]),
+		TMDSize:       binary.BigEndian.Uint32(data[0x14:]),
+		DataSize:      binary.BigEndian.Uint32(data[0x18:]),
+		FooterSize:    binary.BigEndian.Uint32(data[0x1C:]),
+	}
+	if h.HeaderSize != HeaderSize {
+		return nil, fmt.Errorf("invalid WAD header size %#x", h.HeaderSize)
+	}
+	if h.Type != TypeInstallable && h.Type != TypeBoot2 {
+		return nil, fmt.Errorf("unknown WAD type %#x", h.Type)
+	}
+	return h, nil
+}
+
+// Marshal returns the header as stored in a WAD.
+func (h *Header) Marshal() []byte {
+	data := make([]byte, HeaderSize)
+	binary.BigEndian.PutUint32(data[0x00:], h.HeaderSize)
+	binary.BigEndian.PutUint16(data[0x04:], h.Type)
+	binary.BigEndian.PutUint16(data[0x06:], h.Version)
+	binary.BigEndian.PutUint32(data[0x08:], h.CertChainSize)
+	binary.BigEndian.PutUint32(data[0x0C:], h.CRLSize)
+	binary.BigEndian.PutUint32(data[0x10:], h.TicketSize)
+	binary.BigEndian.PutUint32(data[0x14:], h.TMDSize)
+	binary.BigEndian.PutUint32(data[0x18:], h.DataSize)
+	binary.BigEndian.PutUint32(data[0x1C:], h.FooterSize)
+	return data
+}
+
+// CertChainOffset returns the offset of the certificate chain.
+func (h *Header) CertChainOffset() int64 {
+	return Align(int64(h.HeaderSize))
+}
+
+// CRLOffset returns the offset of the certificate revocation list, which is
+// empty in every WAD seen in practice.
+func (h *Header) CRLOffset() int64 {
+	return h.CertChainOffset() + Align(int64(h.CertChainSize))
+}
+
+// TicketOffset returns the offset of the ticket.
+func (h *Header) TicketOffset() int64 {
+	return h.CRLOffset() + Align(int64(h.CRLSize))
+}
+
+// TMDOffset returns the offset of the TMD.
+func (h *Header) TMDOffset() int64 {
+	return h.TicketOffset() + Align(int64(h.TicketSize))
+}
+
+// DataOffset returns the offset of the encrypted contents. Each content is
+// stored in TMD order, padded to Alignment.
+func (h *Header) DataOffset() int64 {
+	return h.TMDOffset() + Align(int64(h.TMDSize))
+}
+
+// FooterOffset returns the offset of the footer, which holds arbitrary data
+// like the banner of the title.
+func (h *Header) FooterOffset() int64 {
+	return h.DataOffset() + Align(int64(h.DataSize))
+}
+
+// Size returns the size of the whole WAD.
+func (h *Header) Size() int64 {
+	return h.FooterOffset() + Align(int64(h.FooterSize))
+}
+
+// Align rounds size up to a multiple of Alignment.
+func Align(size int64) int64 {
+	return (size + Alignment - 1) &^ (Alignment - 1)
+}
@@ -0,0 +1,122 @@
+package wud
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	// SectorSize is the size of a disc sector, the unit partitions are laid
+	// out in.
+	SectorSize = 0x8000
+	// Magic0 and Magic1 start a WUX file, "WUX0" followed by a constant.
+	Magic0 = 0x30585557
+	Magic1 = 0x1099D02E
+	// MaxWUXSectorSize bounds the sector size of a WUX file, whose sectors
+	// are read whole.
+	MaxWUXSectorSize = 1 << 20
+)
+
+const wuxHeaderSize = 0x20
+
+// Image is a Wii U disc image, either a plain .wud dump or a .wux one where
+// identical sectors are stored once. Reading a WUX image returns the data of
+// the plain dump it was made from.
+type Image struct {
+	r    io.ReaderAt
+	size int64
+
+	// The rest is only set for WUX images.
+	sectorSize int64
+	dataOffset int64
+	sectors    []uint32
+}
+
+// Open opens the disc image in r, which is size bytes long.
+func Open(r io.ReaderAt, size int64) (*Image, error) {
+	header := make([]byte, wuxHeaderSize)
+	if size >= wuxHeaderSize {
+		if _, err := r.ReadAt(header, 0); err != nil {
+			return nil, fmt.Errorf("failed to read disc image header: %w", err)
+		}
+	}
+	if size < wuxHeaderSize || binary.LittleEndian.Uint32(header[0x00:]) != Magic0 || binary.LittleEndian.Uint32(header[0x04:]) != Magic1 {
+		if size < SectorSize {
+			return nil, errors.New("disc image is too short")
+		}
+		return &Image{r: r, size: size}, nil
+	}
+
+	sectorSize := int64(binary.LittleEndian.Uint32(header[0x08:]))
+	uncompressedSize := binary.LittleEndian.Uint64(header[0x10:])
+	if sectorSize < 0x100 || sectorSize > MaxWUXSectorSize || sectorSize&(sectorSize-1) != 0 {
+		return nil, fmt.Errorf("invalid WUX sector size %#x", sectorSize)
+	}
+	count := (uncompressedSize + uint64(sectorSize) - 1) / uint64(sectorSize)
+	if count > uint64(size-wuxHeaderSize)/4 {
+		return nil, errors.New("WUX sector table is truncated")
+	}
+	table := make([]byte, count*4)
+	if _, err := r.ReadAt(table, wuxHeaderSize); err != nil {
+		return nil, fmt.Errorf("failed to read WUX sector table: %w", err)
+	}
+	img := &Image{
+		r:          r,
+		size:       int64(uncompressedSize),
+		sectorSize: sectorSize,
+		// Sectors start at the first multiple of the sector size after the
+		// table.
+		dataOffset: (wuxHeaderSize + int64(len(table)) + sectorSize - 1) &^ (sectorSize - 1),
+		sectors:    make([]uint32, count),
+	}
+	for i := range img.sectors {
+		img.sectors[i] = binary.LittleEndian.Uint32(table[i*4:])
+		if img.dataOffset+(int64(img.sectors[i])+1)*sectorSize > size {
+			return nil, fmt.Errorf("WUX sector %d is out of bounds", i)
+		}
+	}
+	return img, nil
+}
+
+// Size returns the size of the disc.
+func (img *Image) Size() int64 {
+	return img.size
+}
+
+// Compressed reports whether img is a WUX image.
+func (img *Image) Compressed() bool {
+	return img.sectors != nil
+}
+
+// ReadAt implements io.ReaderAt.
+func (img *Image) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("wud: negative offset")
+	}
+	if off >= img.size {
+		return 0, io.EOF
+	}
+	limit := p
+	if int64(len(p)) > img.size-off {
+		limit = p[:img.size-off]
+	}
+	var n int
+	var err error
+	if img.sectors == nil {
+		n, err = img.r.ReadAt(limit, off)
+	} else {
+		for n < len(limit) && err == nil {
+			sector, within := (off+int64(n))/img.sectorSize, (off+int64(n))%img.sectorSize
+			chunk := limit[n:min(len(limit), n+int(img.sectorSize-within))]
+			var m int
+			m, err = img.r.ReadAt(chunk, img.dataOffset+int64(img.sectors[sector])*img.sectorSize+within)
+			n += m
+		}
+	}
+	if err == nil && n < len(p) {
+		err = io.EOF
+	}
+	return n, err
+}
@@ -0,0 +1,86 @@
+package wud
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+)
+
+// testWUX returns a WUX image with 0x100 byte sectors whose table maps the
+// sectors of the disc to stored.
+func testWUX(size uint64, table []uint32, stored ...[]byte) []byte {
+	const sectorSize = 0x100
+	data := make([]byte, wuxHeaderSize+4*len(table))
+	binary.LittleEndian.PutUint32(data[0x00:], Magic0)
+	binary.LittleEndian.PutUint32(data[0x04:], Magic1)
+	binary.LittleEndian.PutUint32(data[0x08:], sectorSize)
+	binary.LittleEndian.PutUint64(data[0x10:], size)
+	for i, sector := range table {
+		binary.LittleEndian.PutUint32(data[wuxHeaderSize+4*i:], sector)
+	}
+	data = append(data, make([]byte, (sectorSize-len(data)%sectorSize)%sectorSize)...)
+	for _, sector := range stored {
+		data = append(data, sector...)
+	}
+	return data
+}
+
+func TestOpenWUD(t *testing.T) {
+	disc := bytes.Repeat([]byte{1, 2, 3}, SectorSize)
+	img, err := Open(bytes.NewReader(disc), int64(len(disc)))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if img.Compressed() || img.Size() != int64(len(disc)) {
+		t.Fatalf("Open = compressed %v, size %d", img.Compressed(), img.Size())
+	}
+	got := make([]byte, 10)
+	if n, err := img.ReadAt(got, int64(len(disc))-5); n != 5 || err != io.EOF || !bytes.Equal(got[:5], disc[len(disc)-5:]) {
+		t.Fatalf("ReadAt past the end = %d, %v", n, err)
+	}
+
+	if _, err := Open(bytes.NewReader(disc[:SectorSize-1]), SectorSize-1); err == nil {
+		t.Fatal("Open accepted a disc shorter than a sector")
+	}
+}
+
+func TestOpenWUX(t *testing.T) {
+	a, b := bytes.Repeat([]byte{0xA}, 0x100), bytes.Repeat([]byte{0xB}, 0x100)
+	// The disc is a, b, a and the first half of b.
+	data := testWUX(0x380, []uint32{0, 1, 0, 1}, a, b)
+	img, err := Open(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if !img.Compressed() || img.Size() != 0x380 {
+		t.Fatalf("Open = compressed %v, size %#x", img.Compressed(), img.Size())
+	}
+	want := append(append(append(bytes.Clone(a), b...), a...), b[:0x80]...)
+	got := make([]byte, len(want))
+	if _, err := img.ReadAt(got, 0); err != nil {
+		t.Fatalf("ReadAt: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatal("ReadAt does not return the disc")
+	}
+	// Reads across sectors start and end within them.
+	got = make([]byte, 0x200)
+	if _, err := img.ReadAt(got, 0xF0); err != nil || !bytes.Equal(got, want[0xF0:0x2F0]) {
+		t.Fatalf("ReadAt across sectors = %v", err)
+	}
+
+	for name, data := range map[string][]byte{
+		"sector size": func() []byte {
+			data := testWUX(0x100, []uint32{0}, a)
+			binary.LittleEndian.PutUint32(data[0x08:], 0x180)
+			return data
+		}(),
+		"sector out of bounds": testWUX(0x100, []uint32{1}, a),
+		"truncated table":      testWUX(0x100000, nil),
+	} {
+		if _, err := Open(bytes.NewReader(data), int64(len(data))); err == nil {
+			t.Errorf("Open accepted an invalid %s", name)
+		}
+	}
+}
@@ -0,0 +1,340 @@
+package zarchive
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	Magic    = 0x169F52D6
+	Version1 = 0x61BF3A01
+	// BlockSize is the amount of file data compressed into each block. A
+	// block that does not get smaller is stored uncompressed.
+	BlockSize     = 64 * 1024
+	MaxNameLength = 0x7FFF
+)
+
+const (
+	blocksPerOffsetRecord = 16
+	offsetRecordSize      = 8 + 2*blocksPerOffsetRecord
+	fileTreeEntrySize     = 16
+	footerSize            = 6*16 + sha256.Size + 8 + 4 + 4
+	rootNameOffset        = 0x7FFFFFFF
+	fileEntryFlag         = 0x80000000
+	maxFileOffset         = 1<<48 - 1
+)
+
+type node struct {
+	name     string
+	file     bool
+	children []*node
+	offset   uint64
+	size     uint64
+}
+
+func (n *node) child(name string) *node {
+	for _, child := range n.children {
+		if strings.EqualFold(child.name, name) {
+			return child
+		}
+	}
+	return nil
+}
+
+// Writer writes a ZArchive, the format of Cemu's .wua files. File data is
+// compressed as it is written, so only the directory tree is kept in memory.
+// Names are matched without regard to case, like Cemu does when reading.
+type Writer struct {
+	w    io.Writer
+	hash hash.Hash
+	zstd *zstd.Encoder
+
+	root *node
+	file *node
+
+	block      []byte
+	compressed []byte
+	dataSize   uint64
+	written    uint64
+	blockSizes []uint16
+	recordBase []uint64
+	err        error
+}
+
+// NewWriter returns a Writer that writes an archive to w. Close must be called
+// to write the directory tree; it does not close w.
+func NewWriter(w io.Writer) (*Writer, error) {
+	encoder, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedDefault), zstd.WithEncoderConcurrency(1))
+	if err != nil {
+		return nil, err
+	}
+	return &Writer{
+		w:     w,
+		hash:  sha256.New(),
+		zstd:  encoder,
+		root:  &node{},
+		block: make([]byte, 0, BlockSize),
+	}, nil
+}
+
+// Mkdir adds the directory name and its parents, which may already exist.
+// Names use forward slashes and are relative to the root of the archive.
+func (zw *Writer) Mkdir(name string) error {
+	_, err := zw.mkdirAll(name)
+	return err
+}
+
+// Create adds the file name, creating its parents, and returns a writer for
+// its data. The file ends when the next file is created or the archive is
+// closed.
+func (zw *Writer) Create(name string) (io.Writer, error) {
+	if zw.err != nil {
+		return nil, zw.err
+	}
+	dir, base := "", name
+	if i := strings.LastIndexByte(name, '/'); i >= 0 {
+		dir, base = name[:i], name[i+1:]
+	}
+	parent, err := zw.mkdirAll(dir)
+	if err != nil {
+		return nil, err
+	}
+	if err := validName(base); err != nil {
+		return nil, fmt.Errorf("%q: %w", name, err)
+	}
+	if parent.child(base) != nil {
+		return nil, fmt.Errorf("%q already exists in the archive", name)
+	}
+	zw.file = &node{name: base, file: true, offset: zw.dataSize}
+	parent.children = append(parent.children, zw.file)
+	return fileWriter{zw: zw, file: zw.file}, nil
+}
+
+func (zw *Writer) mkdirAll(name string) (*node, error) {
+	dir := zw.root
+	if name == "" {
+		return dir, nil
+	}
+	for _, element := range strings.Split(name, "/") {
+		if err := validName(element); err != nil {
+			return nil, fmt.Errorf("%q: %w", name, err)
+		}
+		child := dir.child(element)
+		if child == nil {
+			child = &node{name: element}
+			dir.children = append(dir.children, child)
+		} else if child.file {
+			return nil, fmt.Errorf("%q: %q is a file", name, child.name)
+		}
+		dir = child
+	}
+	return dir, nil
+}
+
+func validName(name string) error {
+	switch {
+	case name == "" || name == "." || name == "..":
+		return errors.New("invalid name")
+	case len(name) > MaxNameLength:
+		return errors.New("name is too long")
+	case strings.ContainsAny(name, `/\`):
+		return errors.New("name contains a path separator")
+	}
+	return nil
+}
+
+type fileWriter struct {
+	zw   *Writer
+	file *node
+}
+
+func (f fileWriter) Write(p []byte) (int, error) {
+	zw := f.zw
+	if zw.err != nil {
+		return 0, zw.err
+	}
+	if zw.file != f.file {
+		return 0, errors.New("zarchive: write to a file after the next one was created")
+	}
+	if zw.dataSize+uint64(len(p)) > maxFileOffset {
+		return 0, errors.New("zarchive: archive is too large")
+	}
+	n := 0
+	for n < len(p) {
+		chunk := min(len(p)-n, BlockSize-len(zw.block))
+		zw.block = append(zw.block, p[n:n+chunk]...)
+		n += chunk
+		if len(zw.block) == BlockSize {
+			if err := zw.storeBlock(); err != nil {
+				return n, err
+			}
+		}
+	}
+	zw.dataSize += uint64(n)
+	f.file.size += uint64(n)
+	return n, nil
+}
+
+// storeBlock compresses the full block buffer and writes it.
+func (zw *Writer) storeBlock() error {
+	if len(zw.blockSizes)%blocksPerOffsetRecord == 0 {
+		zw.recordBase = append(zw.recordBase, zw.written)
+	}
+	zw.compressed = zw.zstd.EncodeAll(zw.block, zw.compressed[:0])
+	data := zw.compressed
+	if len(data) >= BlockSize {
+		data = zw.block
+	}
+	zw.blockSizes = append(zw.blockSizes, uint16(len(data)-1))
+	zw.block = zw.block[:0]
+	return zw.output(data)
+}
+
+func (zw *Writer) output(data []byte) error {
+	if zw.err != nil {
+		return zw.err
+	}
+	if _, err := zw.w.Write(data); err != nil {
+		zw.err = err
+		return err
+	}
+	zw.hash.Write(data)
+	zw.written += uint64(len(data))
+	return nil
+}
+
+// Close writes the last block, the directory tree and the footer.
+func (zw *Writer) Close() error {
+	if zw.err != nil {
+		return zw.err
+	}
+	zw.file = nil
+	if len(zw.block) > 0 {
+		// Blocks are always decompressed whole, so the last one is padded.
+		zw.block = append(zw.block, make([]byte, BlockSize-len(zw.block))...)
+		if err := zw.storeBlock(); err != nil {
+			return err
+		}
+	}
+	var sections [6][2]uint64
+	sections[0] = [2]uint64{0, zw.written}
+
+	start := zw.written
+	if err := zw.output(zw.offsetRecords()); err != nil {
+		return err
+	}
+	sections[1] = [2]uint64{start, zw.written - start}
+
+	names, nameOffsets := zw.nameTable()
+	start = zw.written
+	if err := zw.output(names); err != nil {
+		return err
+	}
+	sections[2] = [2]uint64{start, zw.written - start}
+
+	start = zw.written
+	if err := zw.output(zw.fileTree(nameOffsets)); err != nil {
+		return err
+	}
+	sections[3] = [2]uint64{start, zw.written - start}
+	// The meta directory and meta data sections are not used.
+	sections[4] = [2]uint64{zw.written, 0}
+	sections[5] = [2]uint64{zw.written, 0}
+
+	footer := make([]byte, footerSize)
+	for i, section := range sections {
+		binary.BigEndian.PutUint64(footer[i*16:], section[0])
+		binary.BigEndian.PutUint64(footer[i*16+8:], section[1])
+	}
+	hashOffset := len(sections) * 16
+	binary.BigEndian.PutUint64(footer[hashOffset+sha256.Size:], zw.written+footerSize)
+	binary.BigEndian.PutUint32(footer[hashOffset+sha256.Size+8:], Version1)
+	binary.BigEndian.PutUint32(footer[hashOffset+sha256.Size+12:], Magic)
+	// The integrity hash covers the whole archive with the hash itself zeroed.
+	zw.hash.Write(footer)
+	copy(footer[hashOffset:], zw.hash.Sum(nil))
+	if _, err := zw.w.Write(footer); err != nil {
+		zw.err = err
+		return err
+	}
+	zw.err = errors.New("zarchive: writer is closed")
+	return nil
+}
+
+// offsetRecords lists the compressed offset of every group of blocks and the
+// compressed sizes, minus one, of the blocks in it.
+func (zw *Writer) offsetRecords() []byte {
+	data := make([]byte, len(zw.recordBase)*offsetRecordSize)
+	for i, base := range zw.recordBase {
+		record := data[i*offsetRecordSize:]
+		binary.BigEndian.PutUint64(record, base)
+		for j := 0; j < blocksPerOffsetRecord && i*blocksPerOffsetRecord+j < len(zw.blockSizes); j++ {
+			binary.BigEndian.PutUint16(record[8+j*2:], zw.blockSizes[i*blocksPerOffsetRecord+j])
+		}
+	}
+	return data
+}
+
+// nameTable stores every distinct name once, prefixed by its length in one
+// byte, or in two when it does not fit in seven bits.
+func (zw *Writer) nameTable() ([]byte, map[string]uint32) {
+	var table []byte
+	offsets := make(map[string]uint32)
+	var add func(n *node)
+	add = func(n *node) {
+		for _, child := range n.children {
+			if _, ok := offsets[child.name]; !ok {
+				offsets[child.name] = uint32(len(table))
+				if len(child.name) >= 0x80 {
+					table = append(table, byte(len(child.name)&0x7F)|0x80, byte(len(child.name)>>7))
+				} else {
+					table = append(table, byte(len(child.name)))
+				}
+				table = append(table, child.name...)
+			}
+			add(child)
+		}
+	}
+	add(zw.root)
+	return table, offsets
+}
+
+// fileTree lists the nodes breadth first, so that the children of each
+// directory follow each other.
+func (zw *Writer) fileTree(nameOffsets map[string]uint32) []byte {
+	var data []byte
+	queue := []*node{zw.root}
+	next := uint32(1)
+	for len(queue) > 0 {
+		n := queue[0]
+		queue = queue[1:]
+
+		entry := make([]byte, fileTreeEntrySize)
+		nameOffset := uint32(rootNameOffset)
+		if n != zw.root {
+			nameOffset = nameOffsets[n.name]
+		}
+		if n.file {
+			binary.BigEndian.PutUint32(entry[0:], nameOffset|fileEntryFlag)
+			binary.BigEndian.PutUint32(entry[4:], uint32(n.offset))
+			binary.BigEndian.PutUint32(entry[8:], uint32(n.size))
+			binary.BigEndian.PutUint16(entry[12:], uint16(n.size>>32))
+			binary.BigEndian.PutUint16(entry[14:], uint16(n.offset>>32))
+		} else {
+			binary.BigEndian.PutUint32(entry[0:], nameOffset)
+			binary.BigEndian.PutUint32(entry[4:], next)
+			binary.BigEndian.PutUint32(entry[8:], uint32(len(n.children)))
+			next += uint32(len(n.children))
+			queue = append(queue, n.children...)
+		}
+		data = append(data, entry...)
+	}
+	return data
+}
@@ -0,0 +1,75 @@
+package wiiudownloader
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// PathFilter selects files of a Wii U title by their path in the FST, such as
+// "code/app.xml". Patterns use path.Match syntax and also select everything
+// below a directory they match, so "content/Common" selects the whole
+// directory. A pattern without a slash is matched against the names at any
+// depth, so "*.rpx" selects every RPX file. A file is selected when it matches
+// one of the Include patterns, or Include is empty, and none of the Exclude
+// patterns.
+type PathFilter struct {
+	Include []string
+	Exclude []string
+}
+
+// IsZero reports whether f selects every file.
+func (f PathFilter) IsZero() bool {
+	return len(f.Include) == 0 && len(f.Exclude) == 0
+}
+
+// Validate returns an error for an empty or malformed pattern.
+func (f PathFilter) Validate() error {
+	for _, patterns := range [][]string{f.Include, f.Exclude} {
+		for _, pattern := range patterns {
+			clean := cleanPathPattern(pattern)
+			if clean == "" {
+				return fmt.Errorf("invalid path pattern %q: empty", pattern)
+			}
+			if _, err := path.Match(clean, ""); err != nil {
+				return fmt.Errorf("invalid path pattern %q: %w", pattern, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Match reports whether f selects the file at name, a slash separated path
+// relative to the title root.
+func (f PathFilter) Match(name string) bool {
+	if len(f.Include) > 0 && !matchAnyPathPattern(f.Include, name) {
+		return false
+	}
+	return !matchAnyPathPattern(f.Exclude, name)
+}
+
+func matchAnyPathPattern(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		pattern = cleanPathPattern(pattern)
+		anyDepth := !strings.Contains(pattern, "/")
+		for dir := name; dir != "." && dir != "/" && dir != ""; dir = path.Dir(dir) {
+			candidate := dir
+			if anyDepth {
+				candidate = path.Base(dir)
+			}
+			if ok, _ := path.Match(pattern, candidate); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func cleanPathPattern(pattern string) string {
+	pattern = strings.Trim(filepath.ToSlash(strings.TrimSpace(pattern)), "/")
+	if pattern == "" {
+		return ""
+	}
+	return path.Clean(pattern)
+}
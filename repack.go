@@ -0,0 +1,576 @@
+package wiiudownloader
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	fstfmt "github.com/Xpl0itU/WiiUDownloader/internal/formats/fst"
+	tmdfmt "github.com/Xpl0itU/WiiUDownloader/internal/formats/tmd"
+)
+
+const (
+	// Content types written by RepackTitle: encrypted, and encrypted with a
+	// hash tree.
+	repackContentType       = 0x2001
+	repackHashedContentType = 0x2003
+	repackFSTFactor         = 0x20
+)
+
+// RepackTitleOptions configures RepackTitle.
+type RepackTitleOptions struct {
+	// Original is the title folder the files were decrypted from. Its
+	// title.tmd and title.tik, and title.cert when present, are reused; its
+	// contents are not needed.
+	Original string
+	// ProgressReporter may be nil.
+	ProgressReporter ProgressReporter
+}
+
+type repackContent struct {
+	hashed bool
+	// fst is the data of content 0, which holds the FST.
+	fst   []byte
+	files []repackFile
+	// size is the size of the data including the padding after the last file.
+	size uint64
+}
+
+type repackFile struct {
+	source string
+	offset uint64
+	size   uint64
+}
+
+// RepackTitle is the inverse of DecryptContents: it packs the decrypted
+// code, content and meta folders of a Wii U title in src into an encrypted
+// title folder at dest that can be installed or decrypted again. Code goes
+// into contents without a hash tree, with every RPX and RPL in one of its
+// own, and meta and content into contents with H0-H3 hash trees and .h3
+// files. The TMD of the original title is rewritten for the new contents but
+// keeps its signature, which no longer matches, so the title only installs
+// where signatures are not checked. dest must not hold a title yet.
+// ErrCancelled is returned when ctx or the progress reporter is cancelled.
+func RepackTitle(ctx context.Context, src, dest string, opts RepackTitleOptions) (err error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	progressReporter := opts.ProgressReporter
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stopMonitor := monitorCancellation(ctx, cancel, progressReporter)
+	defer stopMonitor()
+	defer func() {
+		if err != nil && (isCancelled(progressReporter) || ctx.Err() != nil) {
+			err = ErrCancelled
+		}
+		if err != nil && err != ErrCancelled {
+			err = fmt.Errorf("repack error: %w", wrapDiskFull(err))
+		}
+	}()
+
+	tmdData, err := os.ReadFile(filepath.Join(opts.Original, "title.tmd"))
+	if err != nil {
+		return err
+	}
+	tmd, err := ParseTMD(tmdData)
+	if err != nil {
+		return err
+	}
+	if tmd.Version != TMD_VERSION_WIIU {
+		return errors.New("only Wii U titles can be repacked")
+	}
+	cipherHashTree, err := titleKeyCipher(opts.Original, tmd)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(filepath.Join(dest, "title.tmd")); err == nil {
+		return fmt.Errorf("%s already holds a title", dest)
+	}
+
+	root, contents, err := layoutRepackContents(src)
+	if err != nil {
+		return err
+	}
+	if err := buildRepackFST(root, contents, tmd.TitleID, uint32(tmd.GroupID)); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(dest, 0o755); err != nil {
+		return err
+	}
+	progress := &byteProgress{reporter: progressReporter}
+	for _, content := range contents {
+		progress.total += content.size
+		if content.hashed {
+			progress.total += content.size
+		}
+	}
+	records := make([]Content, len(contents))
+	for i, content := range contents {
+		records[i] = Content{ID: uint32(i), Index: []byte{byte(i >> 8), byte(i)}, CIDStr: fmt.Sprintf("%08X", i)}
+		if err := writeRepackContent(ctx, dest, content, &records[i], cipherHashTree, progress); err != nil {
+			return err
+		}
+	}
+
+	newTMD, err := repackTMD(tmdData, records)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dest, "title.tmd"), newTMD, downloadFilePerm); err != nil {
+		return err
+	}
+	for _, name := range []string{"title.tik", "title.cert"} {
+		data, err := os.ReadFile(filepath.Join(opts.Original, name))
+		if errors.Is(err, os.ErrNotExist) && name == "title.cert" {
+			continue
+		} else if err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(dest, name), data, downloadFilePerm); err != nil {
+			return err
+		}
+	}
+	if progressReporter != nil {
+		progressReporter.UpdateDecryptionProgress(1.0)
+	}
+	return nil
+}
+
+// layoutRepackContents reads the folders of the decrypted title in src and
+// assigns every file to a content. Content 0 is left for the FST.
+func layoutRepackContents(src string) (*fstfmt.Node, []*repackContent, error) {
+	root := &fstfmt.Node{Dir: true}
+	var code, meta, data []*fstfmt.Node
+	var binaries [][]*fstfmt.Node
+	sources := make(map[*fstfmt.Node]string)
+
+	var add func(dir *fstfmt.Node, path, top string) error
+	add = func(dir *fstfmt.Node, path, top string) error {
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			childPath := filepath.Join(path, entry.Name())
+			node := &fstfmt.Node{Name: entry.Name(), Dir: entry.IsDir()}
+			dir.Children = append(dir.Children, node)
+			if entry.IsDir() {
+				if err := add(node, childPath, top); err != nil {
+					return err
+				}
+				continue
+			}
+			info, err := entry.Info()
+			if err != nil {
+				return err
+			}
+			if !info.Mode().IsRegular() {
+				return fmt.Errorf("%s is not a regular file", childPath)
+			}
+			if info.Size() > 0xFFFFFFFF {
+				return fmt.Errorf("%s is larger than 4 GiB", childPath)
+			}
+			node.Size = uint32(info.Size())
+			sources[node] = childPath
+			switch ext := strings.ToLower(filepath.Ext(entry.Name())); {
+			case top == "code" && (ext == ".rpx" || ext == ".rpl"):
+				binaries = append(binaries, []*fstfmt.Node{node})
+			case top == "code":
+				code = append(code, node)
+			case top == "meta":
+				meta = append(meta, node)
+			default:
+				data = append(data, node)
+			}
+		}
+		return nil
+	}
+	for _, top := range cemuTitleDirectories {
+		path := filepath.Join(src, top)
+		if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		node := &fstfmt.Node{Name: top, Dir: true}
+		root.Children = append(root.Children, node)
+		if err := add(node, path, top); err != nil {
+			return nil, nil, err
+		}
+	}
+	if len(root.Children) == 0 {
+		return nil, nil, errNotDecrypted
+	}
+
+	contents := []*repackContent{{}}
+	addContent := func(nodes []*fstfmt.Node, hashed bool) {
+		if len(nodes) == 0 {
+			return
+		}
+		content := &repackContent{hashed: hashed}
+		for _, node := range nodes {
+			node.Content = uint16(len(contents))
+			node.Offset = alignUp(content.size, repackFSTFactor)
+			content.files = append(content.files, repackFile{source: sources[node], offset: node.Offset, size: uint64(node.Size)})
+			content.size = node.Offset + uint64(node.Size)
+		}
+		contents = append(contents, content)
+	}
+	addContent(code, false)
+	for _, rpl := range binaries {
+		addContent(rpl, false)
+	}
+	addContent(meta, true)
+	addContent(data, true)
+	for _, content := range contents[1:] {
+		if !content.hashed {
+			content.size = alignUp(max(content.size, 1), BLOCK_SIZE)
+		}
+	}
+	return root, contents, nil
+}
+
+// buildRepackFST stores the FST of root in content 0. Every content gets a
+// cluster whose offset and size count 0x8000 byte units as if the contents
+// followed each other.
+func buildRepackFST(root *fstfmt.Node, contents []*repackContent, titleID uint64, groupID uint32) error {
+	clusters := make([]fstfmt.Cluster, len(contents))
+	fst, err := fstfmt.Build(repackFSTFactor, clusters, root)
+	if err != nil {
+		return err
+	}
+	contents[0].size = alignUp(uint64(len(fst)), BLOCK_SIZE)
+
+	offset := uint32(0)
+	for i, content := range contents {
+		clusters[i] = fstfmt.Cluster{
+			Offset:       offset,
+			Size:         uint32(repackEncryptedSize(content) / BLOCK_SIZE),
+			OwnerTitleID: titleID,
+			GroupID:      groupID,
+			HashMode:     fstfmt.HashModeRaw,
+		}
+		if content.hashed {
+			clusters[i].HashMode = fstfmt.HashModeHashed
+		}
+		offset += clusters[i].Size
+	}
+	if contents[0].fst, err = fstfmt.Build(repackFSTFactor, clusters, root); err != nil {
+		return err
+	}
+	return nil
+}
+
+// repackEncryptedSize returns the size of the .app file of content.
+func repackEncryptedSize(content *repackContent) uint64 {
+	if !content.hashed {
+		return content.size
+	}
+	return max(1, (content.size+HASH_BLOCK_SIZE-1)/HASH_BLOCK_SIZE) * BLOCK_SIZE_HASHED
+}
+
+// writeRepackContent encrypts content into its .app file, and its .h3 file for
+// a hashed content, and fills in the size, type and hash of record.
+func writeRepackContent(ctx context.Context, dest string, content *repackContent, record *Content, cipherHashTree cipher.Block, progress *byteProgress) error {
+	file, err := os.OpenFile(filepath.Join(dest, record.CIDStr+".app"), os.O_CREATE|os.O_TRUNC|os.O_WRONLY, downloadFilePerm)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if !content.hashed {
+		var iv [aes.BlockSize]byte
+		copy(iv[:], record.Index)
+		w := &contentEncrypter{w: file, cbc: cipher.NewCBCEncrypter(cipherHashTree, iv[:]), hash: sha1.New()}
+		if err := content.writeData(ctx, w, progress); err != nil {
+			return err
+		}
+		record.Type = repackContentType
+		record.Size = content.size
+		record.Hash = w.hash.Sum(nil)
+		return file.Close()
+	}
+
+	// The hash tables in each block cover the blocks after it, so the data is
+	// read twice: once to hash it and once to encrypt it.
+	hasher := newBlockHasher()
+	if err := content.writeData(ctx, hasher, progress); err != nil {
+		return err
+	}
+	if err := hasher.finish(); err != nil {
+		return err
+	}
+	tree := newHashTree(hasher.h0)
+	w := newHashedContentEncrypter(file, cipherHashTree, tree)
+	if err := content.writeData(ctx, w, progress); err != nil {
+		return err
+	}
+	if err := w.finish(); err != nil {
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dest, record.CIDStr+".h3"), tree.h3, downloadFilePerm); err != nil {
+		return err
+	}
+	h3Hash := sha1.Sum(tree.h3)
+	record.Type = repackHashedContentType
+	record.Size = repackEncryptedSize(content)
+	record.Hash = h3Hash[:]
+	return nil
+}
+
+// writeData writes the data of the content, with the files at their offsets
+// and zeros between them, to w.
+func (c *repackContent) writeData(ctx context.Context, w io.Writer, progress *byteProgress) error {
+	written := uint64(0)
+	pad := func(to uint64) error {
+		for written < to {
+			n := min(to-written, uint64(len(zeroPadding)))
+			if _, err := w.Write(zeroPadding[:n]); err != nil {
+				return err
+			}
+			written += n
+		}
+		return nil
+	}
+	if c.fst != nil {
+		if _, err := w.Write(c.fst); err != nil {
+			return err
+		}
+		written = uint64(len(c.fst))
+	}
+	buf := make([]byte, READ_SIZE)
+	for _, file := range c.files {
+		if err := pad(file.offset); err != nil {
+			return err
+		}
+		if err := copyRepackFile(ctx, w, file, buf, progress); err != nil {
+			return err
+		}
+		written += file.size
+	}
+	return pad(c.size)
+}
+
+var zeroPadding = make([]byte, BLOCK_SIZE)
+
+func copyRepackFile(ctx context.Context, w io.Writer, file repackFile, buf []byte, progress *byteProgress) error {
+	src, err := os.Open(file.source)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	left := file.size
+	for left > 0 {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		n, err := io.ReadFull(src, buf[:min(left, uint64(len(buf)))])
+		if err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return fmt.Errorf("%s changed while it was repacked", file.source)
+			}
+			return err
+		}
+		if _, err := w.Write(buf[:n]); err != nil {
+			return err
+		}
+		left -= uint64(n)
+		if progress.add(uint64(n)) {
+			return ErrCancelled
+		}
+	}
+	return nil
+}
+
+// contentEncrypter encrypts a content without a hash tree and hashes its
+// plain data. The data must be a multiple of the AES block size.
+type contentEncrypter struct {
+	w       io.Writer
+	cbc     cipher.BlockMode
+	hash    hash.Hash
+	pending []byte
+	out     []byte
+}
+
+func (e *contentEncrypter) Write(p []byte) (int, error) {
+	e.hash.Write(p)
+	e.pending = append(e.pending, p...)
+	n := len(e.pending) / aes.BlockSize * aes.BlockSize
+	if cap(e.out) < n {
+		e.out = make([]byte, n)
+	}
+	e.cbc.CryptBlocks(e.out[:n], e.pending[:n])
+	e.pending = append(e.pending[:0], e.pending[n:]...)
+	if _, err := e.w.Write(e.out[:n]); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// blockSplitter hands the data written to it to fn in blocks of
+// HASH_BLOCK_SIZE bytes.
+type blockSplitter struct {
+	block []byte
+	count int64
+	fn    func(block []byte, index int64) error
+}
+
+func (s *blockSplitter) Write(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		chunk := min(len(p)-n, HASH_BLOCK_SIZE-len(s.block))
+		s.block = append(s.block, p[n:n+chunk]...)
+		n += chunk
+		if len(s.block) == HASH_BLOCK_SIZE {
+			if err := s.flush(); err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// finish pads the last block with zeros. A content has at least one block.
+func (s *blockSplitter) finish() error {
+	if len(s.block) == 0 && s.count > 0 {
+		return nil
+	}
+	n := len(s.block)
+	s.block = s.block[:HASH_BLOCK_SIZE]
+	clear(s.block[n:])
+	return s.flush()
+}
+
+func (s *blockSplitter) flush() error {
+	err := s.fn(s.block, s.count)
+	s.count++
+	s.block = s.block[:0]
+	return err
+}
+
+// blockHasher collects the H0 hashes of the blocks of a hashed content.
+type blockHasher struct {
+	blockSplitter
+	h0 []byte
+}
+
+func newBlockHasher() *blockHasher {
+	h := &blockHasher{}
+	h.block = make([]byte, 0, HASH_BLOCK_SIZE)
+	h.fn = h.hashBlock
+	return h
+}
+
+func (h *blockHasher) hashBlock(block []byte, index int64) error {
+	sum := sha1.Sum(block)
+	h.h0 = append(h.h0, sum[:]...)
+	return nil
+}
+
+// hashTree holds the H0-H2 hashes of the blocks of a content and its H3
+// table, as the tables stored in the blocks and the .h3 file expect them.
+type hashTree struct {
+	h0, h1, h2, h3 []byte
+}
+
+func newHashTree(h0 []byte) *hashTree {
+	tree := &hashTree{h0: h0}
+	tree.h1 = hashGroups(tree.h0)
+	tree.h2 = hashGroups(tree.h1)
+	tree.h3 = hashGroups(tree.h2)
+	return tree
+}
+
+// hashGroups returns the hash of every group of HASH_ENTRIES_PER_LEVEL hashes.
+func hashGroups(hashes []byte) []byte {
+	groups := (len(hashes)/HASH_ENTRY_SIZE + HASH_ENTRIES_PER_LEVEL - 1) / HASH_ENTRIES_PER_LEVEL
+	out := make([]byte, 0, groups*HASH_ENTRY_SIZE)
+	for group := 0; group < groups; group++ {
+		sum := sha1.Sum(hashGroup(hashes, int64(group)))
+		out = append(out, sum[:]...)
+	}
+	return out
+}
+
+// hashGroup returns a table of HASH_ENTRIES_PER_LEVEL hashes, padded with
+// zeros after the last one.
+func hashGroup(hashes []byte, group int64) []byte {
+	table := make([]byte, HASH_ENTRIES_PER_LEVEL*HASH_ENTRY_SIZE)
+	start := group * HASH_ENTRIES_PER_LEVEL * HASH_ENTRY_SIZE
+	if start < int64(len(hashes)) {
+		copy(table, hashes[start:])
+	}
+	return table
+}
+
+// hashedContentEncrypter encrypts a content with a hash tree, prefixing every
+// block with its encrypted H0-H2 tables.
+type hashedContentEncrypter struct {
+	blockSplitter
+	w      io.Writer
+	cipher cipher.Block
+	tree   *hashTree
+	out    []byte
+}
+
+func newHashedContentEncrypter(w io.Writer, cipherHashTree cipher.Block, tree *hashTree) *hashedContentEncrypter {
+	e := &hashedContentEncrypter{w: w, cipher: cipherHashTree, tree: tree, out: make([]byte, BLOCK_SIZE_HASHED)}
+	e.block = make([]byte, 0, HASH_BLOCK_SIZE)
+	e.fn = e.encryptBlock
+	return e
+}
+
+func (e *hashedContentEncrypter) encryptBlock(block []byte, index int64) error {
+	hashes := e.out[:HASHES_SIZE]
+	copy(hashes[HASH_H0_START:], hashGroup(e.tree.h0, index/HASH_ENTRIES_PER_LEVEL))
+	copy(hashes[HASH_H1_START:], hashGroup(e.tree.h1, index/(HASH_ENTRIES_PER_LEVEL*HASH_ENTRIES_PER_LEVEL)))
+	copy(hashes[HASH_H2_START:], hashGroup(e.tree.h2, index/(HASH_ENTRIES_PER_LEVEL*HASH_ENTRIES_PER_LEVEL*HASH_ENTRIES_PER_LEVEL)))
+	clear(hashes[HASH_H2_END:])
+	h0Hash := hashEntryAt(e.tree.h0, index)
+
+	var zeroIV [aes.BlockSize]byte
+	cipher.NewCBCEncrypter(e.cipher, zeroIV[:]).CryptBlocks(hashes, hashes)
+	cipher.NewCBCEncrypter(e.cipher, h0Hash[:aes.BlockSize]).CryptBlocks(e.out[HASHES_SIZE:], block)
+	_, err := e.w.Write(e.out)
+	return err
+}
+
+// repackTMD returns a copy of the TMD in original with the content records
+// replaced by contents, and the content info record and header hashes
+// updated to match. The certificates after the content records are kept.
+func repackTMD(original []byte, contents []Content) ([]byte, error) {
+	tmd, err := tmdfmt.Parse(original)
+	if err != nil {
+		return nil, err
+	}
+	tmd.ContentCount = uint16(len(contents))
+	tmd.Contents = make([]tmdfmt.Content, len(contents))
+	for i, content := range contents {
+		tmd.Contents[i] = tmdfmt.Content{
+			ID:   content.ID,
+			Type: content.Type,
+			Size: content.Size,
+			Hash: content.Hash,
+		}
+		copy(tmd.Contents[i].Index[:], content.Index)
+	}
+	if err := tmd.UpdateContentInfo(); err != nil {
+		return nil, err
+	}
+	return tmd.Marshal()
+}
+
+func alignUp(value, alignment uint64) uint64 {
+	return (value + alignment - 1) / alignment * alignment
+}
@@ -0,0 +1,163 @@
+package wiiudownloader
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"fmt"
+	"io/fs"
+	"math/rand/v2"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+)
+
+const testTitleID = 0x0005000010101C00
+
+// testTitleFiles are the files of the decrypted title repacked by
+// TestRepackTitle. content/big.bin spans several hashed blocks.
+func testTitleFiles() map[string][]byte {
+	random := rand.NewChaCha8([32]byte{1})
+	randomBytes := func(n int) []byte {
+		data := make([]byte, n)
+		random.Read(data)
+		return data
+	}
+	return map[string][]byte{
+		"code/app.xml":         []byte("<app><title_version>32</title_version></app>\n"),
+		"code/cos.xml":         []byte("<app><max_size>0x40000000</max_size></app>\n"),
+		"code/game.rpx":        randomBytes(100_000),
+		"code/lib.rpl":         randomBytes(3),
+		"meta/meta.xml":        []byte("<menu><title_id>0005000010101C00</title_id></menu>\n"),
+		"content/Common/a.bin": randomBytes(70_000),
+		"content/Common/b.bin": randomBytes(5),
+		"content/big.bin":      randomBytes(3*HASH_BLOCK_SIZE + 123),
+		"content/empty.bin":    nil,
+	}
+}
+
+func writeTestFiles(t *testing.T, dir string, files map[string][]byte) {
+	t.Helper()
+	for name, data := range files {
+		filePath := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(filePath), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filePath, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// writeTestWiiUOriginal writes the title.tmd and title.tik RepackTitle takes
+// from the original title, for a title key of zeroes. The TMD has no
+// contents, since RepackTitle replaces them.
+func writeTestWiiUOriginal(t *testing.T, dir string) {
+	t.Helper()
+	tmd := make([]byte, 0xB04)
+	binary.BigEndian.PutUint32(tmd, 0x10004)
+	copy(tmd[0x140:], "Root-CA00000003-CP0000000b")
+	tmd[0x180] = TMD_VERSION_WIIU
+	binary.BigEndian.PutUint64(tmd[0x18C:], testTitleID)
+	binary.BigEndian.PutUint16(tmd[0x1DC:], 32)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "title.tmd"), tmd, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	titleKey := encryptTestTitleKey(t, wiiUCommonKey, testTitleID, make([]byte, aes.BlockSize))
+	if err := GenerateTicket(filepath.Join(dir, "title.tik"), testTitleID, titleKey, 32); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func encryptTestTitleKey(t *testing.T, commonKey []byte, titleID uint64, titleKey []byte) []byte {
+	t.Helper()
+	block, err := aes.NewCipher(commonKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var iv [aes.BlockSize]byte
+	binary.BigEndian.PutUint64(iv[:], titleID)
+	encrypted := make([]byte, len(titleKey))
+	cipher.NewCBCEncrypter(block, iv[:]).CryptBlocks(encrypted, titleKey)
+	return encrypted
+}
+
+// TestRepackTitle repacks a decrypted title and reads the result back through
+// every path that accepts an encrypted title folder.
+func TestRepackTitle(t *testing.T) {
+	files := testTitleFiles()
+	dir := t.TempDir()
+	src, original, dest := filepath.Join(dir, "src"), filepath.Join(dir, "original"), filepath.Join(dir, "dest")
+	writeTestFiles(t, src, files)
+	writeTestWiiUOriginal(t, original)
+
+	if err := RepackTitle(context.Background(), src, dest, RepackTitleOptions{Original: original}); err != nil {
+		t.Fatalf("RepackTitle: %v", err)
+	}
+
+	report, err := VerifyTitle(dest, nil)
+	if err != nil {
+		t.Fatalf("VerifyTitle: %v", err)
+	}
+	if !report.OK() {
+		t.Fatalf("VerifyTitle failed contents: %v", report.Failed())
+	}
+
+	titleFS, err := OpenTitleFS(dest)
+	if err != nil {
+		t.Fatalf("OpenTitleFS: %v", err)
+	}
+	var names []string
+	for name, data := range files {
+		names = append(names, name)
+		got, err := fs.ReadFile(titleFS, name)
+		if err != nil {
+			t.Fatalf("TitleFS: %v", err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Errorf("TitleFS: %s differs from the repacked file", name)
+		}
+	}
+	if err := fstest.TestFS(titleFS, names...); err != nil {
+		t.Errorf("TitleFS: %v", err)
+	}
+
+	var wua bytes.Buffer
+	titles, err := WriteWUA(&wua, []string{dest}, nil)
+	if err != nil {
+		t.Fatalf("WriteWUA: %v", err)
+	}
+	folder := fmt.Sprintf("%016x_v%d", uint64(testTitleID), 32)
+	if len(titles) != 1 || titles[0].Folder != folder {
+		t.Fatalf("WriteWUA titles = %+v, want one in %s", titles, folder)
+	}
+	archived := readTestZArchive(t, wua.Bytes())
+	if len(archived) != len(files) {
+		t.Errorf("archive holds %d files, want %d", len(archived), len(files))
+	}
+	for name, data := range files {
+		if got, ok := archived[path.Join(folder, name)]; !ok || !bytes.Equal(got, data) {
+			t.Errorf("archive: %s is missing or differs", name)
+		}
+	}
+
+	if err := DecryptContentsWithOptions(context.Background(), dest, DecryptContentsOptions{}); err != nil {
+		t.Fatalf("DecryptContentsWithOptions: %v", err)
+	}
+	for name, data := range files {
+		got, err := os.ReadFile(filepath.Join(dest, filepath.FromSlash(name)))
+		if err != nil {
+			t.Fatalf("decrypted title: %v", err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Errorf("decrypted title: %s differs from the repacked file", name)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package wiiudownloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// A content is downloaded at most this many times. The second round catches
+// data that only shows up as damaged once a replaced H3 table can check it.
+const maxRepairRounds = 2
+
+// RepairTitleOptions configures RepairTitle.
+type RepairTitleOptions struct {
+	// Client defaults to http.DefaultClient.
+	Client *http.Client
+	// ProgressReporter may be nil.
+	ProgressReporter ProgressReporter
+	// Concurrency is the number of contents repaired in parallel. Zero uses the default of 4.
+	Concurrency int
+	// ConnectionsPerFile is the number of range requests a large content is
+	// split into. Zero uses the default of 4; 1 disables splitting.
+	ConnectionsPerFile int
+	// UserAgent defaults to "WiiUDownloader".
+	UserAgent string
+}
+
+// RepairTitleResult describes what RepairTitle did.
+type RepairTitleResult struct {
+	// Scan is the verification report of the folder before it was repaired.
+	Scan *VerifyReport
+	// Repaired lists the contents that were downloaded again and now pass
+	// verification.
+	Repaired []ContentReport
+	// Unrepaired lists the contents that are still damaged or missing, with
+	// the reason.
+	Unrepaired []ContentReport
+	// BytesTransferred counts the bytes received over the network.
+	BytesTransferred int64
+}
+
+// RepairTitle verifies the title folder in path like VerifyTitle and downloads
+// only the contents that are damaged or missing again. Damaged files are
+// removed first; a repair that is interrupted resumes where it stopped when it
+// is run again. The error is set when the folder cannot be repaired at all,
+// e.g. because title.tmd is missing, when the disk is full and with
+// ErrCancelled when ctx or the progress reporter is cancelled.
+func RepairTitle(ctx context.Context, path string, opts RepairTitleOptions) (*RepairTitleResult, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	client := opts.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	userAgent := opts.UserAgent
+	if userAgent == "" {
+		userAgent = DEFAULT_USER_AGENT
+	}
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = maxConcurrentDownloads
+	}
+	connections := opts.ConnectionsPerFile
+	if connections <= 0 {
+		connections = defaultConnectionsPerFile
+	}
+	progressReporter := opts.ProgressReporter
+
+	result := &RepairTitleResult{}
+	scan, err := VerifyTitle(path, progressReporter)
+	if err != nil {
+		return result, err
+	}
+	result.Scan = scan
+	damaged := scan.Failed()
+	if len(damaged) == 0 {
+		return result, nil
+	}
+
+	cipherHashTree, err := titleKeyCipher(path, scan.TMD)
+	if err != nil {
+		return result, err
+	}
+
+	stats := &downloadStats{}
+	defer func() {
+		result.BytesTransferred = stats.bytesTransferred.Load()
+	}()
+	if progressReporter != nil {
+		progressReporter.ResetTotals()
+		var size int64
+		for _, report := range damaged {
+			if damagedH3(report) {
+				size += expectedH3DownloadSize(report.Content)
+			} else {
+				size += expectedContentDownloadSize(report.Content)
+			}
+		}
+		progressReporter.SetDownloadSize(size)
+		progressReporter.SetStartTime(time.Now())
+	}
+
+	titleIDStr := fmt.Sprintf("%016x", scan.TMD.TitleID)
+	var resultMutex sync.Mutex
+	g, groupCtx := errgroup.WithContext(ctx)
+	g.SetLimit(concurrency)
+	for _, report := range damaged {
+		report := report
+		g.Go(func() error {
+			for round := 1; round <= maxRepairRounds && report.Err != nil; round++ {
+				if !waitUntilResumed(progressReporter) {
+					return ErrCancelled
+				}
+				content, err := removeDamagedContentFiles(path, report)
+				if err != nil {
+					return err
+				}
+				if err := downloadContentFiles(groupCtx, progressReporter, client, titleIDStr, path, content, cipherHashTree, downloadOptions{
+					UserAgent:   userAgent,
+					Stats:       stats,
+					Connections: connections,
+				}); err != nil {
+					if isCancelled(progressReporter) || groupCtx.Err() != nil {
+						return ErrCancelled
+					}
+					if diskErr := wrapDiskFull(err); errors.Is(diskErr, ErrDiskFull) {
+						return diskErr
+					}
+					report.Err = err
+					break
+				}
+				report.Err = resolveContentFileName(path, &content)
+				if report.Err == nil {
+					report.Err = verifyContent(path, content, cipherHashTree, &verifyProgress{})
+				}
+				report.Content = content
+			}
+
+			resultMutex.Lock()
+			defer resultMutex.Unlock()
+			if report.Err == nil {
+				result.Repaired = append(result.Repaired, report)
+			} else {
+				result.Unrepaired = append(result.Unrepaired, report)
+			}
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		if err == ErrCancelled || errors.Is(err, context.Canceled) || isCancelled(progressReporter) || ctx.Err() != nil {
+			return result, ErrCancelled
+		}
+		return result, err
+	}
+	return result, nil
+}
+
+// removeDamagedContentFiles deletes the file a verification error points at,
+// so that downloadContentFiles fetches it again. A damaged H3 table only costs
+// the .h3 file; the .app file is checked again once the table is back. Files
+// named with a lower case content ID are renamed to the upper case names
+// downloadContentFiles uses, and the content is returned with CIDStr updated.
+func removeDamagedContentFiles(path string, report ContentReport) (Content, error) {
+	content := report.Content
+	upper := fmt.Sprintf("%08X", content.ID)
+	if content.CIDStr != upper {
+		for _, ext := range []string{".app", ".h3"} {
+			err := os.Rename(filepath.Join(path, content.CIDStr+ext), filepath.Join(path, upper+ext))
+			if err != nil && !os.IsNotExist(err) {
+				return content, err
+			}
+		}
+		content.CIDStr = upper
+	}
+
+	if errors.Is(report.Err, fs.ErrNotExist) {
+		return content, nil
+	}
+	file := content.CIDStr + ".app"
+	if damagedH3(report) {
+		file = content.CIDStr + ".h3"
+	}
+	if err := os.Remove(filepath.Join(path, file)); err != nil && !os.IsNotExist(err) {
+		return content, err
+	}
+	return content, nil
+}
+
+func damagedH3(report ContentReport) bool {
+	var hashErr *HashMismatchError
+	return errors.As(report.Err, &hashErr) && strings.HasSuffix(hashErr.File, ".h3")
+}
@@ -0,0 +1,206 @@
+package wiiudownloader
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Xpl0itU/WiiUDownloader/internal/formats/cert"
+	ticketfmt "github.com/Xpl0itU/WiiUDownloader/internal/formats/ticket"
+	tmdfmt "github.com/Xpl0itU/WiiUDownloader/internal/formats/tmd"
+)
+
+const (
+	// ROOT_KEY_SIZE is the size of the modulus of the root key, which signs
+	// the CA certificates.
+	ROOT_KEY_SIZE     = 0x200
+	ROOT_KEY_EXPONENT = 0x10001
+)
+
+// Certificate chains end in a CA certificate issued by the root key, and are
+// never longer than root, CA and signer.
+const (
+	rootIssuer          = "Root"
+	maxCertificateDepth = 3
+)
+
+// nintendoCertificates holds the SHA-256 hashes of the public certificates
+// Nintendo signs TMDs and tickets with, the CA, CP and XS certificates
+// GenerateCert assembles, by full name. A chain with any other certificate is
+// reported as invalid unless SignatureOptions.RootKey vouches for it. While no
+// certificate is pinned, such chains are only SignatureChainUnverified.
+var nintendoCertificates = map[string][sha256.Size]byte{}
+
+// fakeSignatureFiller is repeated over the signature of tickets made by
+// GenerateTicket, and of those other tools make the same way.
+var fakeSignatureFiller = []byte{0xD1, 0x5E, 0xA5, 0xED, 0x15, 0xAB, 0xE1, 0x1A}
+
+// SignatureStatus is the outcome of checking the signature of a TMD or ticket.
+type SignatureStatus int
+
+const (
+	// SignatureInvalid means the signature does not match the data or its
+	// certificate chain, as for tampered files.
+	SignatureInvalid SignatureStatus = iota
+	// SignatureFakesigned means the file was never signed, as for generated
+	// tickets. Consoles only accept these with signature checks patched out.
+	SignatureFakesigned
+	// SignatureChainUnverified means the signature matches its certificate
+	// chain, but neither pinned certificates nor a root key were available to
+	// tell whether the chain is Nintendo's.
+	SignatureChainUnverified
+	// SignatureNintendo means the signature matches a certificate chain made
+	// of the pinned Nintendo certificates, or whose CA certificate is signed by
+	// SignatureOptions.RootKey.
+	SignatureNintendo
+)
+
+func (s SignatureStatus) String() string {
+	switch s {
+	case SignatureFakesigned:
+		return "fakesigned"
+	case SignatureChainUnverified:
+		return "signed, CA certificate not checked"
+	case SignatureNintendo:
+		return "signed by Nintendo"
+	default:
+		return "invalid"
+	}
+}
+
+// SignatureReport is the outcome of VerifyTitleSignatures.
+type SignatureReport struct {
+	TMD    SignatureStatus
+	Ticket SignatureStatus
+	// TMDErr and TicketErr tell why a signature is invalid.
+	TMDErr    error
+	TicketErr error
+}
+
+// OK reports whether neither signature is invalid.
+func (r *SignatureReport) OK() bool {
+	return r.TMD != SignatureInvalid && r.Ticket != SignatureInvalid
+}
+
+// SignatureOptions configures VerifyTitleSignatures.
+type SignatureOptions struct {
+	// RootKey is the ROOT_KEY_SIZE byte modulus of the root key. It is not
+	// shipped with WiiUDownloader; without it only chains of the pinned
+	// Nintendo certificates are accepted.
+	RootKey []byte
+}
+
+// VerifyTitleSignatures checks the signatures of title.tmd and title.tik in the
+// title folder in path against the certificates in title.cert and those
+// appended to both files. For Wii U TMDs, the content info hashes that tie the
+// content records to the signed header are checked as well. The error is only
+// set when the files cannot be read or parsed.
+func VerifyTitleSignatures(path string, opts SignatureOptions) (*SignatureReport, error) {
+	if opts.RootKey != nil && len(opts.RootKey) != ROOT_KEY_SIZE {
+		return nil, fmt.Errorf("root key must be %d bytes, got %d", ROOT_KEY_SIZE, len(opts.RootKey))
+	}
+	tmdData, err := os.ReadFile(filepath.Join(path, "title.tmd"))
+	if err != nil {
+		return nil, err
+	}
+	tmd, err := tmdfmt.Parse(tmdData)
+	if err != nil {
+		return nil, fmt.Errorf("title.tmd: %w", err)
+	}
+	ticketData, err := os.ReadFile(filepath.Join(path, "title.tik"))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("%w: title.tik not found", ErrTicketUnavailable)
+		}
+		return nil, err
+	}
+	ticket, err := ticketfmt.Parse(ticketData)
+	if err != nil {
+		return nil, fmt.Errorf("title.tik: %w", err)
+	}
+	certData, err := os.ReadFile(filepath.Join(path, "title.cert"))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	// Certificate1 and Certificate2 split the certificates appended to the TMD
+	// at fixed sizes, not necessarily between two certificates.
+	tmdCertificates := append(append([]byte(nil), tmd.Certificate1...), tmd.Certificate2...)
+	certificates, err := parseCertificatePool(certData, tmdCertificates, ticket.Certificates)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &SignatureReport{}
+	tmdSigned, err := tmd.SignedData()
+	if err != nil {
+		return nil, fmt.Errorf("title.tmd: %w", err)
+	}
+	report.TMD, report.TMDErr = checkSignature(certificates, opts.RootKey, tmd.Issuer, tmd.SignatureType, tmd.Signature, tmdSigned)
+	if report.TMD == SignatureNintendo || report.TMD == SignatureChainUnverified {
+		if err := tmd.VerifyContentInfo(); err != nil {
+			report.TMD, report.TMDErr = SignatureInvalid, err
+		}
+	}
+	ticketSigned, err := ticket.SignedData()
+	if err != nil {
+		return nil, fmt.Errorf("title.tik: %w", err)
+	}
+	report.Ticket, report.TicketErr = checkSignature(certificates, opts.RootKey, ticket.Issuer, ticket.SignatureType, ticket.Signature, ticketSigned)
+	return report, nil
+}
+
+// checkSignature checks signature over data, made by issuer, and the chain of
+// certificates up to the root key. The chain must consist of the pinned
+// Nintendo certificates, or end in a CA certificate signed by rootKey.
+func checkSignature(certificates map[string]cert.Certificate, rootKey []byte, issuer string, signatureType uint32, signature, data []byte) (SignatureStatus, error) {
+	if isFakeSignature(signature) {
+		return SignatureFakesigned, nil
+	}
+	if !strings.HasPrefix(issuer, rootIssuer+"-") {
+		return SignatureInvalid, fmt.Errorf("unexpected issuer %q", issuer)
+	}
+	var unpinned string
+	for depth := 0; issuer != rootIssuer; depth++ {
+		if depth == maxCertificateDepth {
+			return SignatureInvalid, errors.New("certificate chain is too long")
+		}
+		certificate, ok := certificates[issuer]
+		if !ok {
+			return SignatureInvalid, fmt.Errorf("certificate %s not found", issuer)
+		}
+		if pinned, ok := nintendoCertificates[issuer]; !ok {
+			unpinned = issuer
+		} else if sha256.Sum256(certificate.Raw) != pinned && rootKey == nil {
+			return SignatureInvalid, fmt.Errorf("certificate %s is not Nintendo's", issuer)
+		}
+		if err := certificate.VerifySignature(signatureType, signature, data); err != nil {
+			return SignatureInvalid, err
+		}
+		issuer, signatureType, signature, data = certificate.Issuer, certificate.SignatureType, certificate.Signature, certificate.SignedData()
+	}
+	if rootKey == nil {
+		switch {
+		case unpinned == "":
+			return SignatureNintendo, nil
+		case len(nintendoCertificates) == 0:
+			return SignatureChainUnverified, nil
+		default:
+			return SignatureInvalid, fmt.Errorf("certificate %s is not Nintendo's", unpinned)
+		}
+	}
+	if err := cert.VerifyRSA(rootKey, ROOT_KEY_EXPONENT, signatureType, signature, data); err != nil {
+		return SignatureInvalid, fmt.Errorf("signature does not match the root key: %w", err)
+	}
+	return SignatureNintendo, nil
+}
+
+// isFakeSignature reports whether signature is zeroed, as in fakesigned Wii
+// files, or filled with fakeSignatureFiller.
+func isFakeSignature(signature []byte) bool {
+	filler := bytes.Repeat(fakeSignatureFiller, len(signature)/len(fakeSignatureFiller)+1)[:len(signature)]
+	return bytes.Equal(signature, filler) || bytes.Count(signature, []byte{0}) == len(signature)
+}
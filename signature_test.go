@@ -0,0 +1,198 @@
+package wiiudownloader
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Xpl0itU/WiiUDownloader/internal/formats/cert"
+	ticketfmt "github.com/Xpl0itU/WiiUDownloader/internal/formats/ticket"
+	tmdfmt "github.com/Xpl0itU/WiiUDownloader/internal/formats/tmd"
+)
+
+// testChain is a CA certificate and the CP and XS certificates it signs,
+// named like Nintendo's. The CA certificate itself is not signed.
+type testChain struct {
+	ca, cp, xs          []byte
+	caKey, cpKey, xsKey *rsa.PrivateKey
+}
+
+func newTestChain(t *testing.T) *testChain {
+	t.Helper()
+	chain := &testChain{}
+	for _, key := range []**rsa.PrivateKey{&chain.caKey, &chain.cpKey, &chain.xsKey} {
+		var err error
+		if *key, err = rsa.GenerateKey(rand.Reader, 2048); err != nil {
+			t.Fatal(err)
+		}
+	}
+	chain.ca = testCertificate(t, nil, cert.SignatureRSA4096SHA256, "Root", "CA00000003", &chain.caKey.PublicKey)
+	chain.cp = testCertificate(t, chain.caKey, cert.SignatureRSA2048SHA256, "Root-CA00000003", "CP0000000b", &chain.cpKey.PublicKey)
+	chain.xs = testCertificate(t, chain.caKey, cert.SignatureRSA2048SHA256, "Root-CA00000003", "XS0000000c", &chain.xsKey.PublicKey)
+	return chain
+}
+
+func (c *testChain) certificates() []byte {
+	return append(append(bytes.Clone(c.ca), c.cp...), c.xs...)
+}
+
+// pin makes the certificates of the chain the pinned Nintendo ones for the
+// rest of the test.
+func (c *testChain) pin(t *testing.T) {
+	saved := nintendoCertificates
+	t.Cleanup(func() { nintendoCertificates = saved })
+	nintendoCertificates = map[string][sha256.Size]byte{
+		"Root-CA00000003":            sha256.Sum256(c.ca),
+		"Root-CA00000003-CP0000000b": sha256.Sum256(c.cp),
+		"Root-CA00000003-XS0000000c": sha256.Sum256(c.xs),
+	}
+}
+
+// testCertificate returns a certificate for the RSA-2048 key public, signed
+// by signer unless it is nil.
+func testCertificate(t *testing.T, signer *rsa.PrivateKey, signatureType uint32, issuer, name string, public *rsa.PublicKey) []byte {
+	t.Helper()
+	size, padding, _ := cert.SignatureSize(signatureType)
+	data := binary.BigEndian.AppendUint32(nil, signatureType)
+	data = append(data, make([]byte, size+padding+0x40)...)
+	copy(data[4+size+padding:], issuer)
+	data = binary.BigEndian.AppendUint32(data, cert.KeyRSA2048)
+	data = append(data, make([]byte, 0x40)...)
+	copy(data[len(data)-0x40:], name)
+	data = binary.BigEndian.AppendUint32(data, 0)
+	data = append(data, public.N.FillBytes(make([]byte, 0x100))...)
+	data = binary.BigEndian.AppendUint32(data, uint32(public.E))
+	data = append(data, make([]byte, 0x34)...)
+	if signer != nil {
+		copy(data[4:], testSign(t, signer, data[4+size+padding:]))
+	}
+	return data
+}
+
+func testSign(t *testing.T, key *rsa.PrivateKey, data []byte) []byte {
+	t.Helper()
+	digest := sha256.Sum256(data)
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signature
+}
+
+// signTestFile signs the TMD or ticket at path in place with key.
+func signTestFile(t *testing.T, path string, key *rsa.PrivateKey, tmd bool) {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var signed []byte
+	if tmd {
+		parsed, err := tmdfmt.Parse(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		signed, err = parsed.SignedData()
+		if err != nil {
+			t.Fatal(err)
+		}
+	} else {
+		parsed, err := ticketfmt.Parse(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		signed, err = parsed.SignedData()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	copy(data[4:], testSign(t, key, signed))
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// writeSignedTestTitle writes a Wii U title whose TMD and ticket are signed by
+// chain, with the chain in title.cert.
+func writeSignedTestTitle(t *testing.T, chain *testChain) string {
+	t.Helper()
+	dir := t.TempDir()
+	src, original, title := filepath.Join(dir, "src"), filepath.Join(dir, "original"), filepath.Join(dir, "title")
+	writeTestFiles(t, src, map[string][]byte{"meta/meta.xml": []byte("<menu/>\n")})
+	writeTestWiiUOriginal(t, original)
+	if err := RepackTitle(context.Background(), src, title, RepackTitleOptions{Original: original}); err != nil {
+		t.Fatalf("RepackTitle: %v", err)
+	}
+	signTestFile(t, filepath.Join(title, "title.tmd"), chain.cpKey, true)
+	signTestFile(t, filepath.Join(title, "title.tik"), chain.xsKey, false)
+	if err := os.WriteFile(filepath.Join(title, "title.cert"), chain.certificates(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return title
+}
+
+func TestVerifyTitleSignatures(t *testing.T) {
+	nintendo := newTestChain(t)
+	nintendo.pin(t)
+
+	check := func(t *testing.T, title string, wantTMD, wantTicket SignatureStatus) {
+		t.Helper()
+		report, err := VerifyTitleSignatures(title, SignatureOptions{})
+		if err != nil {
+			t.Fatalf("VerifyTitleSignatures: %v", err)
+		}
+		if report.TMD != wantTMD || report.Ticket != wantTicket {
+			t.Fatalf("TMD %v (%v), ticket %v (%v); want %v and %v", report.TMD, report.TMDErr, report.Ticket, report.TicketErr, wantTMD, wantTicket)
+		}
+	}
+
+	t.Run("Pristine", func(t *testing.T) {
+		title := writeSignedTestTitle(t, nintendo)
+		check(t, title, SignatureNintendo, SignatureNintendo)
+	})
+
+	t.Run("Fakesigned", func(t *testing.T) {
+		title := writeSignedTestTitle(t, nintendo)
+		titleKey := make([]byte, ticketfmt.TitleKeySize)
+		if err := GenerateTicket(filepath.Join(title, "title.tik"), testTitleID, titleKey, 32); err != nil {
+			t.Fatal(err)
+		}
+		check(t, title, SignatureNintendo, SignatureFakesigned)
+	})
+
+	t.Run("Tampered", func(t *testing.T) {
+		title := writeSignedTestTitle(t, nintendo)
+		tmdPath := filepath.Join(title, "title.tmd")
+		data, err := os.ReadFile(tmdPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data[0x1DC] ^= 1
+		if err := os.WriteFile(tmdPath, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		check(t, title, SignatureInvalid, SignatureNintendo)
+	})
+
+	t.Run("Resigned", func(t *testing.T) {
+		// A chain made up with the same names signs the title as well as
+		// Nintendo's would.
+		title := writeSignedTestTitle(t, newTestChain(t))
+		check(t, title, SignatureInvalid, SignatureInvalid)
+	})
+
+	t.Run("Unpinned", func(t *testing.T) {
+		saved := nintendoCertificates
+		t.Cleanup(func() { nintendoCertificates = saved })
+		nintendoCertificates = map[string][sha256.Size]byte{}
+		title := writeSignedTestTitle(t, nintendo)
+		check(t, title, SignatureChainUnverified, SignatureChainUnverified)
+	})
+}
@@ -1,25 +1,15 @@
 package wiiudownloader
 
 import (
-	"encoding/binary"
 	"encoding/hex"
 	"fmt"
 	"os"
 	"sync"
-)
 
-const (
-	// Offsets below follow Nintendo's ticket binary layout.
-	TICKET_ENCRYPTED_KEY_OFFSET = 0x1BF
-	TICKET_ENCRYPTED_KEY_SIZE   = 0x10
-	TICKET_KEY_INDEX_OFFSET     = 0x1F1
-	TICKET_TITLE_ID_OFFSET      = 476
-	TICKET_TITLE_ID_SIZE        = 8
-	TICKET_TITLE_VERSION_OFFSET = 486
-	TICKET_TITLE_VERSION_SIZE   = 2
+	ticketfmt "github.com/Xpl0itU/WiiUDownloader/internal/formats/ticket"
 )
 
-// Immutable default ticket payload; runtime fields are patched at known offsets.
+// Immutable default ticket payload; GenerateTicket fills in the title fields.
 const TICKET_TEMPLATE_HEX = "" +
 	"00010004d15ea5ed15abe11ad15ea5ed15abe11ad15ea5ed15abe11ad15ea5ed" +
 	"15abe11ad15ea5ed15abe11ad15ea5ed15abe11ad15ea5ed15abe11ad15ea5ed" +
@@ -49,69 +39,94 @@ const TICKET_TEMPLATE_HEX = "" +
 	"0000000000000000000000000000000000000000000000000000000000000000" +
 	"00000000000000000000000000000000"
 
+// Ticket is a parsed title.tik. TitleKey is still encrypted with the common
+// key selected by CommonKeyIndex.
+type Ticket struct {
+	SignatureType  uint32
+	Issuer         string
+	Version        byte
+	TitleKey       []byte
+	TicketID       uint64
+	ConsoleID      uint32
+	TitleID        uint64
+	TitleVersion   uint16
+	CommonKeyIndex byte
+	// ContentAccessPermissions has one bit per content index.
+	ContentAccessPermissions []byte
+	Limits                   []TicketLimit
+}
+
+// TicketLimit is a usage limit of a ticket, such as a play time or launch
+// count.
+type TicketLimit struct {
+	Type  uint32
+	Value uint32
+}
+
+func ParseTicket(data []byte) (*Ticket, error) {
+	parsed, err := ticketfmt.Parse(data)
+	if err != nil {
+		return nil, err
+	}
+
+	out := &Ticket{
+		SignatureType:            parsed.SignatureType,
+		Issuer:                   parsed.Issuer,
+		Version:                  parsed.Version,
+		TitleKey:                 append([]byte(nil), parsed.TitleKey[:]...),
+		TicketID:                 parsed.TicketID,
+		ConsoleID:                parsed.ConsoleID,
+		TitleID:                  parsed.TitleID,
+		TitleVersion:             parsed.TitleVersion,
+		CommonKeyIndex:           parsed.CommonKeyIndex,
+		ContentAccessPermissions: append([]byte(nil), parsed.ContentAccessPermissions[:]...),
+	}
+	for _, limit := range parsed.Limits {
+		if limit.Type != 0 {
+			out.Limits = append(out.Limits, TicketLimit{Type: limit.Type, Value: limit.Value})
+		}
+	}
+	return out, nil
+}
+
 var (
 	ticketTemplateOnce sync.Once
-	ticketTemplateData []byte
+	ticketTemplate     *ticketfmt.Ticket
 	ticketTemplateErr  error
 )
 
 func GenerateTicket(path string, titleID uint64, titleKey []byte, titleVersion uint16) error {
-	if len(titleKey) < TICKET_ENCRYPTED_KEY_SIZE {
-		return fmt.Errorf("title key must be at least %d bytes, got %d", TICKET_ENCRYPTED_KEY_SIZE, len(titleKey))
+	if len(titleKey) < ticketfmt.TitleKeySize {
+		return fmt.Errorf("title key must be at least %d bytes, got %d", ticketfmt.TitleKeySize, len(titleKey))
 	}
 
-	ticketFile, err := os.Create(path)
+	ticket, err := newTicket()
 	if err != nil {
 		return err
 	}
-	defer ticketFile.Close()
+	copy(ticket.TitleKey[:], titleKey)
+	ticket.TitleID = titleID
+	ticket.TitleVersion = titleVersion
 
-	ticketData, err := newTicketData()
+	ticketData, err := ticket.Marshal()
 	if err != nil {
 		return err
 	}
-
-	copy(ticketData[TICKET_ENCRYPTED_KEY_OFFSET:TICKET_ENCRYPTED_KEY_OFFSET+TICKET_ENCRYPTED_KEY_SIZE], titleKey[:TICKET_ENCRYPTED_KEY_SIZE])
-
-	var titleIDBytes [TICKET_TITLE_ID_SIZE]byte
-	binary.BigEndian.PutUint64(titleIDBytes[:], titleID)
-	copy(ticketData[TICKET_TITLE_ID_OFFSET:TICKET_TITLE_ID_OFFSET+TICKET_TITLE_ID_SIZE], titleIDBytes[:])
-
-	var versionBytes [TICKET_TITLE_VERSION_SIZE]byte
-	binary.BigEndian.PutUint16(versionBytes[:], titleVersion)
-	copy(ticketData[TICKET_TITLE_VERSION_OFFSET:TICKET_TITLE_VERSION_OFFSET+TICKET_TITLE_VERSION_SIZE], versionBytes[:])
-
-	_, err = ticketFile.Write(ticketData)
-	if err != nil {
-		return err
-	}
-
-	return nil
+	return os.WriteFile(path, ticketData, downloadFilePerm)
 }
 
-func newTicketData() ([]byte, error) {
+// newTicket returns a copy of the ticket template.
+func newTicket() (*ticketfmt.Ticket, error) {
 	ticketTemplateOnce.Do(func() {
-		ticketTemplateData, ticketTemplateErr = hex.DecodeString(TICKET_TEMPLATE_HEX)
-		if ticketTemplateErr != nil {
+		data, err := hex.DecodeString(TICKET_TEMPLATE_HEX)
+		if err != nil {
+			ticketTemplateErr = err
 			return
 		}
-
-		requiredSize := TICKET_ENCRYPTED_KEY_OFFSET + TICKET_ENCRYPTED_KEY_SIZE
-		if TICKET_KEY_INDEX_OFFSET+1 > requiredSize {
-			requiredSize = TICKET_KEY_INDEX_OFFSET + 1
-		}
-		if TICKET_TITLE_ID_OFFSET+TICKET_TITLE_ID_SIZE > requiredSize {
-			requiredSize = TICKET_TITLE_ID_OFFSET + TICKET_TITLE_ID_SIZE
-		}
-		if TICKET_TITLE_VERSION_OFFSET+TICKET_TITLE_VERSION_SIZE > requiredSize {
-			requiredSize = TICKET_TITLE_VERSION_OFFSET + TICKET_TITLE_VERSION_SIZE
-		}
-		if len(ticketTemplateData) < requiredSize {
-			ticketTemplateErr = fmt.Errorf("ticket template too small: got %d, need at least %d", len(ticketTemplateData), requiredSize)
-		}
+		ticketTemplate, ticketTemplateErr = ticketfmt.Parse(data)
 	})
 	if ticketTemplateErr != nil {
-		return nil, ticketTemplateErr
+		return nil, fmt.Errorf("invalid ticket template: %w", ticketTemplateErr)
 	}
-	return append([]byte(nil), ticketTemplateData...), nil
+	return ticketTemplate.Clone(), nil
 }
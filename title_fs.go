@@ -0,0 +1,301 @@
+package wiiudownloader
+
+import (
+	"crypto/cipher"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	fstfmt "github.com/Xpl0itU/WiiUDownloader/internal/formats/fst"
+)
+
+// TitleFS is a read-only view of the files of an encrypted Wii U title folder,
+// as they would be extracted by DecryptContents. Files are decrypted as they
+// are read, so nothing is written to disk and a file can be read at any
+// offset. Blocks of contents with a hash tree are checked as they are read;
+// contents without one are not checked against the TMD, use VerifyTitle for
+// that. A TitleFS is safe for concurrent use.
+type TitleFS struct {
+	path           string
+	tmd            *TMD
+	cipherHashTree cipher.Block
+	modTime        time.Time
+	root           *titleFSNode
+}
+
+type titleFSNode struct {
+	name     string
+	dir      bool
+	children []*titleFSNode
+	// content is the index in the TMD of the content holding a file.
+	content int
+	offset  uint64
+	size    int64
+}
+
+// OpenTitleFS reads the TMD, ticket and FST of the title folder in path. Only
+// content 0 has to be present; opening a file of a missing content fails.
+func OpenTitleFS(path string) (*TitleFS, error) {
+	tmd, cipherHashTree, err := loadTitleCipher(path, false)
+	if err != nil {
+		return nil, err
+	}
+	if tmd.Version != TMD_VERSION_WIIU || len(tmd.Contents) == 0 {
+		return nil, ErrNoFST
+	}
+	info, err := os.Stat(filepath.Join(path, "title.tmd"))
+	if err != nil {
+		return nil, err
+	}
+	table, err := readFST(path, tmd, cipherHashTree)
+	if err != nil {
+		return nil, err
+	}
+	if table == nil {
+		return nil, ErrNoFST
+	}
+
+	fsys := &TitleFS{
+		path:           path,
+		tmd:            tmd,
+		cipherHashTree: cipherHashTree,
+		modTime:        info.ModTime(),
+		root:           &titleFSNode{name: ".", dir: true},
+	}
+	if err := fsys.addFSTEntries(table); err != nil {
+		return nil, err
+	}
+	return fsys, nil
+}
+
+// addFSTEntries builds the directory tree from the FST. As in extraction,
+// shared entries are left out and the last of several files with the same
+// path wins.
+func (fsys *TitleFS) addFSTEntries(table *fstfmt.Table) error {
+	dirs := map[string]*titleFSNode{".": fsys.root}
+	err := walkFST(table, func(index uint32, parents []string, name string, entry fstfmt.Entry) error {
+		isDir := entry.Type&FST_DIRECTORY_TYPE_FLAG != 0
+		if !isDir && entry.Type&FST_SHARED_CONTENT_FLAG != 0 {
+			return nil
+		}
+		// The parents were checked when they were added.
+		if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+			return fmt.Errorf("unsafe path in content metadata: %q", name)
+		}
+		parentPath := path.Join(parents...)
+		if parentPath == "" {
+			parentPath = "."
+		}
+		parent, ok := dirs[parentPath]
+		if !ok {
+			return fmt.Errorf("FST directory %q is shadowed by a file", parentPath)
+		}
+		fullPath := path.Join(parentPath, name)
+
+		node := &titleFSNode{name: name, dir: isDir}
+		if isDir {
+			if _, ok := dirs[fullPath]; ok {
+				return nil
+			}
+			dirs[fullPath] = node
+		} else {
+			if _, ok := dirs[fullPath]; ok {
+				return fmt.Errorf("FST file %q is shadowed by a directory", fullPath)
+			}
+			if int(entry.ContentID) >= len(fsys.tmd.Contents) {
+				return fmt.Errorf("invalid content index %d", entry.ContentID)
+			}
+			node.content = int(entry.ContentID)
+			node.offset = fstFileOffset(table, entry)
+			node.size = int64(entry.Length)
+		}
+		if i := slices.IndexFunc(parent.children, func(child *titleFSNode) bool { return child.name == name }); i >= 0 {
+			parent.children[i] = node
+		} else {
+			parent.children = append(parent.children, node)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, dir := range dirs {
+		slices.SortFunc(dir.children, func(a, b *titleFSNode) int { return strings.Compare(a.name, b.name) })
+	}
+	return nil
+}
+
+// TMD returns the TMD of the title.
+func (fsys *TitleFS) TMD() *TMD {
+	return fsys.tmd
+}
+
+func (fsys *TitleFS) lookup(op, name string) (*titleFSNode, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	node := fsys.root
+	if name == "." {
+		return node, nil
+	}
+	for _, element := range strings.Split(name, "/") {
+		i, found := slices.BinarySearchFunc(node.children, element, func(child *titleFSNode, target string) int {
+			return strings.Compare(child.name, target)
+		})
+		if !node.dir || !found {
+			return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+		}
+		node = node.children[i]
+	}
+	return node, nil
+}
+
+// Open implements fs.FS. Files also implement io.ReaderAt and io.Seeker.
+func (fsys *TitleFS) Open(name string) (fs.File, error) {
+	node, err := fsys.lookup("open", name)
+	if err != nil {
+		return nil, err
+	}
+	if node.dir {
+		return &titleFSDir{fsys: fsys, node: node}, nil
+	}
+
+	reader, err := openContentReader(fsys.path, fsys.tmd.Contents[node.content], fsys.cipherHashTree)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	var data io.ReaderAt = reader
+	if fsys.tmd.Contents[node.content].Type&CONTENT_TYPE_HASHED != 0 {
+		data = hashedDataReader{reader}
+	}
+	return &titleFSFile{
+		SectionReader: io.NewSectionReader(data, int64(node.offset), node.size),
+		fsys:          fsys,
+		node:          node,
+		reader:        reader,
+	}, nil
+}
+
+// ReadDir implements fs.ReadDirFS.
+func (fsys *TitleFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	node, err := fsys.lookup("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	if !node.dir {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fmt.Errorf("not a directory")}
+	}
+	entries := make([]fs.DirEntry, len(node.children))
+	for i, child := range node.children {
+		entries[i] = fs.FileInfoToDirEntry(fsys.fileInfo(child))
+	}
+	return entries, nil
+}
+
+// Stat implements fs.StatFS.
+func (fsys *TitleFS) Stat(name string) (fs.FileInfo, error) {
+	node, err := fsys.lookup("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return fsys.fileInfo(node), nil
+}
+
+func (fsys *TitleFS) fileInfo(node *titleFSNode) fs.FileInfo {
+	return titleFSFileInfo{node: node, modTime: fsys.modTime}
+}
+
+type titleFSFileInfo struct {
+	node    *titleFSNode
+	modTime time.Time
+}
+
+func (fi titleFSFileInfo) Name() string       { return fi.node.name }
+func (fi titleFSFileInfo) Size() int64        { return fi.node.size }
+func (fi titleFSFileInfo) ModTime() time.Time { return fi.modTime }
+func (fi titleFSFileInfo) IsDir() bool        { return fi.node.dir }
+func (fi titleFSFileInfo) Sys() any           { return nil }
+
+func (fi titleFSFileInfo) Mode() fs.FileMode {
+	if fi.node.dir {
+		return fs.ModeDir | 0o555
+	}
+	return 0o444
+}
+
+type titleFSFile struct {
+	*io.SectionReader
+	fsys   *TitleFS
+	node   *titleFSNode
+	reader *contentReader
+}
+
+func (f *titleFSFile) Stat() (fs.FileInfo, error) {
+	return f.fsys.fileInfo(f.node), nil
+}
+
+func (f *titleFSFile) Close() error {
+	return f.reader.Close()
+}
+
+type titleFSDir struct {
+	fsys   *TitleFS
+	node   *titleFSNode
+	offset int
+}
+
+func (d *titleFSDir) Stat() (fs.FileInfo, error) {
+	return d.fsys.fileInfo(d.node), nil
+}
+
+func (d *titleFSDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.node.name, Err: fmt.Errorf("is a directory")}
+}
+
+func (d *titleFSDir) Close() error {
+	return nil
+}
+
+// ReadDir implements fs.ReadDirFile.
+func (d *titleFSDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	children := d.node.children[d.offset:]
+	if n > 0 {
+		if len(children) == 0 {
+			return nil, io.EOF
+		}
+		children = children[:min(n, len(children))]
+	}
+	d.offset += len(children)
+	entries := make([]fs.DirEntry, len(children))
+	for i, child := range children {
+		entries[i] = fs.FileInfoToDirEntry(d.fsys.fileInfo(child))
+	}
+	return entries, nil
+}
+
+// hashedDataReader reads the data of a content with a hash tree without the
+// hash headers of its blocks, which is how the FST addresses files in it.
+type hashedDataReader struct {
+	reader *contentReader
+}
+
+func (r hashedDataReader) ReadAt(p []byte, off int64) (int, error) {
+	n := 0
+	for n < len(p) {
+		block, within := off/HASH_BLOCK_SIZE, off%HASH_BLOCK_SIZE
+		chunk := p[n:min(len(p), n+int(HASH_BLOCK_SIZE-within))]
+		m, err := r.reader.ReadAt(chunk, block*BLOCK_SIZE_HASHED+HASHES_SIZE+within)
+		n += m
+		off += int64(m)
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
@@ -11,11 +11,25 @@ const (
 	TMD_VERSION_WIIU = 0x01
 )
 
+const (
+	tidHighWiiIOS = 0x00000001
+	// The Wii U OS titles are 000500101000400X, X being the OS version.
+	wiiUOSTitleIDLow = 0x10004000
+)
+
 type TMD struct {
-	TitleID      uint64
-	Version      byte
+	TitleID uint64
+	Version byte
+	// SystemVersion is the title ID of the IOS or OS the title requires.
+	SystemVersion uint64
+	TitleType     uint32
+	GroupID       uint16
+	// Region is only meaningful for Wii titles, see GetFormattedTMDRegion.
+	Region       uint16
+	AccessRights uint32
 	TitleVersion uint16
 	ContentCount uint16
+	BootIndex    uint16
 	Contents     []Content
 	Certificate1 []byte
 	Certificate2 []byte
@@ -37,13 +51,19 @@ func ParseTMD(data []byte) (*TMD, error) {
 	}
 
 	out := &TMD{
-		TitleID:      parsed.TitleID,
-		Version:      parsed.Version,
-		TitleVersion: parsed.TitleVersion,
-		ContentCount: parsed.ContentCount,
-		Contents:     make([]Content, len(parsed.Contents)),
-		Certificate1: append([]byte(nil), parsed.Certificate1...),
-		Certificate2: append([]byte(nil), parsed.Certificate2...),
+		TitleID:       parsed.TitleID,
+		Version:       parsed.Version,
+		SystemVersion: parsed.SystemVersion,
+		TitleType:     parsed.TitleType,
+		GroupID:       parsed.GroupID,
+		Region:        parsed.Region,
+		AccessRights:  parsed.AccessRights,
+		TitleVersion:  parsed.TitleVersion,
+		ContentCount:  parsed.ContentCount,
+		BootIndex:     parsed.BootIndex,
+		Contents:      make([]Content, len(parsed.Contents)),
+		Certificate1:  append([]byte(nil), parsed.Certificate1...),
+		Certificate2:  append([]byte(nil), parsed.Certificate2...),
 	}
 	for i, content := range parsed.Contents {
 		out.Contents[i] = Content{
@@ -61,3 +81,38 @@ func ParseTMD(data []byte) (*TMD, error) {
 		return nil, fmt.Errorf("unknown TMD version: %d", out.Version)
 	}
 }
+
+// GetFormattedSystemVersion returns the IOS or OS a title requires, like
+// "IOS58" or "OSv10", followed by its title ID.
+func GetFormattedSystemVersion(systemVersion uint64) string {
+	high, low := uint32(systemVersion>>32), uint32(systemVersion)
+	switch {
+	case systemVersion == 0:
+		return "none"
+	case high == tidHighWiiIOS || high == TID_HIGH_VWII_IOS:
+		return fmt.Sprintf("IOS%d (%016x)", low, systemVersion)
+	case high == TID_HIGH_SYSTEM_APP && low&^0xFF == wiiUOSTitleIDLow:
+		return fmt.Sprintf("OSv%d (%016x)", low&0xFF, systemVersion)
+	default:
+		return fmt.Sprintf("%016x", systemVersion)
+	}
+}
+
+// GetFormattedTMDRegion returns the region in the TMD of a Wii title. Wii U
+// titles keep their region in meta.xml instead.
+func GetFormattedTMDRegion(region uint16) string {
+	switch region {
+	case 0:
+		return "Japan"
+	case 1:
+		return "USA"
+	case 2:
+		return "Europe"
+	case 3:
+		return "All"
+	case 4:
+		return "Korea"
+	default:
+		return "Unknown"
+	}
+}
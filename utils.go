@@ -40,3 +40,36 @@ func hasRemovableEncryptedExtension(name string) bool {
 	}
 	return false
 }
+
+func NormalizeFilename(filename string) string {
+	var out strings.Builder
+	shouldAppend := true
+	firstChar := true
+
+	for _, c := range filename {
+		switch {
+		case c == '_':
+			if shouldAppend {
+				out.WriteRune('_')
+				shouldAppend = false
+			}
+			firstChar = false
+		case c == ' ':
+			if shouldAppend && !firstChar {
+				out.WriteRune(' ')
+				shouldAppend = false
+			}
+		case (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9'):
+			out.WriteRune(c)
+			shouldAppend = true
+			firstChar = false
+		}
+	}
+
+	result := out.String()
+	if len(result) > 0 && result[len(result)-1] == '_' {
+		result = result[:len(result)-1]
+	}
+
+	return result
+}
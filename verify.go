@@ -0,0 +1,179 @@
+package wiiudownloader
+
+import (
+	"bufio"
+	"crypto/cipher"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Progress is reported at most once per this many verified bytes.
+const verifyProgressInterval = 8 << 20
+
+// ContentReport is the outcome of verifying one content of a title.
+type ContentReport struct {
+	Content Content
+	// Hashed is set for contents checked against their H0-H3 hash tree rather
+	// than the content hash in the TMD.
+	Hashed bool
+	// Err is nil when the content is intact. A missing file is reported with
+	// an error matching fs.ErrNotExist, a truncated one with a
+	// SizeMismatchError and damaged data with a HashMismatchError.
+	Err error
+}
+
+// String describes the content and its verification result.
+func (r ContentReport) String() string {
+	if r.Err == nil {
+		return fmt.Sprintf("%s.app: OK", r.Content.CIDStr)
+	}
+	return fmt.Sprintf("%s.app: %v", r.Content.CIDStr, r.Err)
+}
+
+// VerifyReport lists the outcome of VerifyTitle for every content in the TMD.
+type VerifyReport struct {
+	TMD      *TMD
+	Contents []ContentReport
+}
+
+// OK reports whether every content passed verification.
+func (r *VerifyReport) OK() bool {
+	return len(r.Failed()) == 0
+}
+
+// Failed returns the reports of the contents that did not pass verification.
+func (r *VerifyReport) Failed() []ContentReport {
+	var failed []ContentReport
+	for _, content := range r.Contents {
+		if content.Err != nil {
+			failed = append(failed, content)
+		}
+	}
+	return failed
+}
+
+// VerifyTitle checks every content of the title folder in path without writing
+// anything: contents are decrypted in memory and compared against their TMD
+// hash, or against their full H0-H3 hash tree for hashed contents. The error is
+// only set when the title cannot be verified at all, e.g. because title.tmd or
+// title.tik is missing; problems with single contents are listed in the
+// report. ErrCancelled is returned when the progress reporter is cancelled.
+func VerifyTitle(path string, progressReporter ProgressReporter) (*VerifyReport, error) {
+	tmd, err := readTitleTMD(path)
+	if err != nil {
+		return nil, err
+	}
+	cipherHashTree, err := titleKeyCipher(path, tmd)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &VerifyReport{TMD: tmd}
+	progress := &verifyProgress{reporter: progressReporter}
+	for _, content := range tmd.Contents {
+		progress.total += content.Size
+	}
+	for i := range tmd.Contents {
+		if isCancelled(progressReporter) {
+			return report, ErrCancelled
+		}
+		content := &tmd.Contents[i]
+		contentReport := ContentReport{Hashed: content.Type&CONTENT_TYPE_HASHED != 0}
+		contentReport.Err = resolveContentFileName(path, content)
+		if contentReport.Err == nil {
+			contentReport.Err = verifyContent(path, *content, cipherHashTree, progress)
+		}
+		if errors.Is(contentReport.Err, ErrCancelled) {
+			return report, ErrCancelled
+		}
+		contentReport.Content = *content
+		report.Contents = append(report.Contents, contentReport)
+		progress.finishContent(content.Size)
+	}
+	if isCancelled(progressReporter) {
+		return report, ErrCancelled
+	}
+	if progressReporter != nil {
+		progressReporter.UpdateDecryptionProgress(1.0)
+	}
+	return report, nil
+}
+
+func verifyContent(path string, content Content, cipherHashTree cipher.Block, progress *verifyProgress) error {
+	appPath := filepath.Join(path, content.CIDStr+".app")
+	info, err := os.Stat(appPath)
+	if err != nil {
+		return err
+	}
+	if expected := expectedContentDownloadSize(content); info.Size() != expected {
+		return &SizeMismatchError{File: content.CIDStr + ".app", Expected: expected, Actual: info.Size()}
+	}
+
+	var h3Data []byte
+	if content.Type&CONTENT_TYPE_HASHED != 0 {
+		if h3Data, err = readH3File(filepath.Join(path, content.CIDStr+".h3"), content); err != nil {
+			return err
+		}
+	}
+
+	src, err := os.Open(appPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	reader := bufio.NewReaderSize(&verifyProgressReader{reader: src, progress: progress}, BLOCK_SIZE_HASHED)
+
+	if h3Data != nil {
+		return verifyHashedContent(reader, h3Data, content, cipherHashTree)
+	}
+	return decryptContent(reader, io.Discard, cipherHashTree, content)
+}
+
+// verifyProgress turns the bytes read by VerifyTitle into decryption progress.
+type verifyProgress struct {
+	reporter    ProgressReporter
+	total       uint64
+	done        uint64
+	contentRead uint64
+	sinceUpdate uint64
+}
+
+func (p *verifyProgress) add(n int) {
+	p.contentRead += uint64(n)
+	p.sinceUpdate += uint64(n)
+	if p.sinceUpdate >= verifyProgressInterval {
+		p.sinceUpdate = 0
+		p.update(p.done + p.contentRead)
+	}
+}
+
+// finishContent accounts for a whole content, however much of it was read.
+func (p *verifyProgress) finishContent(size uint64) {
+	p.done += size
+	p.contentRead = 0
+	p.update(p.done)
+}
+
+func (p *verifyProgress) update(done uint64) {
+	if p.reporter == nil || p.total == 0 {
+		return
+	}
+	p.reporter.UpdateDecryptionProgress(float64(min(done, p.total)) / float64(p.total))
+}
+
+type verifyProgressReader struct {
+	reader   io.Reader
+	progress *verifyProgress
+}
+
+func (r *verifyProgressReader) Read(p []byte) (int, error) {
+	if isCancelled(r.progress.reporter) {
+		return 0, ErrCancelled
+	}
+	n, err := r.reader.Read(p)
+	r.progress.add(n)
+	return n, err
+}
@@ -0,0 +1,355 @@
+package wiiudownloader
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Xpl0itU/WiiUDownloader/internal/formats/cert"
+	ticketfmt "github.com/Xpl0itU/WiiUDownloader/internal/formats/ticket"
+	"github.com/Xpl0itU/WiiUDownloader/internal/formats/wad"
+)
+
+const (
+	// Wii TMDs are shorter than Wii U ones, and a WAD stores them without the
+	// certificates the CDN appends.
+	wiiTMDHeaderSize       = 0x1E4
+	wiiTMDContentEntrySize = 0x24
+	signedIssuerSize       = 0x40
+)
+
+// ExportWAD writes the encrypted Wii or vWii title in path as an installable
+// WAD at dest, which is only replaced once the WAD is complete. The certificate
+// chain is taken from title.cert and the certificates appended to title.tmd and
+// title.tik. ErrCancelled is returned when the progress reporter is cancelled.
+func ExportWAD(path, dest string, progressReporter ProgressReporter) error {
+	tmdData, err := os.ReadFile(filepath.Join(path, "title.tmd"))
+	if err != nil {
+		return err
+	}
+	tmd, err := ParseTMD(tmdData)
+	if err != nil {
+		return err
+	}
+	if tmd.Version != TMD_VERSION_WII {
+		return errors.New("WADs can only be built from Wii and vWii titles")
+	}
+	tmdSize := wiiTMDHeaderSize + wiiTMDContentEntrySize*int(tmd.ContentCount)
+
+	ticketData, err := os.ReadFile(filepath.Join(path, "title.tik"))
+	if err != nil {
+		return err
+	}
+	ticket, err := ticketfmt.Parse(ticketData)
+	if err != nil {
+		return fmt.Errorf("title.tik: %w", err)
+	}
+	if ticket.Version != ticketfmt.VersionWii {
+		return errors.New("title.tik is not a Wii ticket")
+	}
+	// The ticket is copied as it is, since re-serialising it could change
+	// the signed bytes.
+	ticketCertificates := ticket.Certificates
+	ticketData = ticketData[:len(ticketData)-len(ticketCertificates)]
+
+	certData, err := os.ReadFile(filepath.Join(path, "title.cert"))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	certificates, err := parseCertificatePool(certData, tmdData[tmdSize:], ticketCertificates)
+	if err != nil {
+		return err
+	}
+	tmdIssuer, err := signedIssuer(tmdData)
+	if err != nil {
+		return fmt.Errorf("title.tmd: %w", err)
+	}
+	chain, err := certificateChain(certificates, tmdIssuer, ticket.Issuer)
+	if err != nil {
+		return err
+	}
+
+	if err := resolveContentFileNames(path, tmd); err != nil {
+		return err
+	}
+	var dataSize int64
+	for _, content := range tmd.Contents {
+		dataSize += wad.Align(expectedContentDownloadSize(content))
+	}
+	if dataSize > 0xFFFFFFFF {
+		return errors.New("title is too large for a WAD")
+	}
+
+	header := &wad.Header{
+		HeaderSize:    wad.HeaderSize,
+		Type:          wad.TypeInstallable,
+		CertChainSize: uint32(len(chain)),
+		TicketSize:    uint32(len(ticketData)),
+		TMDSize:       uint32(tmdSize),
+		DataSize:      uint32(dataSize),
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(dest), filepath.Base(dest)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := tmp.Chmod(downloadFilePerm); err != nil {
+		tmp.Close()
+		return err
+	}
+	w := bufio.NewWriterSize(tmp, BLOCK_SIZE_HASHED)
+	err = writeWAD(w, path, header, chain, ticketData, tmdData[:tmdSize], tmd, progressReporter)
+	if err == nil {
+		err = w.Flush()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return wrapDiskFull(err)
+	}
+	return os.Rename(tmp.Name(), dest)
+}
+
+func writeWAD(w io.Writer, path string, header *wad.Header, chain, ticket, tmdData []byte, tmd *TMD, progressReporter ProgressReporter) error {
+	for _, section := range [][]byte{header.Marshal(), chain, ticket, tmdData} {
+		if err := writeWADSection(w, section); err != nil {
+			return err
+		}
+	}
+	progress := &byteProgress{reporter: progressReporter, total: uint64(header.DataSize)}
+	for _, content := range tmd.Contents {
+		if isCancelled(progressReporter) {
+			return ErrCancelled
+		}
+		size := expectedContentDownloadSize(content)
+		file, err := os.Open(filepath.Join(path, content.CIDStr+".app"))
+		if err != nil {
+			return err
+		}
+		_, err = io.CopyN(w, file, size)
+		file.Close()
+		if err == io.EOF {
+			// A short .app would shift every following content.
+			return fmt.Errorf("%s.app is shorter than %d bytes", content.CIDStr, size)
+		}
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(make([]byte, wad.Align(size)-size)); err != nil {
+			return err
+		}
+		if progress.add(uint64(size)) {
+			return ErrCancelled
+		}
+	}
+	if progressReporter != nil {
+		progressReporter.UpdateDecryptionProgress(1.0)
+	}
+	return nil
+}
+
+func writeWADSection(w io.Writer, data []byte) error {
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	_, err := w.Write(make([]byte, wad.Align(int64(len(data)))-int64(len(data))))
+	return err
+}
+
+// ImportWAD unpacks the WAD at wadPath into dest as title.tmd, title.tik,
+// title.cert and one .app file per content, the layout of a downloaded title,
+// so it can be verified and decrypted like one. dest must not hold a title yet.
+func ImportWAD(wadPath, dest string, progressReporter ProgressReporter) (*TMD, error) {
+	file, err := os.Open(wadPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	headerData := make([]byte, wad.HeaderSize)
+	if _, err := io.ReadFull(file, headerData); err != nil {
+		return nil, fmt.Errorf("invalid WAD: %w", err)
+	}
+	header, err := wad.Parse(headerData)
+	if err != nil {
+		return nil, err
+	}
+	if header.Type != wad.TypeInstallable {
+		return nil, errors.New("WAD does not hold an installable title")
+	}
+	readSection := func(name string, offset int64, size uint32) ([]byte, error) {
+		if offset+int64(size) > info.Size() {
+			return nil, fmt.Errorf("WAD is truncated in the %s", name)
+		}
+		data := make([]byte, size)
+		if _, err := file.ReadAt(data, offset); err != nil {
+			return nil, err
+		}
+		return data, nil
+	}
+	chainData, err := readSection("certificate chain", header.CertChainOffset(), header.CertChainSize)
+	if err != nil {
+		return nil, err
+	}
+	ticketData, err := readSection("ticket", header.TicketOffset(), header.TicketSize)
+	if err != nil {
+		return nil, err
+	}
+	tmdData, err := readSection("TMD", header.TMDOffset(), header.TMDSize)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := ticketfmt.Parse(ticketData); err != nil {
+		return nil, fmt.Errorf("WAD ticket: %w", err)
+	}
+
+	certificates, err := parseCertificatePool(chainData)
+	if err != nil {
+		return nil, err
+	}
+	// Downloaded Wii TMDs are followed by the certificates they are signed
+	// with, which ParseTMD expects.
+	tmdIssuer, err := signedIssuer(tmdData)
+	if err != nil {
+		return nil, fmt.Errorf("TMD: %w", err)
+	}
+	tmdChain, err := certificateChain(certificates, tmdIssuer)
+	if err != nil {
+		return nil, err
+	}
+	tmdData = append(tmdData, tmdChain...)
+	tmd, err := ParseTMD(tmdData)
+	if err != nil {
+		return nil, err
+	}
+	if tmd.Version != TMD_VERSION_WII {
+		return nil, errors.New("WAD does not hold a Wii title")
+	}
+
+	if _, err := os.Stat(filepath.Join(dest, "title.tmd")); err == nil {
+		return nil, fmt.Errorf("%s already holds a title", dest)
+	}
+	if err := os.MkdirAll(dest, 0755); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(dest, "title.cert"), chainData, downloadFilePerm); err != nil {
+		return nil, wrapDiskFull(err)
+	}
+	if err := os.WriteFile(filepath.Join(dest, "title.tik"), ticketData, downloadFilePerm); err != nil {
+		return nil, wrapDiskFull(err)
+	}
+
+	progress := &byteProgress{reporter: progressReporter, total: uint64(header.DataSize)}
+	offset := header.DataOffset()
+	for i := range tmd.Contents {
+		if isCancelled(progressReporter) {
+			return nil, ErrCancelled
+		}
+		content := &tmd.Contents[i]
+		content.CIDStr = fmt.Sprintf("%08X", content.ID)
+		size := expectedContentDownloadSize(*content)
+		if offset+size > info.Size() || offset+size > header.DataOffset()+int64(header.DataSize) {
+			return nil, fmt.Errorf("WAD is missing content %s", content.CIDStr)
+		}
+		if err := writeFileFromReader(filepath.Join(dest, content.CIDStr+".app"), io.NewSectionReader(file, offset, size)); err != nil {
+			return nil, wrapDiskFull(err)
+		}
+		offset += wad.Align(size)
+		if progress.add(uint64(size)) {
+			return nil, ErrCancelled
+		}
+	}
+	// The TMD is written last so an interrupted import is not taken for a
+	// complete title.
+	if err := os.WriteFile(filepath.Join(dest, "title.tmd"), tmdData, downloadFilePerm); err != nil {
+		return nil, wrapDiskFull(err)
+	}
+	if progressReporter != nil {
+		progressReporter.UpdateDecryptionProgress(1.0)
+	}
+	return tmd, nil
+}
+
+func writeFileFromReader(path string, r io.Reader) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, downloadFilePerm)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(file, r)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// parseCertificatePool parses the certificates of every chain, keeping the
+// first one of each name.
+func parseCertificatePool(chains ...[]byte) (map[string]cert.Certificate, error) {
+	certificates := make(map[string]cert.Certificate)
+	for _, data := range chains {
+		chain, err := cert.ParseChain(data)
+		if err != nil {
+			return nil, err
+		}
+		for _, certificate := range chain {
+			if _, ok := certificates[certificate.FullName()]; !ok {
+				certificates[certificate.FullName()] = certificate
+			}
+		}
+	}
+	return certificates, nil
+}
+
+// certificateChain returns the certificates the issuers are signed with, from
+// the root down, once each. For a TMD and a ticket this is the CA, then the TMD
+// signer (CP) and the ticket signer (XS).
+func certificateChain(certificates map[string]cert.Certificate, issuers ...string) ([]byte, error) {
+	var chain []byte
+	added := make(map[string]bool)
+	for _, issuer := range issuers {
+		parts := strings.Split(issuer, "-")
+		for i := 2; i <= len(parts); i++ {
+			name := strings.Join(parts[:i], "-")
+			if added[name] {
+				continue
+			}
+			certificate, ok := certificates[name]
+			if !ok {
+				return nil, fmt.Errorf("certificate %s not found", name)
+			}
+			chain = append(chain, certificate.Raw...)
+			added[name] = true
+		}
+	}
+	return chain, nil
+}
+
+// signedIssuer returns the issuer of a signed TMD or ticket, the full name of
+// the certificate it is signed with, e.g. "Root-CA00000001-CP00000004".
+func signedIssuer(data []byte) (string, error) {
+	if len(data) < 4 {
+		return "", errors.New("signed data is too short")
+	}
+	signatureType := binary.BigEndian.Uint32(data)
+	size, padding, ok := cert.SignatureSize(signatureType)
+	if !ok {
+		return "", fmt.Errorf("unknown signature type %#x", signatureType)
+	}
+	offset := 4 + size + padding
+	if len(data) < offset+signedIssuerSize {
+		return "", errors.New("signed data is too short")
+	}
+	issuer, _, _ := bytes.Cut(data[offset:offset+signedIssuerSize], []byte{0})
+	return string(issuer), nil
+}
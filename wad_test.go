@@ -0,0 +1,129 @@
+package wiiudownloader
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testWiiCertificate returns a certificate with an RSA-2048 key that is not
+// used to sign anything.
+func testWiiCertificate(signatureType uint32, signatureSize int, issuer, name string) []byte {
+	data := binary.BigEndian.AppendUint32(nil, signatureType)
+	data = append(data, make([]byte, signatureSize+0x3C)...)
+	data = append(data, make([]byte, 0x40)...)
+	copy(data[len(data)-0x40:], issuer)
+	data = binary.BigEndian.AppendUint32(data, 1)
+	data = append(data, make([]byte, 0x40)...)
+	copy(data[len(data)-0x40:], name)
+	data = binary.BigEndian.AppendUint32(data, 0)
+	data = append(data, bytes.Repeat([]byte{0xA5}, 0x100)...)
+	data = binary.BigEndian.AppendUint32(data, 0x10001)
+	return append(data, make([]byte, 0x34)...)
+}
+
+// TestWADRoundTrip builds a WAD from an encrypted Wii title folder and checks
+// that the folder unpacked from it verifies and builds the same WAD.
+func TestWADRoundTrip(t *testing.T) {
+	const titleID = 0x0000000100000038
+	dir := t.TempDir()
+	title := filepath.Join(dir, "title")
+	if err := os.MkdirAll(title, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	ca := testWiiCertificate(0x10000, 0x200, "Root", "CA00000001")
+	cp := testWiiCertificate(0x10001, 0x100, "Root-CA00000001", "CP00000004")
+	xs := testWiiCertificate(0x10001, 0x100, "Root-CA00000001", "XS00000003")
+	writeFile := func(name string, data []byte) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(title, name), data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	titleKey := bytes.Repeat([]byte{0x5A}, aes.BlockSize)
+	ticket := make([]byte, 0x2A4)
+	binary.BigEndian.PutUint32(ticket, 0x10001)
+	copy(ticket[0x140:], "Root-CA00000001-XS00000003")
+	copy(ticket[0x1BF:], encryptTestTitleKey(t, wiiCommonKeys[0], titleID, titleKey))
+	binary.BigEndian.PutUint64(ticket[0x1DC:], titleID)
+	writeFile("title.tik", append(append(bytes.Clone(ticket), xs...), ca...))
+	writeFile("title.cert", append(append(bytes.Clone(ca), cp...), xs...))
+
+	sizes := []int{1000, 70_001, 5}
+	tmd := make([]byte, 0x1E4+0x24*len(sizes))
+	binary.BigEndian.PutUint32(tmd, 0x10001)
+	copy(tmd[0x140:], "Root-CA00000001-CP00000004")
+	binary.BigEndian.PutUint64(tmd[0x18C:], titleID)
+	binary.BigEndian.PutUint16(tmd[0x1DC:], 5661)
+	binary.BigEndian.PutUint16(tmd[0x1DE:], uint16(len(sizes)))
+	block, err := aes.NewCipher(titleKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	random := rand.NewChaCha8([32]byte{2})
+	for i, size := range sizes {
+		data := make([]byte, size)
+		random.Read(data)
+		record := tmd[0x1E4+0x24*i:]
+		binary.BigEndian.PutUint32(record, uint32(0x10+i))
+		binary.BigEndian.PutUint16(record[4:], uint16(i))
+		binary.BigEndian.PutUint16(record[6:], 1)
+		binary.BigEndian.PutUint64(record[8:], uint64(size))
+		hash := sha1.Sum(data)
+		copy(record[16:], hash[:])
+
+		encrypted := make([]byte, alignUp(uint64(size), aes.BlockSize))
+		copy(encrypted, data)
+		var iv [aes.BlockSize]byte
+		binary.BigEndian.PutUint16(iv[:], uint16(i))
+		cipher.NewCBCEncrypter(block, iv[:]).CryptBlocks(encrypted, encrypted)
+		writeFile(fmt.Sprintf("%08X.app", 0x10+i), encrypted)
+	}
+	writeFile("title.tmd", append(append(tmd, cp...), ca...))
+
+	if report, err := VerifyTitle(title, nil); err != nil || !report.OK() {
+		t.Fatalf("VerifyTitle of the generated title: %v", err)
+	}
+	wadPath := filepath.Join(dir, "title.wad")
+	if err := ExportWAD(title, wadPath, nil); err != nil {
+		t.Fatalf("ExportWAD: %v", err)
+	}
+	imported := filepath.Join(dir, "imported")
+	parsed, err := ImportWAD(wadPath, imported, nil)
+	if err != nil {
+		t.Fatalf("ImportWAD: %v", err)
+	}
+	if parsed.TitleID != titleID || len(parsed.Contents) != len(sizes) {
+		t.Fatalf("ImportWAD returned title %016x with %d contents", parsed.TitleID, len(parsed.Contents))
+	}
+	report, err := VerifyTitle(imported, nil)
+	if err != nil {
+		t.Fatalf("VerifyTitle: %v", err)
+	}
+	if !report.OK() {
+		t.Fatalf("VerifyTitle failed contents: %v", report.Failed())
+	}
+	if got, err := os.ReadFile(filepath.Join(imported, "title.tik")); err != nil || !bytes.Equal(got, ticket) {
+		t.Errorf("imported title.tik differs from the ticket without certificates")
+	}
+
+	rebuilt := filepath.Join(dir, "rebuilt.wad")
+	if err := ExportWAD(imported, rebuilt, nil); err != nil {
+		t.Fatalf("ExportWAD of the imported title: %v", err)
+	}
+	want, err := os.ReadFile(wadPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := os.ReadFile(rebuilt); err != nil || !bytes.Equal(got, want) {
+		t.Error("WAD built from the imported title differs")
+	}
+}
@@ -7,6 +7,10 @@ import (
 
 const WRITER_PROGRESS_FLUSH_INTERVAL = 100 * time.Millisecond
 
+// Progress is reported at most once per this many bytes processed without
+// downloading.
+const byteProgressInterval = 8 << 20
+
 type WriterProgress struct {
 	writer               io.Writer
 	progressReporter     ProgressReporter
@@ -26,11 +30,11 @@ func newWriterProgress(writer io.Writer, progressReporter ProgressReporter, file
 func (r *WriterProgress) Write(p []byte) (n int, err error) {
 	if waiter, ok := r.progressReporter.(pauseWaiter); ok {
 		if !waiter.WaitIfPaused() {
-			return 0, errCancel
+			return 0, ErrCancelled
 		}
 	}
 	if r.progressReporter != nil && r.progressReporter.Cancelled() {
-		return 0, errCancel
+		return 0, ErrCancelled
 	}
 	n, err = r.writer.Write(p)
 	if err != nil && err != io.EOF {
@@ -60,3 +64,26 @@ func (r *WriterProgress) flushPending() {
 		r.downloadToReport = 0
 	}
 }
+
+// byteProgress turns the bytes processed by an operation without downloads,
+// such as WriteWUA, into decryption progress.
+type byteProgress struct {
+	reporter ProgressReporter
+	total    uint64
+	done     uint64
+	reported uint64
+}
+
+// add counts n processed bytes and reports whether the operation was
+// cancelled.
+func (p *byteProgress) add(n uint64) bool {
+	p.done += n
+	if p.reporter == nil || p.done-p.reported < byteProgressInterval {
+		return false
+	}
+	p.reported = p.done
+	if p.total > 0 {
+		p.reporter.UpdateDecryptionProgress(float64(p.done) / float64(p.total))
+	}
+	return p.reporter.Cancelled()
+}
@@ -0,0 +1,193 @@
+package wiiudownloader
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+
+	"github.com/Xpl0itU/WiiUDownloader/internal/formats/zarchive"
+)
+
+// WUATitle describes a title folder written into a .wua archive.
+type WUATitle struct {
+	Source       string
+	TitleID      uint64
+	TitleVersion uint16
+	// Folder is the top-level folder of the archive holding the title, named
+	// "<title ID>_v<version>" as Cemu expects.
+	Folder string
+}
+
+type wuaSource struct {
+	WUATitle
+	fsys  fs.FS
+	files []string
+	size  uint64
+}
+
+// ExportWUA writes the title folders into a Cemu .wua archive at dest, which
+// is only replaced once the archive is complete. See WriteWUA.
+func ExportWUA(dest string, folders []string, progressReporter ProgressReporter) ([]WUATitle, error) {
+	tmp, err := os.CreateTemp(filepath.Dir(dest), filepath.Base(dest)+".*.tmp")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	if err := tmp.Chmod(downloadFilePerm); err != nil {
+		tmp.Close()
+		return nil, err
+	}
+	buffered := bufio.NewWriterSize(tmp, BLOCK_SIZE_HASHED)
+	titles, err := WriteWUA(buffered, folders, progressReporter)
+	if err == nil {
+		err = buffered.Flush()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	return titles, os.Rename(tmp.Name(), dest)
+}
+
+// WriteWUA writes the title folders as a Cemu .wua archive, a ZArchive with
+// one top-level folder per title, to w. Folders can be decrypted, or encrypted
+// in which case their files are decrypted as they are read through TitleFS, so
+// the files are streamed into the archive without being written to disk. A game
+// is stored before its updates and DLC, as in InstallCemuTitles.
+// ErrCancelled is returned when the progress reporter is cancelled.
+func WriteWUA(w io.Writer, folders []string, progressReporter ProgressReporter) ([]WUATitle, error) {
+	var sources []*wuaSource
+	var total uint64
+	for _, folder := range folders {
+		source, err := openWUASource(folder)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", folder, err)
+		}
+		sources = append(sources, source)
+		total += source.size
+	}
+	slices.SortStableFunc(sources, func(a, b *wuaSource) int {
+		if order := cemuInstallOrder(a.TitleID) - cemuInstallOrder(b.TitleID); order != 0 {
+			return order
+		}
+		return int(a.TitleVersion) - int(b.TitleVersion)
+	})
+
+	archive, err := zarchive.NewWriter(w)
+	if err != nil {
+		return nil, err
+	}
+	progress := &byteProgress{reporter: progressReporter, total: total}
+	titles := make([]WUATitle, len(sources))
+	for i, source := range sources {
+		if err := archive.Mkdir(source.Folder); err != nil {
+			return nil, err
+		}
+		for _, name := range source.files {
+			if isCancelled(progressReporter) {
+				return nil, ErrCancelled
+			}
+			if err := archiveFile(archive, source, name, progress); err != nil {
+				return nil, fmt.Errorf("%s: %w", source.Source, err)
+			}
+		}
+		titles[i] = source.WUATitle
+	}
+	if err := archive.Close(); err != nil {
+		return nil, err
+	}
+	if progressReporter != nil {
+		progressReporter.UpdateDecryptionProgress(1.0)
+	}
+	return titles, nil
+}
+
+// openWUASource lists the files of a decrypted title folder, or of the title
+// in an encrypted one.
+func openWUASource(folder string) (*wuaSource, error) {
+	source := &wuaSource{WUATitle: WUATitle{Source: folder}}
+	titleID, titleVersion, err := decryptedTitleInfo(folder)
+	if err == nil {
+		source.fsys = os.DirFS(folder)
+	} else {
+		if !errors.Is(err, errNotDecrypted) {
+			return nil, err
+		}
+		title, err := OpenTitleFS(folder)
+		if err != nil {
+			return nil, err
+		}
+		source.fsys = title
+		titleID, titleVersion = title.TMD().TitleID, title.TMD().TitleVersion
+	}
+	source.TitleID = titleID
+	source.TitleVersion = titleVersion
+	source.Folder = fmt.Sprintf("%016x_v%d", titleID, titleVersion)
+
+	for _, dir := range cemuTitleDirectories {
+		if _, err := fs.Stat(source.fsys, dir); errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		err := fs.WalkDir(source.fsys, dir, func(name string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if entry.IsDir() {
+				return nil
+			}
+			if !entry.Type().IsRegular() {
+				return fmt.Errorf("%s is not a regular file", name)
+			}
+			info, err := entry.Info()
+			if err != nil {
+				return err
+			}
+			source.files = append(source.files, name)
+			source.size += uint64(info.Size())
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return source, nil
+}
+
+func archiveFile(archive *zarchive.Writer, source *wuaSource, name string, progress *byteProgress) error {
+	dst, err := archive.Create(path.Join(source.Folder, name))
+	if err != nil {
+		return err
+	}
+	src, err := source.fsys.Open(name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	buf := make([]byte, BLOCK_SIZE_HASHED)
+	for {
+		n, err := src.Read(buf)
+		if n > 0 {
+			if _, err := dst.Write(buf[:n]); err != nil {
+				return err
+			}
+			if progress.add(uint64(n)) {
+				return ErrCancelled
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
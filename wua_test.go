@@ -0,0 +1,91 @@
+package wiiudownloader
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"path"
+	"testing"
+
+	"github.com/Xpl0itU/WiiUDownloader/internal/formats/zarchive"
+	"github.com/klauspost/compress/zstd"
+)
+
+// readTestZArchive returns the files of a ZArchive by path.
+func readTestZArchive(t *testing.T, data []byte) map[string][]byte {
+	t.Helper()
+	const footerSize = 6*16 + sha256.Size + 8 + 4 + 4
+	if len(data) < footerSize {
+		t.Fatalf("archive is %d bytes", len(data))
+	}
+	footer := data[len(data)-footerSize:]
+	if binary.BigEndian.Uint32(footer[footerSize-4:]) != zarchive.Magic || binary.BigEndian.Uint32(footer[footerSize-8:]) != zarchive.Version1 {
+		t.Fatal("archive footer has the wrong magic or version")
+	}
+	if binary.BigEndian.Uint64(footer[footerSize-16:]) != uint64(len(data)) {
+		t.Fatal("archive footer has the wrong size")
+	}
+	hashed := bytes.Clone(data)
+	clear(hashed[len(data)-footerSize+6*16:][:sha256.Size])
+	if sum := sha256.Sum256(hashed); !bytes.Equal(sum[:], footer[6*16:][:sha256.Size]) {
+		t.Fatal("archive integrity hash does not match")
+	}
+	section := func(i int) []byte {
+		offset := binary.BigEndian.Uint64(footer[i*16:])
+		size := binary.BigEndian.Uint64(footer[i*16+8:])
+		return data[offset : offset+size]
+	}
+	compressed, records, names, tree := section(0), section(1), section(2), section(3)
+
+	decoder, err := zstd.NewReader(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer decoder.Close()
+	var contents []byte
+	for record := 0; record*(8+2*16) < len(records); record++ {
+		entry := records[record*(8+2*16):]
+		offset := binary.BigEndian.Uint64(entry)
+		for i := 0; i < 16 && offset < uint64(len(compressed)); i++ {
+			size := uint64(binary.BigEndian.Uint16(entry[8+i*2:])) + 1
+			block := compressed[offset : offset+size]
+			if size != zarchive.BlockSize {
+				if block, err = decoder.DecodeAll(block, nil); err != nil {
+					t.Fatalf("block %d: %v", record*16+i, err)
+				}
+			}
+			contents = append(contents, block...)
+			offset += size
+		}
+	}
+
+	name := func(offset uint32) string {
+		length := uint32(names[offset])
+		offset++
+		if length&0x80 != 0 {
+			length = length&0x7F | uint32(names[offset])<<7
+			offset++
+		}
+		return string(names[offset : offset+length])
+	}
+	files := make(map[string][]byte)
+	var walk func(index uint32, dir string)
+	walk = func(index uint32, dir string) {
+		entry := tree[index*16:]
+		first, count := binary.BigEndian.Uint32(entry[4:]), binary.BigEndian.Uint32(entry[8:])
+		for child := first; child < first+count; child++ {
+			entry := tree[child*16:]
+			nameOffset := binary.BigEndian.Uint32(entry)
+			childPath := path.Join(dir, name(nameOffset&^0x80000000))
+			if nameOffset&0x80000000 == 0 {
+				walk(child, childPath)
+				continue
+			}
+			offset := uint64(binary.BigEndian.Uint32(entry[4:])) | uint64(binary.BigEndian.Uint16(entry[14:]))<<32
+			size := uint64(binary.BigEndian.Uint32(entry[8:])) | uint64(binary.BigEndian.Uint16(entry[12:]))<<32
+			files[childPath] = contents[offset : offset+size]
+		}
+	}
+	walk(0, "")
+	return files
+}